@@ -1,7 +1,55 @@
 package constants
 
+import "time"
+
+// defaults, both can be changed at runtime through storage.Options
 const PageSize int = 4096
 const BufferPoolSize int = 500
 
+// ---------------------------- Page header layout ------------------------
+// byte 0 is the page flag set on a new page, the page lsn is kept on the page so that redo can check it after a crash
+// the checksum is stamped by the disk manager on every page write and verified on every page read
+const PageLSNOffset int = 8
+const PageChecksumOffset int = 16
+const PageHeaderSize int = 24
+
+// ---------------------------- Replacer configs ------------------------
+// default lru-k history size and correlated reference period (references closer than the period count as one)
+// the period is counted in ticks of the pool's logical clock, 0 counts every reference
+const LrukK int = 4
+const LrukCorrelatedPeriod int64 = 0
+
+// ---------------------------- Access strategy configs ------------------------
+// ring sizes of the scan and bulk write access strategies, in frames, a ring never takes more than an eighth of the pool
+const ScanRingFrames int = 32
+const BulkWriteRingFrames int = 256
+
+// ---------------------------- Prefetch configs ------------------------
+// default number of prefetch goroutines of a pool, and the number of prefetch requests (runs of neighbouring pages) that can wait for them
+const PrefetchWorkers int = 4
+const PrefetchQueueSize int = 256
+
+// read-ahead starts once this many fetches in a row were for ascending pages, by default it reads this many pages ahead
+// but never more than an eighth of the pool
+const ReadAheadTrigger int = 3
+const ReadAheadPages int = 16
+
+// ---------------------------- Background writer configs ------------------------
+// default time between two rounds of the background writer, and the share of dirty frames it starts writing at.
+// by default a round writes this many pages but never more than an eighth of the pool
+const BgWriterInterval time.Duration = 200 * time.Millisecond
+const BgWriterDirtyRatio float64 = 0.1
+const BgWriterPages int = 32
+
+// ---------------------------- Disk manager configs ------------------------
+// default time between the syncs of the db file under the periodic sync policy
+const SyncInterval time.Duration = time.Second
+
+// pages moved by one read or write of WritePages and ReadPages at most
+const IORunPages int = 64
+
 // ---------------------------- Hash table configs ------------------------
 const MaxBucketSize = 10
+
+// ---------------------------- Log manager configs ------------------------
+const LogBufferSize int = 16 * PageSize
@@ -1,12 +1,17 @@
 package diskmgr
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/cespare/xxhash/v2"
 	"github.com/rohithputha/HymStMgr/constants"
 )
 
@@ -17,111 +22,458 @@ func fileFormatCheck(filePath, fileFormat string) bool {
 	return strings.HasSuffix(filePath, fileFormat)
 }
 
+/*
+page reads and writes use positional i/o (pread/pwrite) and run in parallel without a lock, the os orders the i/o on one file.
+only the writes that grow the file and AllocatePage take growMux, so dbFileSize only moves on once the pages up to it are written
+and a read never sees a page that is still being appended. the file on disk can be longer than dbFileSize, see extendFile.
+writes of the same page at the same time are not ordered, the buffer pool latches the pages.
+mux guards the superblock and the free list, logMux the log file.
+*/
 type DiskFileMetaData struct {
 	DbFilePath  string
 	LogFilePath string
 	dbFile      *(os.File)
 	logFile     *(os.File)
-	dbFileSize  int64
+	dbFileSize  atomic.Int64 // the end of the last allocated page
+	logFileSize int64        // the log offset past the last log byte, see LogHeaderSize
+	logStart    int64        // the log offset of the first log byte that is not discarded
+	pageSize    int
+	syncPolicy  SyncPolicy
+	superblock  Superblock
+	closed      atomic.Bool // set under both mux and logMux
 	mux         *sync.Mutex
+	growMux     *sync.Mutex // taken by the writes past the end of the file, guards fileCapacity
+	syncMux     *sync.Mutex // orders the syncs of the db file, so a sync after a failed one returns its error
+	logMux      *sync.Mutex
+
+	// a failed fsync can drop the dirty pages of the file from the os page cache, so the first failure is kept and every
+	// later call on that file fails with it instead of reading or syncing over the lost writes
+	dbFileErr  atomic.Pointer[error] // read without a lock by the page reads and writes, set under syncMux
+	logFileErr error                 // guarded by logMux
+
+	logGeneration uint64 // the generation of the newest copy of the log header, guarded by logMux
+
+	fileCapacity  int64 // the size of the file on disk, the pages past dbFileSize up to it are preallocated and not written yet
+	preallocPages int
+
+	syncInterval time.Duration
+	syncStop     chan struct{} // closed by Close to stop the periodic sync, nil for the other policies
+	syncDone     chan struct{}
+	stopSyncOnce *sync.Once
 }
 
+/*
+DiskFileInit is what GetDiskFileMgr opens, a zero PageSize is constants.PageSize.
+the page size is fixed for the life of the db file, the superblock rejects a different one on open.
+*/
 type DiskFileInit struct {
-	DbFilePath  string
-	LogFilePath string
+	DbFilePath   string
+	LogFilePath  string
+	PageSize     int
+	SyncPolicy   SyncPolicy
+	SyncInterval time.Duration // time between the syncs of SyncPeriodic, 0 is constants.SyncInterval
+	// pages the file is grown by at once when AllocatePage runs past the space on disk, 0 grows it one page at a time
+	PreallocPages int
+}
+
+// SyncPolicy is when page writes are fsynced, the log file is synced on every WriteLog whatever the policy
+type SyncPolicy int
+
+const (
+	SyncEveryWrite SyncPolicy = iota // WritePage syncs every page it writes
+	SyncOnFlush                      // only Sync (flush all pages, close) syncs the pages
+	SyncPeriodic                     // a goroutine syncs the pages every SyncInterval, Sync and Close still sync them too
+	SyncNever                        // the db file is never synced, for tests and bulk loads that are redone from scratch after a crash
+)
+
+// the page size has to fit the page header and the two copies of the superblock and be a power of two (so that pages stay aligned to the disk sectors)
+const minPageSize int = 512
+
+func validPageSize(pageSize int) bool {
+	return pageSize >= minPageSize && pageSize&(pageSize-1) == 0
 }
 
 type DiskFileMgr interface {
-	init()
+	init() (initErr error)
 	WritePage(pageId int, writeData []byte) (writeErr error)
+	WritePageNoSync(pageId int, writeData []byte) (writeErr error)
+	WritePages(pageIds []int, pagesData [][]byte) (writeErr error)
+	Sync() (syncErr error)
 	ReadPage(pageId int, readData []byte) (readErr error)
+	ReadPages(pageIds []int, readData [][]byte) (readErrs []error)
 	GetPageCount() int
+	GetPageSize() int
+	AllocatePage() (pageId int, allocErr error)
+	DeallocatePage(pageId int) (deallocErr error)
+	WriteLog(logData []byte) (writeErr error)
+	ReadLog(readData []byte, offset int64) (numRead int, readErr error)
+	GetLogSize() int64
+	GetLogStart() int64
+	TruncateLogTail(logSize int64) (truncErr error)
+	DiscardLogPrefix(offset int64) (discardErr error)
+	Close() (closeErr error)
+	GetSuperblock() Superblock
+	SetCatalogRoot(pageId int) (sbErr error)
 }
 
-func GetDiskFileMgr(init DiskFileInit) DiskFileMgr {
+// GetDiskFileMgr opens (or creates) the db and log files, the files are closed again if anything in the open fails
+func GetDiskFileMgr(init DiskFileInit) (diskMgr DiskFileMgr, initErr error) {
+	if init.PageSize == 0 {
+		init.PageSize = constants.PageSize
+	}
+	if init.SyncInterval == 0 {
+		init.SyncInterval = constants.SyncInterval
+	}
+	if !validPageSize(init.PageSize) {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidPageSize, init.PageSize)
+	}
+	if init.SyncPolicy < SyncEveryWrite || init.SyncPolicy > SyncNever || init.SyncInterval < 0 {
+		return nil, fmt.Errorf("%w: policy %d with interval %v", ErrInvalidSyncPolicy, init.SyncPolicy, init.SyncInterval)
+	}
+	if init.PreallocPages < 0 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidPreallocPages, init.PreallocPages)
+	}
 	diskFileMd := DiskFileMetaData{
-		DbFilePath:  init.DbFilePath,
-		LogFilePath: init.LogFilePath,
-		mux:         &sync.Mutex{},
+		DbFilePath:    init.DbFilePath,
+		LogFilePath:   init.LogFilePath,
+		pageSize:      init.PageSize,
+		syncPolicy:    init.SyncPolicy,
+		syncInterval:  init.SyncInterval,
+		preallocPages: init.PreallocPages,
+		mux:           &sync.Mutex{},
+		growMux:       &sync.Mutex{},
+		syncMux:       &sync.Mutex{},
+		logMux:        &sync.Mutex{},
+		stopSyncOnce:  &sync.Once{},
 	}
-	(&diskFileMd).init()
-	return &diskFileMd
+	if initErr = (&diskFileMd).init(); initErr != nil {
+		if diskFileMd.dbFile != nil {
+			diskFileMd.dbFile.Close()
+		}
+		if diskFileMd.logFile != nil {
+			diskFileMd.logFile.Close()
+		}
+		return nil, initErr
+	}
+	if diskFileMd.syncPolicy == SyncPeriodic {
+		diskFileMd.syncStop = make(chan struct{})
+		diskFileMd.syncDone = make(chan struct{})
+		go diskFileMd.periodicSync()
+	}
+	return &diskFileMd, nil
 }
 
-func (dm *DiskFileMetaData) init() {
-	var err error
-	if !fileFormatCheck(dm.DbFilePath, dbFileFormat) {
-		panic("database file format incorrect!")
+// periodicSync syncs the db file every syncInterval till Close, a failed sync is kept in dbFileErr and returned by the next call
+func (dm *DiskFileMetaData) periodicSync() {
+	defer close(dm.syncDone)
+	ticker := time.NewTicker(dm.syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-dm.syncStop:
+			return
+		case <-ticker.C:
+			dm.Sync()
+		}
 	}
-	dm.dbFile, err = os.OpenFile(dm.DbFilePath, os.O_CREATE|os.O_RDWR, 0644)
-	if err != nil {
-		panic(err)
+}
+
+// stopPeriodicSync stops the periodic sync and waits for a sync it is running, dm.mux should not be held
+func (dm *DiskFileMetaData) stopPeriodicSync() {
+	if dm.syncStop == nil {
+		return
 	}
+	dm.stopSyncOnce.Do(func() { close(dm.syncStop) })
+	<-dm.syncDone
+}
 
-	if !fileFormatCheck(dm.LogFilePath, logFileFormat) {
-		panic("log file format incorrect!")
+// syncDbFile syncs the db file unless the policy is SyncNever
+func (dm *DiskFileMetaData) syncDbFile() (syncErr error) {
+	dm.syncMux.Lock()
+	defer dm.syncMux.Unlock()
+	if syncErr = dm.dbFileFailed(); syncErr != nil || dm.syncPolicy == SyncNever {
+		return syncErr
 	}
-	dm.logFile, err = os.OpenFile(dm.LogFilePath, os.O_CREATE|os.O_APPEND, 0644)
-	if err != nil {
-		panic(err)
+	if syncErr = dm.dbFile.Sync(); syncErr != nil {
+		// a write that ran into Close syncs a closed file, that is not a failed sync
+		if errors.Is(dm.dbFileErrOf(syncErr), ErrClosed) {
+			return ErrClosed
+		}
+		syncErr = fmt.Errorf("%w for %s: %w", ErrSyncFailed, dm.DbFilePath, syncErr)
+		dm.dbFileErr.Store(&syncErr)
 	}
+	return syncErr
+}
 
-	dbFileInfo, err := dm.dbFile.Stat()
-	if err != nil {
-		panic("db file stats not available")
+// dbFileFailed returns the failed sync of the db file, nil if no sync failed
+func (dm *DiskFileMetaData) dbFileFailed() (syncErr error) {
+	if failedErr := dm.dbFileErr.Load(); failedErr != nil {
+		return *failedErr
 	}
-	dm.dbFileSize = dbFileInfo.Size()
+	return nil
+}
 
+// syncLogFile syncs the log file, dm.logMux should be held
+func (dm *DiskFileMetaData) syncLogFile() (syncErr error) {
+	if dm.logFileErr != nil {
+		return dm.logFileErr
+	}
+	if syncErr = dm.logFile.Sync(); syncErr != nil {
+		dm.logFileErr = fmt.Errorf("%w for %s: %w", ErrSyncFailed, dm.LogFilePath, syncErr)
+	}
+	return dm.logFileErr
 }
 
-// WritePage should take byte data for a page id and write at the offset of the pageId.
-func (dm *DiskFileMetaData) WritePage(pageId int, writeData []byte) (writeErr error) {
+// checkDbFile returns ErrClosed after Close and the failed sync after a sync of the db file failed
+func (dm *DiskFileMetaData) checkDbFile() (checkErr error) {
+	if dm.closed.Load() {
+		return ErrClosed
+	}
+	return dm.dbFileFailed()
+}
+
+// checkLogFile is checkDbFile for the log file, dm.logMux should be held
+func (dm *DiskFileMetaData) checkLogFile() (checkErr error) {
+	if dm.closed.Load() {
+		return ErrClosed
+	}
+	return dm.logFileErr
+}
+
+// dbFileErrOf returns ErrClosed for the i/o that Close ran into, the page i/o checks closed before it starts but does not hold a lock
+func (dm *DiskFileMetaData) dbFileErrOf(ioErr error) error {
+	if errors.Is(ioErr, os.ErrClosed) && dm.closed.Load() {
+		return ErrClosed
+	}
+	return ioErr
+}
+
+/*
+Close syncs and closes the db and log files, the files are closed even if a sync fails (or failed before).
+every call after Close (Close included) returns ErrClosed.
+*/
+func (dm *DiskFileMetaData) Close() (closeErr error) {
+	dm.stopPeriodicSync()
 	dm.mux.Lock()
 	defer dm.mux.Unlock()
+	dm.logMux.Lock()
+	defer dm.logMux.Unlock()
 
-	if len(writeData) < constants.PageSize {
-		return errors.New("write page size less than the actual page size defined")
+	if dm.closed.Load() {
+		return ErrClosed
 	}
-	appendMode := false
-	offset := int64(pageId * constants.PageSize)
+	dm.closed.Store(true)
+	closeErr = errors.Join(dm.syncDbFile(), dm.syncLogFile())
+	return errors.Join(closeErr, dm.dbFile.Close(), dm.logFile.Close())
+}
+
+func (dm *DiskFileMetaData) init() (initErr error) {
+	if !fileFormatCheck(dm.DbFilePath, dbFileFormat) {
+		return fmt.Errorf("%w: %s should end with %s", ErrInvalidDbFilePath, dm.DbFilePath, dbFileFormat)
+	}
+	if !fileFormatCheck(dm.LogFilePath, logFileFormat) {
+		return fmt.Errorf("%w: %s should end with %s", ErrInvalidLogFilePath, dm.LogFilePath, logFileFormat)
+	}
+	if dm.dbFile, initErr = os.OpenFile(dm.DbFilePath, os.O_CREATE|os.O_RDWR, 0644); initErr != nil {
+		return initErr
+	}
+	if dm.logFile, initErr = os.OpenFile(dm.LogFilePath, os.O_CREATE|os.O_RDWR, 0644); initErr != nil {
+		return initErr
+	}
+
+	dbFileInfo, initErr := dm.dbFile.Stat()
+	if initErr != nil {
+		return fmt.Errorf("db file stats not available: %w", initErr)
+	}
+	dm.fileCapacity = dbFileInfo.Size()
+	dbFileSize, initErr := dm.allocatedFileSize(dm.fileCapacity)
+	if initErr != nil {
+		return initErr
+	}
+	dm.dbFileSize.Store(dbFileSize)
 
-	if offset == dm.dbFileSize {
-		appendMode = true
+	logFileInfo, initErr := dm.logFile.Stat()
+	if initErr != nil {
+		return fmt.Errorf("log file stats not available: %w", initErr)
 	}
-	if offset > dm.dbFileSize {
-		return errors.New("page failed to be appended after the EOF")
+	if initErr = dm.loadLogHeader(logFileInfo.Size()); initErr != nil {
+		return initErr
 	}
 
-	_, writeErr = dm.dbFile.WriteAt(writeData, offset)
-	dm.dbFile.Sync()
+	return dm.loadSuperblock()
+}
+
+// WritePage should take byte data for a page id and write at the offset of the pageId.
+func (dm *DiskFileMetaData) WritePage(pageId int, writeData []byte) (writeErr error) {
+	return dm.writeDataPage(pageId, writeData, true)
+}
 
-	if writeErr == nil && appendMode {
-		dm.dbFileSize += int64(constants.PageSize)
+// WritePageNoSync is WritePage without the fsync, the page is durable only after a later Sync
+func (dm *DiskFileMetaData) WritePageNoSync(pageId int, writeData []byte) (writeErr error) {
+	return dm.writeDataPage(pageId, writeData, false)
+}
+
+/*
+Sync makes every page written so far durable, under SyncNever it does nothing.
+once a sync failed the pages written before it can not be trusted to be on disk, it and every later call on the db file return ErrSyncFailed.
+*/
+func (dm *DiskFileMetaData) Sync() (syncErr error) {
+	if syncErr = dm.checkDbFile(); syncErr != nil {
+		return syncErr
+	}
+	return dm.syncDbFile()
+}
+
+func (dm *DiskFileMetaData) writeDataPage(pageId int, writeData []byte, sync bool) (writeErr error) {
+	if writeErr = dm.checkDbFile(); writeErr != nil {
+		return writeErr
+	}
+	if len(writeData) < dm.pageSize {
+		return ErrPageBufferTooSmall
+	}
+	if pageId <= SuperblockPageId {
+		return ErrReservedPage
+	}
+	if writeErr = dm.writePage(pageId, writeData); writeErr == nil && sync && dm.syncPolicy == SyncEveryWrite {
+		writeErr = dm.syncDbFile()
 	}
 	return writeErr
 }
 
+// writePage does the actual write for the data pages and the superblock without a sync
+func (dm *DiskFileMetaData) writePage(pageId int, writeData []byte) (writeErr error) {
+	return dm.writeRun(pageId, [][]byte{writeData})
+}
+
+// ReadPage reads the page into read, a page on the free list is not found (ErrPageNotFound together with ErrPageFree)
 func (dm *DiskFileMetaData) ReadPage(pageId int, read []byte) (readErr error) {
-	dm.mux.Lock()
-	defer dm.mux.Unlock()
+	if readErr = dm.checkDbFile(); readErr != nil {
+		return readErr
+	}
+	readErrs := make([]error, 1)
+	dm.readRun(pageId, [][]byte{read}, readErrs)
+	return readErrs[0]
+}
 
-	offset := int64(pageId * constants.PageSize)
-	if dm.dbFileSize == 0 || offset > dm.dbFileSize {
-		return errors.New("read page not present")
+// pageChecksum is the xxhash of the page id and the page bytes, leaving out the checksum itself.
+// the page id is part of it so that a page written at the wrong offset also fails the check, pageData should be exactly one page
+func pageChecksum(pageId int, pageData []byte) uint64 {
+	var pageIdBytes [8]byte
+	binary.LittleEndian.PutUint64(pageIdBytes[:], uint64(pageId))
+	digest := xxhash.New()
+	digest.Write(pageIdBytes[:])
+	digest.Write(pageData[:constants.PageChecksumOffset])
+	digest.Write(pageData[constants.PageChecksumOffset+8:])
+	return digest.Sum64()
+}
+
+func stampPageChecksum(pageId int, pageData []byte) {
+	binary.LittleEndian.PutUint64(pageData[constants.PageChecksumOffset:], pageChecksum(pageId, pageData))
+}
+
+func verifyPageChecksum(pageId int, pageData []byte) bool {
+	return binary.LittleEndian.Uint64(pageData[constants.PageChecksumOffset:]) == pageChecksum(pageId, pageData)
+}
+
+func (dm *DiskFileMetaData) GetPageCount() (numPages int) {
+	return int(dm.dbFileSize.Load() / int64(dm.pageSize))
+}
+
+func (dm *DiskFileMetaData) GetPageSize() int {
+	return dm.pageSize
+}
+
+// WriteLog appends the log bytes at the end of the log and syncs the log file.
+// the log is append only, so there is no offset here unlike WritePage
+func (dm *DiskFileMetaData) WriteLog(logData []byte) (writeErr error) {
+	dm.logMux.Lock()
+	defer dm.logMux.Unlock()
+
+	if writeErr = dm.checkLogFile(); writeErr != nil {
+		return writeErr
+	}
+	numWritten, writeErr := dm.logFile.WriteAt(logData, LogHeaderSize+dm.logFileSize)
+	dm.logFileSize += int64(numWritten)
+	if writeErr != nil {
+		return writeErr
 	}
+	return dm.syncLogFile()
+}
 
-	numRead, readErr := dm.dbFile.ReadAt(read, offset)
-	if readErr != nil {
-		return readErr
+/*
+ReadLog reads the log bytes from the offset into readData. numRead can be less than len(readData) at the end of the log.
+an offset before the log start is discarded, it fails with ErrLogDiscarded.
+*/
+func (dm *DiskFileMetaData) ReadLog(readData []byte, offset int64) (numRead int, readErr error) {
+	dm.logMux.Lock()
+	defer dm.logMux.Unlock()
+
+	if readErr = dm.checkLogFile(); readErr != nil {
+		return 0, readErr
+	}
+	if offset < dm.logStart {
+		return 0, fmt.Errorf("%w: offset %d, the log starts at %d", ErrLogDiscarded, offset, dm.logStart)
 	}
-	if numRead < constants.PageSize {
-		return errors.New("number of bytes read is not equal to the pagesize for pageId:" + fmt.Sprintf("%d", pageId))
+	if offset >= dm.logFileSize {
+		return 0, io.EOF
 	}
-	return readErr
+	return dm.logFile.ReadAt(readData, LogHeaderSize+offset)
 }
 
-func (dm *DiskFileMetaData) GetPageCount() (numPages int) {
-	return int((dm.dbFileSize) / int64(constants.PageSize))
+// GetLogSize returns the log offset past the last log byte, the discarded prefix counts too (see GetLogStart)
+func (dm *DiskFileMetaData) GetLogSize() int64 {
+	dm.logMux.Lock()
+	defer dm.logMux.Unlock()
+	return dm.logFileSize
+}
+
+// GetLogStart returns the log offset of the first log byte that is not discarded, the log is read from there
+func (dm *DiskFileMetaData) GetLogStart() int64 {
+	dm.logMux.Lock()
+	defer dm.logMux.Unlock()
+	return dm.logStart
 }
 
-//More Functions to be added here to add data to WAL
+// TruncateLogTail cuts the log down to logSize, used to drop a torn record at the end of the log after a crash.
+func (dm *DiskFileMetaData) TruncateLogTail(logSize int64) (truncErr error) {
+	dm.logMux.Lock()
+	defer dm.logMux.Unlock()
+
+	if truncErr = dm.checkLogFile(); truncErr != nil {
+		return truncErr
+	}
+	if logSize > dm.logFileSize || logSize < dm.logStart {
+		return fmt.Errorf("%w: truncate to %d, the log is from %d to %d", ErrLogOutOfRange, logSize, dm.logStart, dm.logFileSize)
+	}
+	if truncErr = dm.logFile.Truncate(LogHeaderSize + logSize); truncErr != nil {
+		return truncErr
+	}
+	dm.logFileSize = logSize
+	return dm.syncLogFile()
+}
+
+/*
+DiscardLogPrefix drops the log bytes before offset, the log then starts at offset (offsets of the bytes after it stay the same).
+the new start is written to the log header and synced first, then the prefix is punched out of the file, so a crash in between
+only leaves the prefix on disk. only the header is written under logMux, the rest of the log is not read or copied.
+*/
+func (dm *DiskFileMetaData) DiscardLogPrefix(offset int64) (discardErr error) {
+	dm.logMux.Lock()
+	defer dm.logMux.Unlock()
+
+	if discardErr = dm.checkLogFile(); discardErr != nil {
+		return discardErr
+	}
+	if offset > dm.logFileSize {
+		return fmt.Errorf("%w: discard up to %d, the log ends at %d", ErrLogOutOfRange, offset, dm.logFileSize)
+	}
+	if offset <= dm.logStart {
+		return nil
+	}
+	if discardErr = dm.writeLogStart(offset); discardErr != nil {
+		return discardErr
+	}
+	prevStart := dm.logStart
+	dm.logStart = offset
+	return punchHole(dm.logFile, LogHeaderSize+prevStart, offset-prevStart)
+}
@@ -0,0 +1,28 @@
+// Package disktest has the helpers shared by the tests of the disk manager and of the packages built on it
+package disktest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/rohithputha/HymStMgr/diskmgr"
+)
+
+// GetFileInit returns a DiskFileInit for a db and a log file in a temp dir of the test, the files go away with the dir
+func GetFileInit(test testing.TB) diskmgr.DiskFileInit {
+	dir := test.TempDir()
+	return diskmgr.DiskFileInit{
+		DbFilePath:  filepath.Join(dir, "dbtest.db"),
+		LogFilePath: filepath.Join(dir, "dblogtest.log"),
+	}
+}
+
+// GetDiskMgr opens a disk manager on the files and closes it when the test ends
+func GetDiskMgr(test testing.TB, d diskmgr.DiskFileInit) diskmgr.DiskFileMgr {
+	diskMgr, initErr := diskmgr.GetDiskFileMgr(d)
+	if initErr != nil {
+		test.Fatalf("disk file mgr failed to open: %v", initErr)
+	}
+	test.Cleanup(func() { diskMgr.Close() })
+	return diskMgr
+}
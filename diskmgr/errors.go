@@ -0,0 +1,42 @@
+package diskmgr
+
+import "errors"
+
+// errors returned by the disk manager, callers should match them with errors.Is as most are wrapped with the pageId or the file path
+var (
+	ErrInvalidDbFilePath    = errors.New("database file format incorrect")
+	ErrInvalidLogFilePath   = errors.New("log file format incorrect")
+	ErrInvalidPageSize      = errors.New("page size should be a power of two and at least 512 bytes")
+	ErrInvalidSyncPolicy    = errors.New("unknown sync policy or negative sync interval")
+	ErrInvalidPreallocPages = errors.New("preallocated pages can not be negative")
+
+	ErrNotDbFile           = errors.New("file is not a db file")
+	ErrIncompatibleVersion = errors.New("db file format version is not supported")
+	ErrPageSizeMismatch    = errors.New("db file page size does not match the configured page size")
+	ErrNotLogFile          = errors.New("file is not a log file")
+
+	// ErrLogDiscarded is returned by ReadLog for an offset in the discarded prefix of the log, before GetLogStart
+	ErrLogDiscarded = errors.New("log offset is discarded")
+	// ErrLogOutOfRange is returned by TruncateLogTail and DiscardLogPrefix for an offset outside the log
+	ErrLogOutOfRange = errors.New("log offset is out of range")
+
+	ErrPageNotFound       = errors.New("read page not present")
+	ErrPageBeyondEOF      = errors.New("page failed to be appended after the EOF")
+	ErrPageBufferTooSmall = errors.New("write page size less than the actual page size defined")
+	ErrShortRead          = errors.New("number of bytes read is not equal to the pagesize")
+	ErrReservedPage       = errors.New("page 0 is reserved for the superblock")
+
+	// ErrPageCorrupted is returned by ReadPage when the checksum in the page header does not match the page bytes (torn or bad write)
+	ErrPageCorrupted = errors.New("page checksum mismatch")
+
+	// ErrClosed is returned by every call on a disk manager after Close
+	ErrClosed = errors.New("disk file mgr is closed")
+
+	// ErrSyncFailed is returned by the call whose fsync failed and by every later call on the same file, the file is not usable after it
+	ErrSyncFailed = errors.New("fsync failed")
+
+	// ErrPageFree is returned with ErrPageNotFound by ReadPage for a page that is on the free list
+	ErrPageFree          = errors.New("page is on the free list")
+	ErrPageAlreadyFree   = errors.New("page is already free")
+	ErrFreeListCorrupted = errors.New("free list is corrupted")
+)
@@ -0,0 +1,62 @@
+package diskmgr
+
+import "fmt"
+
+/*
+the db file grows at its end: AllocatePage takes the page after the last one and writes it blank, WritePage and WritePages can
+also append right after the last page. with preallocPages the file on disk is grown that many pages at a time, so that a new page
+does not grow the file (and its metadata) every time. the preallocated pages are zeroes, a written page (blank ones too) has a
+checksum, so the zero pages at the end of the file on open are preallocated ones and dbFileSize ends before them.
+*/
+
+// extendFile takes the page after the end of the file and writes it blank, dm.mux should be held
+func (dm *DiskFileMetaData) extendFile() (pageId int, extendErr error) {
+	dm.growMux.Lock()
+	defer dm.growMux.Unlock()
+
+	fileSize := dm.dbFileSize.Load()
+	pageId = int(fileSize / int64(dm.pageSize))
+	offset := int64(pageId * dm.pageSize)
+	pageEnd := offset + int64(dm.pageSize)
+	if pageEnd > dm.fileCapacity && dm.preallocPages > 0 {
+		capacity := offset + int64(dm.preallocPages*dm.pageSize)
+		if extendErr = preallocate(dm.dbFile, dm.fileCapacity, capacity-dm.fileCapacity); extendErr != nil {
+			return -1, dm.dbFileErrOf(extendErr)
+		}
+		dm.fileCapacity = capacity
+	}
+	pageData := make([]byte, dm.pageSize)
+	stampPageChecksum(pageId, pageData)
+	if _, extendErr = dm.dbFile.WriteAt(pageData, offset); extendErr != nil {
+		return -1, dm.dbFileErrOf(extendErr)
+	}
+	dm.dbFileSize.Store(max(fileSize, pageEnd))
+	dm.fileCapacity = max(dm.fileCapacity, pageEnd)
+	return pageId, nil
+}
+
+// allocatedFileSize returns the size of the file without the preallocated zero pages at its end, the superblock page is always kept
+func (dm *DiskFileMetaData) allocatedFileSize(fileSize int64) (allocatedSize int64, readErr error) {
+	if fileSize%int64(dm.pageSize) != 0 {
+		return fileSize, nil
+	}
+	pageData := make([]byte, dm.pageSize)
+	for allocatedSize = fileSize; allocatedSize > int64(dm.pageSize); allocatedSize -= int64(dm.pageSize) {
+		if _, readErr = dm.dbFile.ReadAt(pageData, allocatedSize-int64(dm.pageSize)); readErr != nil {
+			return 0, fmt.Errorf("db file end not readable: %w", readErr)
+		}
+		if !isZeroPage(pageData) {
+			break
+		}
+	}
+	return allocatedSize, nil
+}
+
+func isZeroPage(pageData []byte) bool {
+	for _, b := range pageData {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
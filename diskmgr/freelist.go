@@ -0,0 +1,97 @@
+package diskmgr
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/rohithputha/HymStMgr/constants"
+)
+
+/*
+freed pages are kept in a linked list on disk, the superblock holds the head and every free page holds the next free pageId.
+a free page carries freePageFlag in its flag byte and the max lsn in its header, so that recovery never redoes an old log record
+on a page that was freed after the record was written.
+*/
+const freePageFlag byte = 2
+const freePageNextOffset = constants.PageHeaderSize
+
+/*
+AllocatePage returns the pageId for a new page: the head of the free list if there is one, else the page right after the end of the file.
+the pageId is the caller's once this returns, a page from the free list is off the list and a page at the end is written blank
+(see extendFile), so a concurrent AllocatePage does not hand it out again. the caller should write the new page to it.
+*/
+func (dm *DiskFileMetaData) AllocatePage() (pageId int, allocErr error) {
+	dm.mux.Lock()
+	defer dm.mux.Unlock()
+
+	if allocErr = dm.checkDbFile(); allocErr != nil {
+		return -1, allocErr
+	}
+	pageId = dm.superblock.FreeListHead
+	if pageId == 0 {
+		return dm.extendFile()
+	}
+	pageData := make([]byte, dm.pageSize)
+	if allocErr = dm.readFreePage(pageId, pageData); allocErr != nil {
+		return -1, allocErr
+	}
+	dm.superblock.FreeListHead = int(binary.LittleEndian.Uint64(pageData[freePageNextOffset:]))
+	if allocErr = dm.writeSuperblock(); allocErr != nil {
+		dm.superblock.FreeListHead = pageId
+		return -1, allocErr
+	}
+	return pageId, nil
+}
+
+/*
+DeallocatePage puts the page at the head of the free list so that AllocatePage reuses it.
+the free page is written before the superblock points to it, a crash in between only leaks the page.
+*/
+func (dm *DiskFileMetaData) DeallocatePage(pageId int) (deallocErr error) {
+	dm.mux.Lock()
+	defer dm.mux.Unlock()
+
+	if deallocErr = dm.checkDbFile(); deallocErr != nil {
+		return deallocErr
+	}
+	if pageId <= SuperblockPageId {
+		return ErrReservedPage
+	}
+	if int64(pageId*dm.pageSize) >= dm.dbFileSize.Load() {
+		return ErrPageNotFound
+	}
+	pageData := make([]byte, dm.pageSize)
+	if deallocErr = dm.readFreePage(pageId, pageData); deallocErr == nil {
+		return fmt.Errorf("%w: pageId %d", ErrPageAlreadyFree, pageId)
+	}
+
+	clear(pageData)
+	pageData[0] = freePageFlag
+	binary.LittleEndian.PutUint64(pageData[constants.PageLSNOffset:], math.MaxInt64)
+	binary.LittleEndian.PutUint64(pageData[freePageNextOffset:], uint64(dm.superblock.FreeListHead))
+	if deallocErr = dm.writePage(pageId, pageData); deallocErr != nil {
+		return deallocErr
+	}
+
+	prevHead := dm.superblock.FreeListHead
+	dm.superblock.FreeListHead = pageId
+	if deallocErr = dm.writeSuperblock(); deallocErr != nil {
+		dm.superblock.FreeListHead = prevHead
+	}
+	return deallocErr
+}
+
+// readFreePage reads the page and checks that it is a free page, dm.mux should be held
+func (dm *DiskFileMetaData) readFreePage(pageId int, pageData []byte) (readErr error) {
+	if pageId <= SuperblockPageId || int64(pageId*dm.pageSize) >= dm.dbFileSize.Load() {
+		return fmt.Errorf("%w: pageId %d is not in the db file", ErrFreeListCorrupted, pageId)
+	}
+	if _, readErr = dm.dbFile.ReadAt(pageData, int64(pageId*dm.pageSize)); readErr != nil {
+		return readErr
+	}
+	if !verifyPageChecksum(pageId, pageData) || pageData[0] != freePageFlag {
+		return fmt.Errorf("%w: pageId %d is not a free page", ErrFreeListCorrupted, pageId)
+	}
+	return nil
+}
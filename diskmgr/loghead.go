@@ -0,0 +1,101 @@
+package diskmgr
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+const logFileMagic uint64 = 0x474f4c5453594d48 // "HYMSTLOG"
+
+/*
+the log file starts with a header of LogHeaderSize bytes, the log bytes follow it: log offset 0 is at LogHeaderSize in the file.
+the header keeps the log start, the offset of the first log byte that was not discarded. offsets do not move when a prefix is
+discarded, the prefix is punched out of the file instead of copying the rest of the log over it.
+like the superblock the header has two copies, in different sectors, with a generation that picks the newest valid one.
+*/
+const LogHeaderSize int64 = 4096
+
+const logHeaderSlots = 2
+const logHeaderSlotSize = 512
+
+// log header fields, from the start of a slot
+const (
+	lhMagicOffset      = 0
+	lhStartOffset      = lhMagicOffset + 8
+	lhGenerationOffset = lhStartOffset + 8
+	lhChecksumOffset   = lhGenerationOffset + 8
+	lhEnd              = lhChecksumOffset + 8
+)
+
+func serializeLogHeader(slotData []byte, logStart int64, generation uint64) {
+	binary.LittleEndian.PutUint64(slotData[lhMagicOffset:], logFileMagic)
+	binary.LittleEndian.PutUint64(slotData[lhStartOffset:], uint64(logStart))
+	binary.LittleEndian.PutUint64(slotData[lhGenerationOffset:], generation)
+	binary.LittleEndian.PutUint64(slotData[lhChecksumOffset:], xxhash.Sum64(slotData[:lhChecksumOffset]))
+}
+
+func verifyLogHeaderChecksum(slotData []byte) bool {
+	return binary.LittleEndian.Uint64(slotData[lhMagicOffset:]) == logFileMagic &&
+		binary.LittleEndian.Uint64(slotData[lhChecksumOffset:]) == xxhash.Sum64(slotData[:lhChecksumOffset])
+}
+
+/*
+loadLogHeader writes the header of an empty log file, else reads both copies and takes the newest valid one.
+fileSize is the size of the log file on disk, dm.logFileSize is set from it.
+*/
+func (dm *DiskFileMetaData) loadLogHeader(fileSize int64) (headerErr error) {
+	if fileSize == 0 {
+		return dm.writeNewLogHeader()
+	}
+	headerData := make([]byte, LogHeaderSize)
+	if _, readErr := dm.logFile.ReadAt(headerData, 0); readErr == io.EOF {
+		return fmt.Errorf("%w: %s is too small for a log header", ErrNotLogFile, dm.LogFilePath)
+	} else if readErr != nil {
+		return readErr
+	}
+
+	found := false
+	for slot := range logHeaderSlots {
+		slotData := headerData[slot*logHeaderSlotSize:]
+		generation := binary.LittleEndian.Uint64(slotData[lhGenerationOffset:])
+		if verifyLogHeaderChecksum(slotData) && (!found || generation > dm.logGeneration) {
+			dm.logStart = int64(binary.LittleEndian.Uint64(slotData[lhStartOffset:]))
+			dm.logGeneration, found = generation, true
+		}
+	}
+	if !found {
+		return fmt.Errorf("%w: %s has no valid log header", ErrNotLogFile, dm.LogFilePath)
+	}
+	dm.logFileSize = fileSize - LogHeaderSize
+	if dm.logStart > dm.logFileSize {
+		return fmt.Errorf("%w: %s starts at %d past its end %d", ErrNotLogFile, dm.LogFilePath, dm.logStart, dm.logFileSize)
+	}
+	return nil
+}
+
+// writeNewLogHeader writes the header of a new log file with both copies at log start 0
+func (dm *DiskFileMetaData) writeNewLogHeader() (headerErr error) {
+	headerData := make([]byte, LogHeaderSize)
+	for slot := range logHeaderSlots {
+		serializeLogHeader(headerData[slot*logHeaderSlotSize:], 0, uint64(slot))
+	}
+	if _, headerErr = dm.logFile.WriteAt(headerData, 0); headerErr != nil {
+		return headerErr
+	}
+	dm.logStart, dm.logGeneration, dm.logFileSize = 0, 1, 0
+	return dm.syncLogFile()
+}
+
+// writeLogStart writes logStart over the older copy of the header and syncs it, dm.logMux should be held
+func (dm *DiskFileMetaData) writeLogStart(logStart int64) (headerErr error) {
+	slotData := make([]byte, lhEnd)
+	serializeLogHeader(slotData, logStart, dm.logGeneration+1)
+	if _, headerErr = dm.logFile.WriteAt(slotData, int64((dm.logGeneration+1)%logHeaderSlots)*logHeaderSlotSize); headerErr != nil {
+		return headerErr
+	}
+	dm.logGeneration++
+	return dm.syncLogFile()
+}
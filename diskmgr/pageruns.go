@@ -0,0 +1,160 @@
+package diskmgr
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/rohithputha/HymStMgr/constants"
+)
+
+/*
+WritePages and ReadPages move many pages with one WriteAt or ReadAt per run of contiguous pageIds instead of one per page.
+a run is a stretch of entries in pageIds where every pageId is one after the one before, at most constants.IORunPages long,
+so the caller sorts the pageIds to get the longest runs. the page buffers do not have to be contiguous, a run is copied
+through one buffer of its own (the checksums are stamped there, the caller's pages are not changed).
+*/
+
+// pageRuns returns the end (exclusive) of every run in pageIds, the first run starts at 0 and every other one at the end of the run before
+func pageRuns(pageIds []int) (runEnds []int) {
+	start := 0
+	for i := 1; i <= len(pageIds); i++ {
+		if i == len(pageIds) || pageIds[i] != pageIds[i-1]+1 || i-start == constants.IORunPages {
+			runEnds = append(runEnds, i)
+			start = i
+		}
+	}
+	return runEnds
+}
+
+/*
+WritePages writes pagesData[i] to pageIds[i] without a sync, like WritePageNoSync the pages are durable only after a later Sync.
+a run can start at the end of the file and grow it. the runs are written in order, on an error the runs before it are written.
+*/
+func (dm *DiskFileMetaData) WritePages(pageIds []int, pagesData [][]byte) (writeErr error) {
+	if writeErr = dm.checkDbFile(); writeErr != nil {
+		return writeErr
+	}
+	if len(pageIds) != len(pagesData) {
+		return fmt.Errorf("%w: %d pageIds for %d pages", ErrPageBufferTooSmall, len(pageIds), len(pagesData))
+	}
+	for i, pageId := range pageIds {
+		if len(pagesData[i]) < dm.pageSize {
+			return ErrPageBufferTooSmall
+		}
+		if pageId <= SuperblockPageId {
+			return ErrReservedPage
+		}
+	}
+	start := 0
+	for _, end := range pageRuns(pageIds) {
+		if writeErr = dm.writeRun(pageIds[start], pagesData[start:end]); writeErr != nil {
+			return writeErr
+		}
+		start = end
+	}
+	return nil
+}
+
+// writeRun writes the pages to firstPageId and the pageIds after it with one WriteAt, only a run that grows the file takes growMux
+func (dm *DiskFileMetaData) writeRun(firstPageId int, pagesData [][]byte) (writeErr error) {
+	offset := int64(firstPageId * dm.pageSize)
+	runBuf := make([]byte, len(pagesData)*dm.pageSize)
+	for i, pageData := range pagesData {
+		pageBuf := runBuf[i*dm.pageSize : (i+1)*dm.pageSize]
+		copy(pageBuf, pageData)
+		stampPageChecksum(firstPageId+i, pageBuf)
+	}
+	runEnd := offset + int64(len(runBuf))
+	if runEnd <= dm.dbFileSize.Load() {
+		_, writeErr = dm.dbFile.WriteAt(runBuf, offset)
+		return dm.dbFileErrOf(writeErr)
+	}
+
+	dm.growMux.Lock()
+	defer dm.growMux.Unlock()
+	fileSize := dm.dbFileSize.Load()
+	if offset > fileSize {
+		return ErrPageBeyondEOF
+	}
+	if _, writeErr = dm.dbFile.WriteAt(runBuf, offset); writeErr != nil {
+		return dm.dbFileErrOf(writeErr)
+	}
+	dm.dbFileSize.Store(max(fileSize, runEnd))
+	dm.fileCapacity = max(dm.fileCapacity, runEnd)
+	return nil
+}
+
+/*
+ReadPages reads pageIds[i] into readData[i] and returns the error of every page, readErrs[i] is nil if page i was read.
+a page fails on its own the way ReadPage fails (not found, free, corrupted), a run that can not be read fails all its pages.
+*/
+func (dm *DiskFileMetaData) ReadPages(pageIds []int, readData [][]byte) (readErrs []error) {
+	readErrs = make([]error, len(pageIds))
+	checkErr := dm.checkDbFile()
+	if checkErr == nil && len(pageIds) != len(readData) {
+		checkErr = fmt.Errorf("%w: %d pageIds for %d pages", ErrPageBufferTooSmall, len(pageIds), len(readData))
+	}
+	if checkErr != nil {
+		for i := range readErrs {
+			readErrs[i] = checkErr
+		}
+		return readErrs
+	}
+	start := 0
+	for _, end := range pageRuns(pageIds) {
+		dm.readRun(pageIds[start], readData[start:end], readErrs[start:end])
+		start = end
+	}
+	return readErrs
+}
+
+// readRun reads the pages from firstPageId on, a stretch of pages that can be read is read with one ReadAt
+func (dm *DiskFileMetaData) readRun(firstPageId int, readData [][]byte, readErrs []error) {
+	for i, pageData := range readData {
+		pageId := firstPageId + i
+		if len(pageData) < dm.pageSize {
+			readErrs[i] = ErrPageBufferTooSmall
+		} else if pageId <= SuperblockPageId {
+			readErrs[i] = ErrReservedPage
+		} else if int64(pageId*dm.pageSize) >= dm.dbFileSize.Load() {
+			readErrs[i] = ErrPageNotFound
+		}
+	}
+	for start := 0; start < len(readData); {
+		if readErrs[start] != nil {
+			start++
+			continue
+		}
+		end := start + 1
+		for end < len(readData) && readErrs[end] == nil {
+			end++
+		}
+		dm.readPagesAt(firstPageId+start, readData[start:end], readErrs[start:end])
+		start = end
+	}
+}
+
+// readPagesAt reads pages that are all in the file with one ReadAt and verifies their checksums
+func (dm *DiskFileMetaData) readPagesAt(firstPageId int, readData [][]byte, readErrs []error) {
+	runBuf := make([]byte, len(readData)*dm.pageSize)
+	numRead, readErr := dm.dbFile.ReadAt(runBuf, int64(firstPageId*dm.pageSize))
+	for i, pageData := range readData {
+		pageId := firstPageId + i
+		pageBuf := runBuf[i*dm.pageSize : (i+1)*dm.pageSize]
+		if readErr != nil && readErr != io.EOF {
+			readErrs[i] = dm.dbFileErrOf(readErr)
+			continue
+		}
+		if numRead < (i+1)*dm.pageSize {
+			readErrs[i] = fmt.Errorf("%w for pageId: %d", ErrShortRead, pageId)
+			continue
+		}
+		copy(pageData, pageBuf)
+		if !verifyPageChecksum(pageId, pageBuf) {
+			readErrs[i] = fmt.Errorf("%w for pageId: %d", ErrPageCorrupted, pageId)
+		} else if pageBuf[0] == freePageFlag {
+			// a freed page is gone until AllocatePage hands it out again
+			readErrs[i] = fmt.Errorf("%w: %w for pageId: %d", ErrPageNotFound, ErrPageFree, pageId)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package diskmgr
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// preallocate grows the file by length bytes from offset with fallocate, so the blocks are reserved on disk and not only in the file size
+func preallocate(file *os.File, offset int64, length int64) (preallocErr error) {
+	rawConn, preallocErr := file.SyscallConn()
+	if preallocErr != nil {
+		return preallocErr
+	}
+	if ctrlErr := rawConn.Control(func(fd uintptr) {
+		preallocErr = syscall.Fallocate(int(fd), 0, offset, length)
+	}); ctrlErr != nil {
+		return ctrlErr
+	}
+	// not every file system has fallocate, the file is still grown (sparse) by the truncate
+	if errors.Is(preallocErr, syscall.EOPNOTSUPP) || errors.Is(preallocErr, syscall.ENOSYS) {
+		return file.Truncate(offset + length)
+	}
+	return preallocErr
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package diskmgr
+
+import "os"
+
+// preallocate grows the file by length bytes from offset, without fallocate the new bytes are a hole the file system fills on write
+func preallocate(file *os.File, offset int64, length int64) (preallocErr error) {
+	return file.Truncate(offset + length)
+}
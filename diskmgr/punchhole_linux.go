@@ -0,0 +1,31 @@
+package diskmgr
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// fallocate modes from linux/falloc.h, the syscall package does not have them
+const (
+	fallocKeepSize  = 0x01
+	fallocPunchHole = 0x02
+)
+
+// punchHole frees the blocks of length bytes from offset, the file keeps its size and the bytes read back as zeros
+func punchHole(file *os.File, offset int64, length int64) (punchErr error) {
+	rawConn, punchErr := file.SyscallConn()
+	if punchErr != nil {
+		return punchErr
+	}
+	if ctrlErr := rawConn.Control(func(fd uintptr) {
+		punchErr = syscall.Fallocate(int(fd), fallocPunchHole|fallocKeepSize, offset, length)
+	}); ctrlErr != nil {
+		return ctrlErr
+	}
+	// not every file system can punch holes, the bytes then stay on disk and are only skipped
+	if errors.Is(punchErr, syscall.EOPNOTSUPP) || errors.Is(punchErr, syscall.ENOSYS) {
+		return nil
+	}
+	return punchErr
+}
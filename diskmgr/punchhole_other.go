@@ -0,0 +1,10 @@
+//go:build !linux
+
+package diskmgr
+
+import "os"
+
+// punchHole does nothing without fallocate, the discarded bytes stay in the file and are only skipped
+func punchHole(file *os.File, offset int64, length int64) (punchErr error) {
+	return nil
+}
@@ -0,0 +1,184 @@
+package diskmgr
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/rohithputha/HymStMgr/constants"
+)
+
+// page 0 of the db file is the superblock, no data page ever gets pageId 0
+const SuperblockPageId int = 0
+
+const dbFileMagic uint64 = 0x52474d5453594d48 // "HYMSTMGR"
+const FormatVersion uint32 = 2
+
+/*
+page 0 holds two copies of the superblock, in slots that start after the common page header and at half the page.
+a write updates only the older copy, so a torn write leaves the other one intact. every copy has its own checksum
+and a generation that every write bumps, on open the valid copy with the highest generation is the superblock.
+*/
+const sbSlots = 2
+
+// superblock fields, from the start of a slot
+const (
+	sbMagicOffset        = 0
+	sbVersionOffset      = sbMagicOffset + 8
+	sbPageSizeOffset     = sbVersionOffset + 4
+	sbCreatedAtOffset    = sbPageSizeOffset + 4
+	sbFreeListHeadOffset = sbCreatedAtOffset + 8
+	sbCatalogRootOffset  = sbFreeListHeadOffset + 8
+	sbGenerationOffset   = sbCatalogRootOffset + 8
+	sbChecksumOffset     = sbGenerationOffset + 8
+	sbEnd                = sbChecksumOffset + 8
+)
+
+/*
+Superblock is the metadata kept in page 0 of the db file.
+FreeListHead and CatalogRoot are page ids, 0 means not set (page 0 can never be a free or a catalog page).
+*/
+type Superblock struct {
+	Magic         uint64
+	FormatVersion uint32
+	PageSize      uint32
+	CreatedAt     int64 // unix nano
+	FreeListHead  int
+	CatalogRoot   int
+	Generation    uint64 // bumped by every write of the superblock, it picks the copy it is written to
+}
+
+func getNewSuperblock(pageSize int) Superblock {
+	return Superblock{
+		Magic:         dbFileMagic,
+		FormatVersion: FormatVersion,
+		PageSize:      uint32(pageSize),
+		CreatedAt:     time.Now().UnixNano(),
+	}
+}
+
+// sbSlotOffset is the offset of the copy in page 0
+func sbSlotOffset(slot int, pageSize int) int {
+	return constants.PageHeaderSize + slot*pageSize/2
+}
+
+func (sb *Superblock) serialize(slotData []byte) {
+	binary.LittleEndian.PutUint64(slotData[sbMagicOffset:], sb.Magic)
+	binary.LittleEndian.PutUint32(slotData[sbVersionOffset:], sb.FormatVersion)
+	binary.LittleEndian.PutUint32(slotData[sbPageSizeOffset:], sb.PageSize)
+	binary.LittleEndian.PutUint64(slotData[sbCreatedAtOffset:], uint64(sb.CreatedAt))
+	binary.LittleEndian.PutUint64(slotData[sbFreeListHeadOffset:], uint64(sb.FreeListHead))
+	binary.LittleEndian.PutUint64(slotData[sbCatalogRootOffset:], uint64(sb.CatalogRoot))
+	binary.LittleEndian.PutUint64(slotData[sbGenerationOffset:], sb.Generation)
+	binary.LittleEndian.PutUint64(slotData[sbChecksumOffset:], xxhash.Sum64(slotData[:sbChecksumOffset]))
+}
+
+func deserializeSuperblock(slotData []byte) Superblock {
+	return Superblock{
+		Magic:         binary.LittleEndian.Uint64(slotData[sbMagicOffset:]),
+		FormatVersion: binary.LittleEndian.Uint32(slotData[sbVersionOffset:]),
+		PageSize:      binary.LittleEndian.Uint32(slotData[sbPageSizeOffset:]),
+		CreatedAt:     int64(binary.LittleEndian.Uint64(slotData[sbCreatedAtOffset:])),
+		FreeListHead:  int(binary.LittleEndian.Uint64(slotData[sbFreeListHeadOffset:])),
+		CatalogRoot:   int(binary.LittleEndian.Uint64(slotData[sbCatalogRootOffset:])),
+		Generation:    binary.LittleEndian.Uint64(slotData[sbGenerationOffset:]),
+	}
+}
+
+func verifySbChecksum(slotData []byte) bool {
+	return binary.LittleEndian.Uint64(slotData[sbChecksumOffset:]) == xxhash.Sum64(slotData[:sbChecksumOffset])
+}
+
+/*
+loadSuperblock writes a new superblock into an empty db file, else reads both copies and takes the newest valid one.
+if no copy is valid, magic, version and page size of the first copy tell why, so that a file with another page size
+(its second copy is somewhere else) or another format gets a clear error instead of a checksum mismatch.
+*/
+func (dm *DiskFileMetaData) loadSuperblock() (sbErr error) {
+	if dm.dbFileSize.Load() == 0 {
+		return dm.writeNewSuperblock()
+	}
+	// a file with a smaller page size can be shorter than one page, its first copy is still at the same place
+	pageData := make([]byte, dm.pageSize)
+	if n, readErr := dm.dbFile.ReadAt(pageData, 0); readErr == io.EOF && n < sbSlotOffset(0, dm.pageSize)+sbEnd {
+		return fmt.Errorf("%w: %s is too small for a superblock", ErrNotDbFile, dm.DbFilePath)
+	} else if readErr != nil && readErr != io.EOF {
+		return readErr
+	}
+
+	found := false
+	for slot := range sbSlots {
+		slotData := pageData[sbSlotOffset(slot, dm.pageSize):]
+		if sb := deserializeSuperblock(slotData); verifySbChecksum(slotData) && (!found || sb.Generation > dm.superblock.Generation) {
+			dm.superblock, found = sb, true
+		}
+	}
+	sb := dm.superblock
+	if !found {
+		sb = deserializeSuperblock(pageData[sbSlotOffset(0, dm.pageSize):])
+	}
+	if sb.Magic != dbFileMagic {
+		return fmt.Errorf("%w: %s has a bad magic number", ErrNotDbFile, dm.DbFilePath)
+	}
+	if sb.FormatVersion != FormatVersion {
+		return fmt.Errorf("%w: %s has version %d, expected %d", ErrIncompatibleVersion, dm.DbFilePath, sb.FormatVersion, FormatVersion)
+	}
+	if sb.PageSize != uint32(dm.pageSize) {
+		return fmt.Errorf("%w: %s has page size %d, expected %d", ErrPageSizeMismatch, dm.DbFilePath, sb.PageSize, dm.pageSize)
+	}
+	if !found {
+		return fmt.Errorf("%w for both copies of the superblock of %s", ErrPageCorrupted, dm.DbFilePath)
+	}
+	return nil
+}
+
+// writeNewSuperblock writes page 0 of a new db file with the same superblock in both copies
+func (dm *DiskFileMetaData) writeNewSuperblock() (sbErr error) {
+	dm.superblock = getNewSuperblock(dm.pageSize)
+	pageData := make([]byte, dm.pageSize)
+	for slot := range sbSlots {
+		dm.superblock.Generation = uint64(slot)
+		dm.superblock.serialize(pageData[sbSlotOffset(slot, dm.pageSize):])
+	}
+	if sbErr = dm.writePage(SuperblockPageId, pageData); sbErr != nil {
+		return sbErr
+	}
+	return dm.syncDbFile()
+}
+
+// writeSuperblock writes dm.superblock over its older copy and syncs it, the caller should hold dm.mux
+func (dm *DiskFileMetaData) writeSuperblock() (sbErr error) {
+	dm.superblock.Generation++
+	slotData := make([]byte, sbEnd)
+	dm.superblock.serialize(slotData)
+	offset := sbSlotOffset(int(dm.superblock.Generation%sbSlots), dm.pageSize)
+	if _, sbErr = dm.dbFile.WriteAt(slotData, int64(offset)); sbErr != nil {
+		dm.superblock.Generation--
+		return dm.dbFileErrOf(sbErr)
+	}
+	return dm.syncDbFile()
+}
+
+func (dm *DiskFileMetaData) GetSuperblock() Superblock {
+	dm.mux.Lock()
+	defer dm.mux.Unlock()
+	return dm.superblock
+}
+
+// SetCatalogRoot records the page id of the catalog root in the superblock
+func (dm *DiskFileMetaData) SetCatalogRoot(pageId int) (sbErr error) {
+	dm.mux.Lock()
+	defer dm.mux.Unlock()
+
+	if sbErr = dm.checkDbFile(); sbErr != nil {
+		return sbErr
+	}
+	prevRoot := dm.superblock.CatalogRoot
+	dm.superblock.CatalogRoot = pageId
+	if sbErr = dm.writeSuperblock(); sbErr != nil {
+		dm.superblock.CatalogRoot = prevRoot
+	}
+	return sbErr
+}
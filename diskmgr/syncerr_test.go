@@ -0,0 +1,71 @@
+package diskmgr
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rohithputha/HymStMgr/constants"
+)
+
+// the failed syncs need the files of the disk manager, so unlike the tests in diskmgr/tests these run inside the package
+
+func getSyncErrTestDiskMgr(test *testing.T) *DiskFileMetaData {
+	dir := test.TempDir()
+	diskMgr, _ := GetDiskFileMgr(DiskFileInit{DbFilePath: dir + "/dbtest.db", LogFilePath: dir + "/dblogtest.log"})
+	diskFileMd := diskMgr.(*DiskFileMetaData)
+	diskFileMd.WritePage(1, make([]byte, constants.PageSize))
+	return diskFileMd
+}
+
+func TestSyncFailedIsFatal(test *testing.T) {
+	dm := getSyncErrTestDiskMgr(test)
+	// a closed file fails every sync, the way a file with lost writes does
+	dm.dbFile.Close()
+	if err := dm.Sync(); !errors.Is(err, ErrSyncFailed) {
+		test.Errorf("failed sync not returned: %v", err)
+	}
+	pageData := make([]byte, constants.PageSize)
+	if err := dm.WritePage(1, pageData); !errors.Is(err, ErrSyncFailed) {
+		test.Errorf("write page after a failed sync does not return ErrSyncFailed: %v", err)
+	}
+	if err := dm.WritePageNoSync(1, pageData); !errors.Is(err, ErrSyncFailed) {
+		test.Errorf("write page no sync after a failed sync does not return ErrSyncFailed: %v", err)
+	}
+	if err := dm.ReadPage(1, pageData); !errors.Is(err, ErrSyncFailed) {
+		test.Errorf("read page after a failed sync does not return ErrSyncFailed: %v", err)
+	}
+	if _, err := dm.AllocatePage(); !errors.Is(err, ErrSyncFailed) {
+		test.Errorf("allocate page after a failed sync does not return ErrSyncFailed: %v", err)
+	}
+	if err := dm.Sync(); !errors.Is(err, ErrSyncFailed) {
+		test.Errorf("sync after a failed sync does not return ErrSyncFailed: %v", err)
+	}
+	// the log file is a file of its own, it still works
+	if err := dm.WriteLog([]byte{1, 2, 3}); err != nil {
+		test.Errorf("write log after a failed sync of the db file failed: %v", err)
+	}
+	if err := dm.Close(); !errors.Is(err, ErrSyncFailed) {
+		test.Errorf("close after a failed sync does not return ErrSyncFailed: %v", err)
+	}
+}
+
+func TestLogSyncFailedIsFatal(test *testing.T) {
+	dm := getSyncErrTestDiskMgr(test)
+	dm.logFile.Close()
+	if err := dm.syncLogFile(); !errors.Is(err, ErrSyncFailed) {
+		test.Errorf("failed sync of the log file not returned: %v", err)
+	}
+	if err := dm.TruncateLogTail(0); !errors.Is(err, ErrSyncFailed) {
+		test.Errorf("truncate log tail after a failed log sync does not return ErrSyncFailed: %v", err)
+	}
+	if err := dm.WriteLog([]byte{1}); !errors.Is(err, ErrSyncFailed) {
+		test.Errorf("write log after a failed log sync does not return ErrSyncFailed: %v", err)
+	}
+	if _, err := dm.ReadLog(make([]byte, 1), 0); !errors.Is(err, ErrSyncFailed) {
+		test.Errorf("read log after a failed log sync does not return ErrSyncFailed: %v", err)
+	}
+	if err := dm.WritePage(1, make([]byte, constants.PageSize)); err != nil {
+		test.Errorf("write page after a failed sync of the log file failed: %v", err)
+	}
+	dm.Close()
+}
@@ -0,0 +1,143 @@
+package diskmgr
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/rohithputha/HymStMgr/constants"
+	"github.com/rohithputha/HymStMgr/diskmgr"
+	"github.com/rohithputha/HymStMgr/diskmgr/disktest"
+)
+
+// getConcurrentTestDiskFile returns a disk file with numPages pages, page i holds byte(i)
+func getConcurrentTestDiskFile(test testing.TB, numPages int) diskmgr.DiskFileMgr {
+	d := disktest.GetFileInit(test)
+	d.SyncPolicy = diskmgr.SyncOnFlush
+	diskFile := disktest.GetDiskMgr(test, d)
+	pageIds := make([]int, numPages)
+	for i := range pageIds {
+		pageIds[i] = i + 1
+	}
+	diskFile.WritePages(pageIds, getPageRunsTestPages(pageIds))
+	return diskFile
+}
+
+// readers of pages nobody writes, writers that rewrite pages of their own and appenders that grow the file, all at once
+func TestReadWritePagesConcurrent(test *testing.T) {
+	const numPages, numWorkers, rounds = 32, 4, 50
+	diskFile := getConcurrentTestDiskFile(test, numPages)
+	var wg sync.WaitGroup
+	for worker := range numWorkers {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			pageData := make([]byte, constants.PageSize)
+			for round := range rounds {
+				pageId := 1 + (worker+round*numWorkers)%(numPages/2)
+				if err := diskFile.ReadPage(pageId, pageData); err != nil || pageData[constants.PageHeaderSize] != byte(pageId) {
+					test.Errorf("concurrent read of pageId %d not working as expected: %v", pageId, err)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			pageId := numPages/2 + 1 + worker
+			for round := range rounds {
+				pageData := make([]byte, constants.PageSize)
+				pageData[constants.PageHeaderSize] = byte(round)
+				if err := diskFile.WritePageNoSync(pageId, pageData); err != nil {
+					test.Errorf("concurrent write of pageId %d failed: %v", pageId, err)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range rounds / 10 {
+				pageId := diskFile.GetPageCount()
+				// another appender can take the page in between, that write is past the end of the file
+				if err := diskFile.WritePageNoSync(pageId, make([]byte, constants.PageSize)); err != nil && !errors.Is(err, diskmgr.ErrPageBeyondEOF) {
+					test.Errorf("concurrent append of pageId %d failed: %v", pageId, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	pageData := make([]byte, constants.PageSize)
+	for worker := range numWorkers {
+		pageId := numPages/2 + 1 + worker
+		if err := diskFile.ReadPage(pageId, pageData); err != nil || pageData[constants.PageHeaderSize] != rounds-1 {
+			test.Errorf("pageId %d does not hold its last concurrent write: %v", pageId, err)
+		}
+	}
+	for pageId := numPages + 1; pageId < diskFile.GetPageCount(); pageId++ {
+		if err := diskFile.ReadPage(pageId, pageData); err != nil {
+			test.Errorf("appended pageId %d not read back: %v", pageId, err)
+		}
+	}
+	if diskFile.Sync() != nil || diskFile.Close() != nil {
+		test.Errorf("sync and close after concurrent writes failed")
+	}
+}
+
+func TestCloseDuringReads(test *testing.T) {
+	diskFile := getConcurrentTestDiskFile(test, 8)
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pageData := make([]byte, constants.PageSize)
+			for {
+				err := diskFile.ReadPage(1+rand.Intn(8), pageData)
+				if errors.Is(err, diskmgr.ErrClosed) {
+					return
+				}
+				if err != nil {
+					test.Errorf("read during close does not return ErrClosed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	if err := diskFile.Close(); err != nil {
+		test.Errorf("close during reads failed: %v", err)
+	}
+	wg.Wait()
+}
+
+func BenchmarkReadPage(b *testing.B) {
+	const numPages = 256
+	diskFile := getConcurrentTestDiskFile(b, numPages)
+	pageData := make([]byte, constants.PageSize)
+	b.ResetTimer()
+	for range b.N {
+		diskFile.ReadPage(1+rand.Intn(numPages), pageData)
+	}
+	b.StopTimer()
+	diskFile.Close()
+}
+
+/*
+BenchmarkReadPageParallel reads random pages from many goroutines, the reads do not share a lock.
+run it with -cpu 1,2,4,8 to see the throughput scale with GOMAXPROCS.
+*/
+func BenchmarkReadPageParallel(b *testing.B) {
+	const numPages = 256
+	diskFile := getConcurrentTestDiskFile(b, numPages)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		rng := rand.New(rand.NewSource(rand.Int63()))
+		pageData := make([]byte, constants.PageSize)
+		for pb.Next() {
+			diskFile.ReadPage(1+rng.Intn(numPages), pageData)
+		}
+	})
+	b.StopTimer()
+	diskFile.Close()
+}
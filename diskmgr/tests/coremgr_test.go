@@ -8,27 +8,22 @@ import (
 
 	"github.com/rohithputha/HymStMgr/constants"
 	"github.com/rohithputha/HymStMgr/diskmgr"
+	"github.com/rohithputha/HymStMgr/diskmgr/disktest"
 )
 
 func TestGetDiskFileMgr(test *testing.T) {
-	d := diskmgr.DiskFileInit{
-		DbFilePath:  test.TempDir() + "dbtest.db",
-		LogFilePath: test.TempDir() + "dblogtest.log",
-	}
-	diskFile := diskmgr.GetDiskFileMgr(d)
+	d := disktest.GetFileInit(test)
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
 	if diskFile == nil {
 		test.Errorf("disk file mgr not working as expected")
 	}
 }
 
 func TestWritePage(test *testing.T) {
-	d := diskmgr.DiskFileInit{
-		DbFilePath:  test.TempDir() + "dbtest.db",
-		LogFilePath: test.TempDir() + "dblogtest.log",
-	}
-	diskFile := diskmgr.GetDiskFileMgr(d)
+	d := disktest.GetFileInit(test)
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
 	testByteArray := make([]byte, 4096)
-	writeErr := diskFile.WritePage(0, testByteArray)
+	writeErr := diskFile.WritePage(1, testByteArray)
 	if writeErr != nil {
 
 		test.Errorf("db write page not working as expected")
@@ -40,18 +35,15 @@ func TestWritePage(test *testing.T) {
 		test.Errorf("file info error")
 		return
 	}
-	if fileInfo.Size() != int64(constants.PageSize) {
+	if fileInfo.Size() != int64(2*constants.PageSize) {
 
 		test.Errorf("db write page not working as expected")
 	}
 }
 
 func TestWritePageDiffArrayLength(test *testing.T) {
-	d := diskmgr.DiskFileInit{
-		DbFilePath:  test.TempDir() + "dbtest.db",
-		LogFilePath: test.TempDir() + "dblogtest.log",
-	}
-	diskFile := diskmgr.GetDiskFileMgr(d)
+	d := disktest.GetFileInit(test)
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
 	testByteArray := make([]byte, 4000)
 	writeErr := diskFile.WritePage(0, testByteArray)
 	if writeErr == nil || writeErr.Error() != errors.New("write page size less than the actual page size defined").Error() {
@@ -60,11 +52,8 @@ func TestWritePageDiffArrayLength(test *testing.T) {
 }
 
 func TestWritePageAppendChecks(test *testing.T) {
-	d := diskmgr.DiskFileInit{
-		DbFilePath:  test.TempDir() + "dbtest.db",
-		LogFilePath: test.TempDir() + "dblogtest.log",
-	}
-	diskFile := diskmgr.GetDiskFileMgr(d)
+	d := disktest.GetFileInit(test)
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
 	testByteArray := make([]byte, constants.PageSize)
 	writeErr := diskFile.WritePage(2, testByteArray)
 	if writeErr == nil || writeErr.Error() != errors.New("page failed to be appended after the EOF").Error() {
@@ -74,16 +63,13 @@ func TestWritePageAppendChecks(test *testing.T) {
 }
 
 func TestWritePageAppend(test *testing.T) {
-	d := diskmgr.DiskFileInit{
-		DbFilePath:  test.TempDir() + "dbtest.db",
-		LogFilePath: test.TempDir() + "dblogtest.log",
-	}
-	diskFile := diskmgr.GetDiskFileMgr(d)
+	d := disktest.GetFileInit(test)
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
 	testByteArray := make([]byte, constants.PageSize)
-	diskFile.WritePage(0, testByteArray)
+	diskFile.WritePage(1, testByteArray)
 
 	testByteArray1 := make([]byte, constants.PageSize)
-	writeErr := diskFile.WritePage(1, testByteArray1)
+	writeErr := diskFile.WritePage(2, testByteArray1)
 
 	if writeErr != nil {
 		test.Errorf("write page with append is not working as expected")
@@ -91,33 +77,228 @@ func TestWritePageAppend(test *testing.T) {
 }
 
 func TestReadPage(test *testing.T) {
-	d := diskmgr.DiskFileInit{
-		DbFilePath:  test.TempDir() + "dbtest.db",
-		LogFilePath: test.TempDir() + "dblogtest.log",
-	}
-	diskFile := diskmgr.GetDiskFileMgr(d)
+	d := disktest.GetFileInit(test)
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
 	testByteArray := make([]byte, constants.PageSize)
 	rand.Read(testByteArray)
 
 	testByteArrayRead := make([]byte, constants.PageSize)
 	test.Log(testByteArrayRead[1])
-	diskFile.WritePage(0, testByteArray)
+	diskFile.WritePage(1, testByteArray)
 
-	diskFile.ReadPage(0, testByteArrayRead)
+	diskFile.ReadPage(1, testByteArrayRead)
 	if testByteArray[100] != testByteArrayRead[100] {
 		test.Errorf("read page error ")
 	}
 }
 
 func TestReadPageNonExists(test *testing.T) {
-	d := diskmgr.DiskFileInit{
-		DbFilePath:  test.TempDir() + "dbtest.db",
-		LogFilePath: test.TempDir() + "dblogtest.log",
-	}
-	diskFile := diskmgr.GetDiskFileMgr(d)
+	d := disktest.GetFileInit(test)
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
 	testByteArray := make([]byte, constants.PageSize)
-	err := diskFile.ReadPage(0, testByteArray)
+	err := diskFile.ReadPage(1, testByteArray)
 	if err == nil || err.Error() != errors.New("read page not present").Error() {
 		test.Errorf("read page error not thrown when the page does not exist")
 	}
 }
+
+func TestWriteReadLog(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
+	diskFile.WriteLog([]byte{1, 2, 3})
+	diskFile.WriteLog([]byte{4, 5})
+	readData := make([]byte, 4)
+	numRead, readErr := diskFile.ReadLog(readData, 1)
+	if readErr != nil || numRead != 4 || readData[0] != 2 || readData[3] != 5 || diskFile.GetLogSize() != 5 {
+		test.Errorf("write and read log not working as expected")
+	}
+}
+
+func TestTruncateLogTail(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
+	diskFile.WriteLog([]byte{1, 2, 3, 4, 5})
+	if err := diskFile.TruncateLogTail(2); err != nil || diskFile.GetLogSize() != 2 {
+		test.Errorf("truncate log tail not working as expected")
+	}
+	diskFile.WriteLog([]byte{9})
+	readData := make([]byte, 3)
+	numRead, _ := diskFile.ReadLog(readData, 0)
+	if numRead != 3 || readData[2] != 9 {
+		test.Errorf("log append after truncate not working as expected")
+	}
+	if err := diskFile.TruncateLogTail(4); !errors.Is(err, diskmgr.ErrLogOutOfRange) {
+		test.Errorf("truncate past the log end does not return ErrLogOutOfRange")
+	}
+	if err := diskFile.DiscardLogPrefix(4); !errors.Is(err, diskmgr.ErrLogOutOfRange) {
+		test.Errorf("discard past the log end does not return ErrLogOutOfRange")
+	}
+}
+
+func TestDiscardLogPrefix(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
+	diskFile.WriteLog([]byte{1, 2, 3, 4, 5})
+	if err := diskFile.DiscardLogPrefix(3); err != nil || diskFile.GetLogStart() != 3 || diskFile.GetLogSize() != 5 {
+		test.Errorf("discard log prefix not working as expected")
+	}
+	diskFile.WriteLog([]byte{6})
+	readData := make([]byte, 3)
+	numRead, _ := diskFile.ReadLog(readData, 3)
+	fileInfo, _ := os.Stat(d.LogFilePath)
+	if numRead != 3 || readData[0] != 4 || readData[2] != 6 || fileInfo.Size() != diskmgr.LogHeaderSize+6 {
+		test.Errorf("log after discard prefix not as expected")
+	}
+	if _, err := diskFile.ReadLog(readData, 0); !errors.Is(err, diskmgr.ErrLogDiscarded) {
+		test.Errorf("read of the discarded prefix does not return ErrLogDiscarded")
+	}
+	diskFile.Close()
+
+	reopened, err := diskmgr.GetDiskFileMgr(d)
+	if err != nil || reopened.GetLogStart() != 3 || reopened.GetLogSize() != 6 {
+		test.Errorf("log start not kept after close and reopen")
+	}
+	reopened.Close()
+}
+
+func TestLogHeaderTornWrite(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
+	diskFile.WriteLog([]byte{1, 2, 3, 4, 5})
+	diskFile.DiscardLogPrefix(2)
+	diskFile.DiscardLogPrefix(4)
+	diskFile.Close()
+
+	// the second discard wrote the second copy of the header (at 512), a torn write leaves the copy from the first discard
+	logFile, _ := os.OpenFile(d.LogFilePath, os.O_RDWR, 0644)
+	logFile.WriteAt([]byte{0xff}, 512+8)
+	logFile.Close()
+	reopened, err := diskmgr.GetDiskFileMgr(d)
+	if err != nil || reopened.GetLogStart() != 2 {
+		test.Errorf("torn log header not recovered from the older copy")
+	}
+	reopened.Close()
+}
+
+func TestNotLogFile(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	os.WriteFile(d.LogFilePath, make([]byte, 2*diskmgr.LogHeaderSize), 0644)
+	if _, err := diskmgr.GetDiskFileMgr(d); !errors.Is(err, diskmgr.ErrNotLogFile) {
+		test.Errorf("log file without a header does not return ErrNotLogFile")
+	}
+}
+
+func TestReadPageCorrupted(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
+	testByteArray := make([]byte, constants.PageSize)
+	rand.Read(testByteArray)
+	diskFile.WritePage(1, testByteArray)
+
+	dbFile, _ := os.OpenFile(d.DbFilePath, os.O_RDWR, 0644)
+	dbFile.WriteAt([]byte{testByteArray[2000] + 1}, int64(constants.PageSize)+2000) // torn write on one byte of the page
+	dbFile.Close()
+
+	err := diskFile.ReadPage(1, make([]byte, constants.PageSize))
+	if !errors.Is(err, diskmgr.ErrPageCorrupted) {
+		test.Errorf("read page of a corrupted page does not return ErrPageCorrupted")
+	}
+}
+
+func TestReadPageMisplaced(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
+	testByteArray := make([]byte, constants.PageSize)
+	rand.Read(testByteArray)
+	diskFile.WritePage(1, testByteArray)
+	diskFile.WritePage(2, testByteArray)
+
+	pageBytes := make([]byte, constants.PageSize)
+	dbFile, _ := os.OpenFile(d.DbFilePath, os.O_RDWR, 0644)
+	dbFile.ReadAt(pageBytes, int64(constants.PageSize))
+	dbFile.WriteAt(pageBytes, int64(2*constants.PageSize)) // page 1 written over page 2
+	dbFile.Close()
+
+	if err := diskFile.ReadPage(1, make([]byte, constants.PageSize)); err != nil {
+		test.Errorf("read page of a good page failed: %v", err)
+	}
+	if err := diskFile.ReadPage(2, make([]byte, constants.PageSize)); !errors.Is(err, diskmgr.ErrPageCorrupted) {
+		test.Errorf("read page of a misplaced page does not return ErrPageCorrupted")
+	}
+}
+
+func TestWriteReadReservedPage(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
+	if err := diskFile.WritePage(0, make([]byte, constants.PageSize)); !errors.Is(err, diskmgr.ErrReservedPage) {
+		test.Errorf("write page over the superblock does not return ErrReservedPage")
+	}
+	if err := diskFile.ReadPage(0, make([]byte, constants.PageSize)); !errors.Is(err, diskmgr.ErrReservedPage) {
+		test.Errorf("read page of the superblock does not return ErrReservedPage")
+	}
+}
+
+func TestGetDiskFileMgrErrors(test *testing.T) {
+	dir := test.TempDir()
+	if _, err := diskmgr.GetDiskFileMgr(diskmgr.DiskFileInit{DbFilePath: dir + "/dbtest.txt", LogFilePath: dir + "/dblogtest.log"}); !errors.Is(err, diskmgr.ErrInvalidDbFilePath) {
+		test.Errorf("wrong db file extension does not return ErrInvalidDbFilePath")
+	}
+	if _, err := diskmgr.GetDiskFileMgr(diskmgr.DiskFileInit{DbFilePath: dir + "/dbtest.db", LogFilePath: dir + "/dblogtest.txt"}); !errors.Is(err, diskmgr.ErrInvalidLogFilePath) {
+		test.Errorf("wrong log file extension does not return ErrInvalidLogFilePath")
+	}
+	if _, err := diskmgr.GetDiskFileMgr(diskmgr.DiskFileInit{DbFilePath: dir + "/missing/dbtest.db", LogFilePath: dir + "/dblogtest.log"}); !errors.Is(err, os.ErrNotExist) {
+		test.Errorf("db file in a missing directory does not return the open error")
+	}
+}
+
+func TestReadPageErrors(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
+	diskFile.WritePage(1, make([]byte, constants.PageSize))
+	if err := diskFile.ReadPage(2, make([]byte, constants.PageSize)); !errors.Is(err, diskmgr.ErrPageNotFound) {
+		test.Errorf("read of a page beyond the file does not return ErrPageNotFound")
+	}
+	if err := diskFile.ReadPage(1, make([]byte, 100)); !errors.Is(err, diskmgr.ErrPageBufferTooSmall) {
+		test.Errorf("read into a short buffer does not return ErrPageBufferTooSmall")
+	}
+	if err := diskFile.WritePage(3, make([]byte, constants.PageSize)); !errors.Is(err, diskmgr.ErrPageBeyondEOF) {
+		test.Errorf("write after the EOF does not return ErrPageBeyondEOF")
+	}
+
+	os.Truncate(d.DbFilePath, int64(constants.PageSize)+100) // the last page is cut short behind the disk mgr
+	if err := diskFile.ReadPage(1, make([]byte, constants.PageSize)); !errors.Is(err, diskmgr.ErrShortRead) {
+		test.Errorf("read of a cut short page does not return ErrShortRead")
+	}
+}
+
+func TestClose(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
+	diskFile.WritePage(1, make([]byte, constants.PageSize))
+	diskFile.WriteLog([]byte{1, 2, 3})
+	if err := diskFile.Close(); err != nil {
+		test.Errorf("close failed: %v", err)
+	}
+
+	if err := diskFile.WritePage(1, make([]byte, constants.PageSize)); !errors.Is(err, diskmgr.ErrClosed) {
+		test.Errorf("write page after close does not return ErrClosed")
+	}
+	if err := diskFile.ReadPage(1, make([]byte, constants.PageSize)); !errors.Is(err, diskmgr.ErrClosed) {
+		test.Errorf("read page after close does not return ErrClosed")
+	}
+	if _, err := diskFile.ReadLog(make([]byte, 3), 0); !errors.Is(err, diskmgr.ErrClosed) {
+		test.Errorf("read log after close does not return ErrClosed")
+	}
+	if _, err := diskFile.AllocatePage(); !errors.Is(err, diskmgr.ErrClosed) {
+		test.Errorf("allocate page after close does not return ErrClosed")
+	}
+	if err := diskFile.Close(); !errors.Is(err, diskmgr.ErrClosed) {
+		test.Errorf("second close does not return ErrClosed")
+	}
+
+	reopened, err := diskmgr.GetDiskFileMgr(d)
+	if err != nil || reopened.GetPageCount() != 2 || reopened.GetLogSize() != 3 {
+		test.Errorf("files not the same after close and reopen")
+	}
+	reopened.Close()
+}
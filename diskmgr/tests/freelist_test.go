@@ -0,0 +1,182 @@
+package diskmgr
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/rohithputha/HymStMgr/constants"
+	"github.com/rohithputha/HymStMgr/diskmgr"
+	"github.com/rohithputha/HymStMgr/diskmgr/disktest"
+)
+
+func getFreeListTestDiskMgr(test *testing.T, numPages int) (diskmgr.DiskFileInit, diskmgr.DiskFileMgr) {
+	d := disktest.GetFileInit(test)
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
+	for pageId := 1; pageId <= numPages; pageId++ {
+		diskFile.WritePage(pageId, make([]byte, constants.PageSize))
+	}
+	return d, diskFile
+}
+
+func TestAllocatePageEmptyFreeList(test *testing.T) {
+	_, diskFile := getFreeListTestDiskMgr(test, 2)
+	pageId, err := diskFile.AllocatePage()
+	if err != nil || pageId != 3 {
+		test.Errorf("allocate page without free pages not working as expected")
+	}
+}
+
+func TestDeallocatePageReuse(test *testing.T) {
+	_, diskFile := getFreeListTestDiskMgr(test, 3)
+	diskFile.DeallocatePage(1)
+	diskFile.DeallocatePage(3)
+	if diskFile.GetSuperblock().FreeListHead != 3 {
+		test.Errorf("deallocate page does not put the page at the head of the free list")
+	}
+
+	first, _ := diskFile.AllocatePage()
+	second, _ := diskFile.AllocatePage()
+	third, _ := diskFile.AllocatePage()
+	if first != 3 || second != 1 || third != 4 || diskFile.GetPageCount() != 5 {
+		test.Errorf("allocate page does not reuse the free pages before growing the file")
+	}
+}
+
+func TestReadFreePage(test *testing.T) {
+	_, diskFile := getFreeListTestDiskMgr(test, 3)
+	diskFile.DeallocatePage(2)
+	readData := make([]byte, constants.PageSize)
+	if err := diskFile.ReadPage(2, readData); !errors.Is(err, diskmgr.ErrPageNotFound) || !errors.Is(err, diskmgr.ErrPageFree) {
+		test.Errorf("read of a free page does not return ErrPageNotFound: %v", err)
+	}
+	readErrs := diskFile.ReadPages([]int{1, 2, 3}, [][]byte{make([]byte, constants.PageSize), readData, make([]byte, constants.PageSize)})
+	if readErrs[0] != nil || !errors.Is(readErrs[1], diskmgr.ErrPageFree) || readErrs[2] != nil {
+		test.Errorf("read pages over a free page not working as expected: %v", readErrs)
+	}
+
+	// once it is allocated and written again it is a page like any other
+	pageId, _ := diskFile.AllocatePage()
+	diskFile.WritePage(pageId, make([]byte, constants.PageSize))
+	if err := diskFile.ReadPage(2, readData); pageId != 2 || err != nil {
+		test.Errorf("read of a reused page failed: %v", err)
+	}
+}
+
+func TestDeallocatePageReopen(test *testing.T) {
+	d, diskFile := getFreeListTestDiskMgr(test, 3)
+	diskFile.DeallocatePage(2)
+
+	reopened, _ := diskmgr.GetDiskFileMgr(d)
+	pageId, err := reopened.AllocatePage()
+	if err != nil || pageId != 2 {
+		test.Errorf("free list not kept across reopen")
+	}
+}
+
+func TestDeallocatePageErrors(test *testing.T) {
+	_, diskFile := getFreeListTestDiskMgr(test, 1)
+	if err := diskFile.DeallocatePage(0); !errors.Is(err, diskmgr.ErrReservedPage) {
+		test.Errorf("deallocate of the superblock does not return ErrReservedPage")
+	}
+	if err := diskFile.DeallocatePage(2); err == nil {
+		test.Errorf("deallocate of a page beyond the file does not return an error")
+	}
+	diskFile.DeallocatePage(1)
+	if err := diskFile.DeallocatePage(1); !errors.Is(err, diskmgr.ErrPageAlreadyFree) {
+		test.Errorf("double deallocate does not return ErrPageAlreadyFree")
+	}
+}
+
+func TestAllocatePageFreeListCorrupted(test *testing.T) {
+	_, diskFile := getFreeListTestDiskMgr(test, 2)
+	diskFile.DeallocatePage(1)
+	diskFile.WritePage(1, make([]byte, constants.PageSize)) // page 1 overwritten while on the free list
+	if _, err := diskFile.AllocatePage(); !errors.Is(err, diskmgr.ErrFreeListCorrupted) {
+		test.Errorf("allocate page from a corrupted free list does not return ErrFreeListCorrupted")
+	}
+}
+
+func TestAllocatePageConcurrent(test *testing.T) {
+	_, diskFile := getFreeListTestDiskMgr(test, 2)
+	const numWorkers, pagesPerWorker = 8, 20
+	pageIds := make(chan int, numWorkers*pagesPerWorker)
+	var wg sync.WaitGroup
+	for range numWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range pagesPerWorker {
+				pageId, err := diskFile.AllocatePage()
+				if err != nil {
+					test.Errorf("concurrent allocate page failed: %v", err)
+					return
+				}
+				pageIds <- pageId
+			}
+		}()
+	}
+	wg.Wait()
+	close(pageIds)
+
+	allocated := make(map[int]bool)
+	pageData := make([]byte, constants.PageSize)
+	for pageId := range pageIds {
+		if allocated[pageId] || pageId < 3 {
+			test.Errorf("pageId %d allocated twice or not after the end of the file", pageId)
+		}
+		allocated[pageId] = true
+		// the page is in the file as soon as it is allocated, before the caller writes it
+		if err := diskFile.ReadPage(pageId, pageData); err != nil {
+			test.Errorf("allocated pageId %d not readable: %v", pageId, err)
+		}
+	}
+	if len(allocated) != numWorkers*pagesPerWorker || diskFile.GetPageCount() != numWorkers*pagesPerWorker+3 {
+		test.Errorf("concurrent allocate page did not grow the file by one page per allocation")
+	}
+}
+
+func TestAllocatePagePrealloc(test *testing.T) {
+	d, diskFile := getFreeListTestDiskMgr(test, 0)
+	diskFile.Close()
+	d.PreallocPages = 8
+	diskFile, _ = diskmgr.GetDiskFileMgr(d)
+	for expected := 1; expected <= 3; expected++ {
+		if pageId, err := diskFile.AllocatePage(); err != nil || pageId != expected {
+			test.Errorf("allocate page with preallocation returned %d, expected %d: %v", pageId, expected, err)
+		}
+	}
+	dbFileInfo, _ := os.Stat(d.DbFilePath)
+	if diskFile.GetPageCount() != 4 || dbFileInfo.Size() != int64(9*constants.PageSize) {
+		test.Errorf("file of %d bytes with %d pages, expected 8 preallocated pages after the superblock", dbFileInfo.Size(), diskFile.GetPageCount())
+	}
+	if err := diskFile.ReadPage(4, make([]byte, constants.PageSize)); !errors.Is(err, diskmgr.ErrPageNotFound) {
+		test.Errorf("read of a preallocated page does not return ErrPageNotFound")
+	}
+	if err := diskFile.WritePage(5, make([]byte, constants.PageSize)); !errors.Is(err, diskmgr.ErrPageBeyondEOF) {
+		test.Errorf("write past the allocated pages into the preallocated ones does not return ErrPageBeyondEOF")
+	}
+	diskFile.Close()
+
+	// the preallocated zero pages are not pages after a reopen, and the file grows past them again
+	reopened, _ := diskmgr.GetDiskFileMgr(d)
+	if reopened.GetPageCount() != 4 {
+		test.Errorf("reopened file has %d pages, expected 4", reopened.GetPageCount())
+	}
+	for expected := 4; expected <= 12; expected++ {
+		if pageId, err := reopened.AllocatePage(); err != nil || pageId != expected {
+			test.Errorf("allocate page after a reopen returned %d, expected %d: %v", pageId, expected, err)
+		}
+	}
+	dbFileInfo, _ = os.Stat(d.DbFilePath)
+	if reopened.GetPageCount() != 13 || dbFileInfo.Size() != int64(17*constants.PageSize) {
+		test.Errorf("file of %d bytes with %d pages after growing past the preallocated pages", dbFileInfo.Size(), reopened.GetPageCount())
+	}
+	reopened.Close()
+
+	d.PreallocPages = -1
+	if _, err := diskmgr.GetDiskFileMgr(d); !errors.Is(err, diskmgr.ErrInvalidPreallocPages) {
+		test.Errorf("negative preallocated pages does not return ErrInvalidPreallocPages")
+	}
+}
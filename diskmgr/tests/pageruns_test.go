@@ -0,0 +1,121 @@
+package diskmgr
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/rohithputha/HymStMgr/constants"
+	"github.com/rohithputha/HymStMgr/diskmgr"
+	"github.com/rohithputha/HymStMgr/diskmgr/disktest"
+)
+
+// getPageRunsTestPages returns a page buffer for every pageId, filled with the pageId
+func getPageRunsTestPages(pageIds []int) [][]byte {
+	pagesData := make([][]byte, len(pageIds))
+	for i, pageId := range pageIds {
+		pagesData[i] = make([]byte, constants.PageSize)
+		for j := constants.PageHeaderSize; j < constants.PageSize; j++ {
+			pagesData[i][j] = byte(pageId)
+		}
+	}
+	return pagesData
+}
+
+func TestWritePagesReadPages(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
+	// more pages than one run holds, and a run that grows the file after pages that are already there
+	pageIds := make([]int, 0)
+	for pageId := 1; pageId <= 2*constants.IORunPages+10; pageId++ {
+		pageIds = append(pageIds, pageId)
+	}
+	if err := diskFile.WritePages(pageIds[:5], getPageRunsTestPages(pageIds[:5])); err != nil {
+		test.Errorf("write pages failed: %v", err)
+		return
+	}
+	if err := diskFile.WritePages(pageIds, getPageRunsTestPages(pageIds)); err != nil || diskFile.GetPageCount() != len(pageIds)+1 {
+		test.Errorf("write pages that grow the file not working as expected: %v", err)
+		return
+	}
+
+	readData := make([][]byte, len(pageIds))
+	for i := range readData {
+		readData[i] = make([]byte, constants.PageSize)
+	}
+	for i, err := range diskFile.ReadPages(pageIds, readData) {
+		if err != nil || readData[i][constants.PageHeaderSize] != byte(pageIds[i]) || readData[i][constants.PageSize-1] != byte(pageIds[i]) {
+			test.Errorf("read pages of pageId %d not working as expected: %v", pageIds[i], err)
+		}
+	}
+	pageData := make([]byte, constants.PageSize)
+	if err := diskFile.ReadPage(70, pageData); err != nil || pageData[constants.PageHeaderSize] != 70 {
+		test.Errorf("page written by write pages not read by read page: %v", err)
+	}
+	diskFile.Close()
+}
+
+func TestWritePagesNotContiguous(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
+	diskFile.WritePages([]int{1, 2, 3, 4, 5, 6}, getPageRunsTestPages([]int{1, 2, 3, 4, 5, 6}))
+	pageIds := []int{5, 1, 2, 4, 7}
+	if err := diskFile.WritePages(pageIds, getPageRunsTestPages([]int{50, 10, 20, 40, 70})); err != nil {
+		test.Errorf("write pages in any order failed: %v", err)
+	}
+	readIds := []int{1, 2, 3, 4, 5, 7}
+	readData := getPageRunsTestPages(readIds)
+	readErrs := diskFile.ReadPages(readIds, readData)
+	for i, expected := range []byte{10, 20, 3, 40, 50, 70} {
+		if readErrs[i] != nil || readData[i][constants.PageHeaderSize] != expected {
+			test.Errorf("pageId %d holds %d after write pages, expected %d", readIds[i], readData[i][constants.PageHeaderSize], expected)
+		}
+	}
+	diskFile.Close()
+}
+
+func TestWritePagesErrors(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
+	if err := diskFile.WritePages([]int{0, 1}, getPageRunsTestPages([]int{0, 1})); !errors.Is(err, diskmgr.ErrReservedPage) || diskFile.GetPageCount() != 1 {
+		test.Errorf("write pages to the superblock does not return ErrReservedPage")
+	}
+	if err := diskFile.WritePages([]int{2, 3}, getPageRunsTestPages([]int{2, 3})); !errors.Is(err, diskmgr.ErrPageBeyondEOF) {
+		test.Errorf("write pages past the end of the file does not return ErrPageBeyondEOF")
+	}
+	if err := diskFile.WritePages([]int{1}, [][]byte{make([]byte, 100)}); !errors.Is(err, diskmgr.ErrPageBufferTooSmall) {
+		test.Errorf("write pages of a short buffer does not return ErrPageBufferTooSmall")
+	}
+	if err := diskFile.WritePages([]int{1, 2}, getPageRunsTestPages([]int{1})); !errors.Is(err, diskmgr.ErrPageBufferTooSmall) {
+		test.Errorf("write pages with fewer buffers than pageIds does not fail")
+	}
+	diskFile.Close()
+}
+
+func TestReadPagesErrors(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
+	diskFile.WritePages([]int{1, 2, 3, 4}, getPageRunsTestPages([]int{1, 2, 3, 4}))
+	dbFile, _ := os.OpenFile(d.DbFilePath, os.O_RDWR, 0644)
+	dbFile.WriteAt([]byte{0xff}, int64(2*constants.PageSize)+2000) // torn write on page 2
+	dbFile.Close()
+
+	// one bad page in a run does not fail the pages around it
+	pageIds := []int{0, 1, 2, 3, 4, 5}
+	readData := getPageRunsTestPages([]int{0, 0, 0, 0, 0, 0})
+	readErrs := diskFile.ReadPages(pageIds, readData)
+	if !errors.Is(readErrs[0], diskmgr.ErrReservedPage) || !errors.Is(readErrs[2], diskmgr.ErrPageCorrupted) || !errors.Is(readErrs[5], diskmgr.ErrPageNotFound) {
+		test.Errorf("read pages errors not working as expected: %v", readErrs)
+	}
+	for _, i := range []int{1, 3, 4} {
+		if readErrs[i] != nil || readData[i][constants.PageHeaderSize] != byte(pageIds[i]) {
+			test.Errorf("read pages of good pageId %d failed: %v", pageIds[i], readErrs[i])
+		}
+	}
+	diskFile.Close()
+	for _, err := range diskFile.ReadPages([]int{1, 2}, readData[:2]) {
+		if !errors.Is(err, diskmgr.ErrClosed) {
+			test.Errorf("read pages after close does not return ErrClosed")
+		}
+	}
+}
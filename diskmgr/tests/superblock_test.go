@@ -0,0 +1,222 @@
+package diskmgr
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rohithputha/HymStMgr/constants"
+	"github.com/rohithputha/HymStMgr/diskmgr"
+	"github.com/rohithputha/HymStMgr/diskmgr/disktest"
+)
+
+func TestSuperblockNewFile(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
+	sb := diskFile.GetSuperblock()
+	if diskFile.GetPageCount() != 1 || sb.FormatVersion != diskmgr.FormatVersion || sb.PageSize != uint32(constants.PageSize) || sb.CreatedAt == 0 {
+		test.Errorf("superblock of a new db file not working as expected")
+	}
+	if sb.FreeListHead != 0 || sb.CatalogRoot != 0 {
+		test.Errorf("superblock of a new db file has a free list or catalog root")
+	}
+}
+
+func TestSuperblockReopen(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
+	diskFile.WritePage(1, make([]byte, constants.PageSize))
+	if err := diskFile.SetCatalogRoot(1); err != nil {
+		test.Errorf("set catalog root failed: %v", err)
+	}
+
+	reopened, _ := diskmgr.GetDiskFileMgr(d)
+	if reopened.GetSuperblock() != diskFile.GetSuperblock() || reopened.GetSuperblock().CatalogRoot != 1 || reopened.GetPageCount() != 2 {
+		test.Errorf("superblock not the same after reopen")
+	}
+}
+
+func TestSuperblockRejectsNonDbFile(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	os.WriteFile(d.DbFilePath, make([]byte, 2*constants.PageSize), 0644)
+	if _, err := diskmgr.GetDiskFileMgr(d); !errors.Is(err, diskmgr.ErrNotDbFile) {
+		test.Errorf("open of a file without a superblock does not return ErrNotDbFile: %v", err)
+	}
+
+	os.WriteFile(d.DbFilePath, []byte("short"), 0644)
+	if _, err := diskmgr.GetDiskFileMgr(d); !errors.Is(err, diskmgr.ErrNotDbFile) {
+		test.Errorf("open of a file smaller than a page does not return ErrNotDbFile: %v", err)
+	}
+}
+
+func TestSuperblockRejectsPageSizeMismatch(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	diskmgr.GetDiskFileMgr(d)
+
+	dbFile, _ := os.OpenFile(d.DbFilePath, os.O_RDWR, 0644)
+	pageSize := make([]byte, 4)
+	binary.LittleEndian.PutUint32(pageSize, uint32(2*constants.PageSize))
+	// both copies of the superblock, magic (8) and version (4) come before the page size
+	dbFile.WriteAt(pageSize, int64(constants.PageHeaderSize+12))
+	dbFile.WriteAt(pageSize, int64(constants.PageHeaderSize+constants.PageSize/2+12))
+	dbFile.Close()
+
+	if _, err := diskmgr.GetDiskFileMgr(d); !errors.Is(err, diskmgr.ErrPageSizeMismatch) {
+		test.Errorf("open of a file with another page size does not return ErrPageSizeMismatch: %v", err)
+	}
+}
+
+func TestSuperblockRejectsVersion(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	diskmgr.GetDiskFileMgr(d)
+
+	dbFile, _ := os.OpenFile(d.DbFilePath, os.O_RDWR, 0644)
+	version := make([]byte, 4)
+	binary.LittleEndian.PutUint32(version, diskmgr.FormatVersion+1)
+	dbFile.WriteAt(version, int64(constants.PageHeaderSize+8))
+	dbFile.WriteAt(version, int64(constants.PageHeaderSize+constants.PageSize/2+8))
+	dbFile.Close()
+
+	if _, err := diskmgr.GetDiskFileMgr(d); !errors.Is(err, diskmgr.ErrIncompatibleVersion) {
+		test.Errorf("open of a file with another format version does not return ErrIncompatibleVersion: %v", err)
+	}
+}
+
+func TestSuperblockCorrupted(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	diskmgr.GetDiskFileMgr(d)
+
+	dbFile, _ := os.OpenFile(d.DbFilePath, os.O_RDWR, 0644)
+	dbFile.WriteAt([]byte{0xff}, int64(constants.PageHeaderSize+40)) // the catalog root of both copies
+	dbFile.WriteAt([]byte{0xff}, int64(constants.PageHeaderSize+constants.PageSize/2+40))
+	dbFile.Close()
+
+	if _, err := diskmgr.GetDiskFileMgr(d); !errors.Is(err, diskmgr.ErrPageCorrupted) {
+		test.Errorf("open of a file with a corrupted superblock does not return ErrPageCorrupted: %v", err)
+	}
+}
+
+// a torn write of the superblock only hits the newest copy, the open falls back to the older one
+func TestSuperblockTornWrite(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
+	diskFile.WritePage(1, make([]byte, constants.PageSize))
+	diskFile.WritePage(2, make([]byte, constants.PageSize))
+	diskFile.SetCatalogRoot(1)
+	older := diskFile.GetSuperblock()
+	diskFile.SetCatalogRoot(2)
+	newest := diskFile.GetSuperblock()
+	diskFile.Close()
+
+	reopened, _ := diskmgr.GetDiskFileMgr(d)
+	if reopened.GetSuperblock() != newest || newest.Generation != older.Generation+1 {
+		test.Errorf("open does not pick the newest copy of the superblock")
+	}
+	reopened.Close()
+
+	dbFile, _ := os.OpenFile(d.DbFilePath, os.O_RDWR, 0644)
+	dbFile.WriteAt([]byte{0xff}, int64(constants.PageHeaderSize+int(newest.Generation%2)*constants.PageSize/2+40))
+	dbFile.Close()
+
+	reopened, err := diskmgr.GetDiskFileMgr(d)
+	if err != nil || reopened.GetSuperblock() != older || reopened.GetSuperblock().CatalogRoot != 1 {
+		test.Errorf("open with a torn newest superblock does not fall back to the older copy: %v", err)
+	}
+	reopened.Close()
+}
+
+func TestSuperblockCustomPageSize(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	d.PageSize = 1024
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
+	writeData := make([]byte, 1024)
+	writeData[1000] = 7
+	diskFile.WritePage(1, writeData)
+	diskFile.Close()
+
+	fileInfo, _ := os.Stat(d.DbFilePath)
+	reopened, err := diskmgr.GetDiskFileMgr(d)
+	readData := make([]byte, 1024)
+	if err != nil || fileInfo.Size() != 2048 || reopened.GetSuperblock().PageSize != 1024 || reopened.ReadPage(1, readData) != nil || readData[1000] != 7 {
+		test.Errorf("db file with a custom page size not working as expected")
+	}
+	reopened.Close()
+
+	d.PageSize = 0
+	if _, err := diskmgr.GetDiskFileMgr(d); !errors.Is(err, diskmgr.ErrPageSizeMismatch) {
+		test.Errorf("open with the default page size does not return ErrPageSizeMismatch: %v", err)
+	}
+	d.PageSize = 1000
+	if _, err := diskmgr.GetDiskFileMgr(d); !errors.Is(err, diskmgr.ErrInvalidPageSize) {
+		test.Errorf("open with a bad page size does not return ErrInvalidPageSize: %v", err)
+	}
+}
+
+func TestSyncOnFlush(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	d.SyncPolicy = diskmgr.SyncOnFlush
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
+	writeData := make([]byte, constants.PageSize)
+	writeData[100] = 3
+	diskFile.WritePage(1, writeData)
+	if err := diskFile.Sync(); err != nil {
+		test.Errorf("sync failed: %v", err)
+	}
+	readData := make([]byte, constants.PageSize)
+	if diskFile.ReadPage(1, readData) != nil || readData[100] != 3 {
+		test.Errorf("write page with sync on flush not working as expected")
+	}
+}
+
+func TestSyncNever(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	d.SyncPolicy = diskmgr.SyncNever
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
+	writeData := make([]byte, constants.PageSize)
+	writeData[100] = 5
+	if diskFile.WritePage(1, writeData) != nil || diskFile.Sync() != nil || diskFile.Close() != nil {
+		test.Errorf("write page with sync never not working as expected")
+	}
+	reopened, _ := diskmgr.GetDiskFileMgr(d)
+	readData := make([]byte, constants.PageSize)
+	if reopened.ReadPage(1, readData) != nil || readData[100] != 5 {
+		test.Errorf("page written with sync never not there after a clean close")
+	}
+	reopened.Close()
+}
+
+func TestSyncPeriodic(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	d.SyncPolicy = diskmgr.SyncPeriodic
+	d.SyncInterval = time.Millisecond
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
+	writeData := make([]byte, constants.PageSize)
+	// the periodic sync runs alongside the writes
+	for pageId := 1; pageId <= 20; pageId++ {
+		writeData[100] = byte(pageId)
+		if err := diskFile.WritePage(pageId, writeData); err != nil {
+			test.Errorf("write page with periodic sync failed: %v", err)
+		}
+		time.Sleep(100 * time.Microsecond)
+	}
+	if err := diskFile.Close(); err != nil {
+		test.Errorf("close with periodic sync failed: %v", err)
+	}
+	if err := diskFile.Sync(); !errors.Is(err, diskmgr.ErrClosed) {
+		test.Errorf("sync after close does not return ErrClosed")
+	}
+}
+
+func TestInvalidSyncPolicy(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	d.SyncPolicy = diskmgr.SyncPolicy(99)
+	if _, err := diskmgr.GetDiskFileMgr(d); !errors.Is(err, diskmgr.ErrInvalidSyncPolicy) {
+		test.Errorf("unknown sync policy does not return ErrInvalidSyncPolicy")
+	}
+	d.SyncPolicy, d.SyncInterval = diskmgr.SyncPeriodic, -time.Second
+	if _, err := diskmgr.GetDiskFileMgr(d); !errors.Is(err, diskmgr.ErrInvalidSyncPolicy) {
+		test.Errorf("negative sync interval does not return ErrInvalidSyncPolicy")
+	}
+}
@@ -0,0 +1,14 @@
+package logmgr
+
+import "errors"
+
+// errors returned by the log manager, callers should match them with errors.Is as they are wrapped with the lsns
+var (
+	ErrLogRecordCorrupted = errors.New("log record is corrupted")
+
+	// ErrLsnOutOfRange is returned by Flush past the last appended lsn and by TruncateBefore past the flushed lsn
+	ErrLsnOutOfRange = errors.New("lsn is beyond the log")
+
+	// ErrNotCheckpointEnd is returned by GetCheckpointData for a record that is not a checkpoint end record
+	ErrNotCheckpointEnd = errors.New("log record is not a checkpoint end record")
+)
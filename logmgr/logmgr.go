@@ -0,0 +1,216 @@
+package logmgr
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/rohithputha/HymStMgr/constants"
+	"github.com/rohithputha/HymStMgr/diskmgr"
+)
+
+type LogMgr interface {
+	AppendLogRecord(logRecord *LogRecord) (lsn int64, appendErr error)
+	Flush(upToLsn int64) (flushErr error)
+	GetFlushedLsn() int64
+	GetLastLsn() int64
+	GetLogIterator() *LogIterator
+	TruncateBefore(lsn int64) (truncErr error)
+}
+
+type LogMgrStr struct {
+	diskMgr    diskmgr.DiskFileMgr
+	logBuffer  []byte
+	nextLsn    int64
+	flushedLsn int64
+	logMux     *sync.Mutex
+}
+
+/*
+GetLogMgr builds the log manager on top of the log file of the disk manager.
+the existing log is scanned to find the last lsn, lsns keep increasing from there.
+if the scan ends in a torn record (crash during a log write) the tail of the log is cut off so that new records are readable.
+*/
+func GetLogMgr(diskMgr diskmgr.DiskFileMgr) (LogMgr, error) {
+	logMgr := LogMgrStr{
+		diskMgr:    diskMgr,
+		logBuffer:  make([]byte, 0, constants.LogBufferSize),
+		nextLsn:    InvalidLsn + 1,
+		flushedLsn: InvalidLsn,
+		logMux:     &sync.Mutex{},
+	}
+
+	logIter := logMgr.GetLogIterator()
+	for {
+		logRecord, readErr := logIter.Next()
+		if readErr == io.EOF {
+			break
+		}
+		if readErr == ErrLogRecordCorrupted {
+			if truncErr := diskMgr.TruncateLogTail(logIter.GetOffset()); truncErr != nil {
+				return nil, truncErr
+			}
+			break
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+		logMgr.nextLsn = logRecord.Lsn + 1
+	}
+	logMgr.flushedLsn = logMgr.nextLsn - 1
+	return &logMgr, nil
+}
+
+// AppendLogRecord assigns the next lsn to the record and puts it in the log buffer, the record is durable only after Flush
+func (lm *LogMgrStr) AppendLogRecord(logRecord *LogRecord) (lsn int64, appendErr error) {
+	lm.logMux.Lock()
+	defer lm.logMux.Unlock()
+
+	logRecord.Lsn = lm.nextLsn
+	recSize := logRecord.getSize()
+	if len(lm.logBuffer)+recSize > cap(lm.logBuffer) {
+		if flushErr := lm.flushBuffer(); flushErr != nil {
+			return InvalidLsn, flushErr
+		}
+	}
+	if recSize > cap(lm.logBuffer) {
+		// record does not fit in the buffer at all, write it out directly
+		recBytes := make([]byte, recSize)
+		logRecord.serialize(recBytes)
+		if writeErr := lm.diskMgr.WriteLog(recBytes); writeErr != nil {
+			return InvalidLsn, writeErr
+		}
+		lm.nextLsn++
+		lm.flushedLsn = logRecord.Lsn
+		return logRecord.Lsn, nil
+	}
+	bufLen := len(lm.logBuffer)
+	lm.logBuffer = lm.logBuffer[:bufLen+recSize]
+	logRecord.serialize(lm.logBuffer[bufLen:])
+	lm.nextLsn++
+	return logRecord.Lsn, nil
+}
+
+// Flush makes sure every record up to upToLsn is on disk. everything in the buffer is written, so later records also become durable.
+func (lm *LogMgrStr) Flush(upToLsn int64) (flushErr error) {
+	lm.logMux.Lock()
+	defer lm.logMux.Unlock()
+
+	if upToLsn <= lm.flushedLsn {
+		return nil
+	}
+	if upToLsn >= lm.nextLsn {
+		return fmt.Errorf("%w: flush lsn %d, last appended lsn %d", ErrLsnOutOfRange, upToLsn, lm.nextLsn-1)
+	}
+	return lm.flushBuffer()
+}
+
+func (lm *LogMgrStr) flushBuffer() (flushErr error) {
+	if len(lm.logBuffer) == 0 {
+		return nil
+	}
+	if writeErr := lm.diskMgr.WriteLog(lm.logBuffer); writeErr != nil {
+		return writeErr
+	}
+	lm.logBuffer = lm.logBuffer[:0]
+	lm.flushedLsn = lm.nextLsn - 1
+	return nil
+}
+
+func (lm *LogMgrStr) GetFlushedLsn() int64 {
+	lm.logMux.Lock()
+	defer lm.logMux.Unlock()
+	return lm.flushedLsn
+}
+
+func (lm *LogMgrStr) GetLastLsn() int64 {
+	lm.logMux.Lock()
+	defer lm.logMux.Unlock()
+	return lm.nextLsn - 1
+}
+
+/*
+TruncateBefore drops every record with an lsn smaller than lsn from the log file.
+the caller (checkpoint) decides the oldest lsn still needed by recovery, records from there on have to be flushed already.
+the last flushed record is always kept: GetLogMgr continues the lsns from the last record in the log, an empty log would start them over at 1.
+*/
+func (lm *LogMgrStr) TruncateBefore(lsn int64) (truncErr error) {
+	lm.logMux.Lock()
+	defer lm.logMux.Unlock()
+
+	if lsn > lm.flushedLsn+1 {
+		return fmt.Errorf("%w: truncate lsn %d, flushed lsn %d", ErrLsnOutOfRange, lsn, lm.flushedLsn)
+	}
+	lsn = min(lsn, lm.flushedLsn)
+	logIter := lm.GetLogIterator()
+	logStart := logIter.GetOffset()
+	for {
+		offset := logIter.GetOffset()
+		logRecord, readErr := logIter.Next()
+		if readErr == io.EOF || (readErr == nil && logRecord.Lsn >= lsn) {
+			if offset == logStart {
+				return nil
+			}
+			return lm.diskMgr.DiscardLogPrefix(offset)
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+// GetLogIterator returns an iterator over the records that are on disk, from the start of the log (the first record that is not truncated)
+func (lm *LogMgrStr) GetLogIterator() *LogIterator {
+	return &LogIterator{diskMgr: lm.diskMgr, offset: lm.diskMgr.GetLogStart()}
+}
+
+type LogIterator struct {
+	diskMgr diskmgr.DiskFileMgr
+	offset  int64
+}
+
+/*
+Next reads the record at the current offset and moves the offset past it.
+io.EOF is returned at the clean end of the log, ErrLogRecordCorrupted if the record is partial or fails the checksum.
+the offset is not moved on error.
+*/
+func (li *LogIterator) Next() (logRecord *LogRecord, readErr error) {
+	header := make([]byte, logRecordHeaderSize)
+	numRead, readErr := li.diskMgr.ReadLog(header, li.offset)
+	if numRead == 0 && readErr == io.EOF {
+		return nil, io.EOF
+	}
+	if numRead < logRecordHeaderSize {
+		return nil, ErrLogRecordCorrupted
+	}
+	bodySize := int64(binary.LittleEndian.Uint32(header[0:]))
+	checksum := binary.LittleEndian.Uint64(header[4:])
+	if li.offset+int64(logRecordHeaderSize)+bodySize > li.diskMgr.GetLogSize() {
+		return nil, ErrLogRecordCorrupted
+	}
+
+	body := make([]byte, bodySize)
+	numRead, readErr = li.diskMgr.ReadLog(body, li.offset+int64(logRecordHeaderSize))
+	if int64(numRead) < bodySize {
+		if readErr == nil || readErr == io.EOF {
+			return nil, ErrLogRecordCorrupted
+		}
+		return nil, readErr
+	}
+	logRecord, readErr = deserializeLogRecord(body, checksum)
+	if readErr != nil {
+		return nil, readErr
+	}
+	li.offset += int64(logRecordHeaderSize) + bodySize
+	return logRecord, nil
+}
+
+// GetOffset returns the file offset of the record that the next call to Next reads
+func (li *LogIterator) GetOffset() int64 {
+	return li.offset
+}
+
+func (li *LogIterator) SetOffset(offset int64) {
+	li.offset = offset
+}
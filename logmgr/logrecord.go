@@ -0,0 +1,180 @@
+package logmgr
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+type LogRecordType uint8
+
+const (
+	LogInvalid LogRecordType = iota
+	LogBegin
+	LogCommit
+	LogAbort
+	LogEnd
+	LogUpdate
+	LogCompensate
+	LogCheckpointBegin
+	LogCheckpointEnd
+)
+
+const InvalidLsn int64 = 0
+
+// on disk every record is laid out as (bodySize uint32, checksum uint64, body)
+// checksum is the xxhash of the body, a record with a bad checksum is treated as torn.
+const logRecordHeaderSize int = 12
+
+// lsn, prevLsn, txnId, recType, pageId, offset, undoNextLsn, beforeImage len, afterImage len
+const logRecordFixedBodySize int = 8 + 8 + 8 + 1 + 8 + 4 + 8 + 4 + 4
+
+/*
+LogRecord is a single entry in the WAL.
+for updates and compensation records the PageId, Offset and the images describe the physical change on the page,
+UndoNextLsn is only used by compensation records to point to the next record to undo for the txn.
+*/
+type LogRecord struct {
+	Lsn         int64
+	PrevLsn     int64
+	TxnId       int64
+	RecType     LogRecordType
+	PageId      int
+	Offset      int
+	UndoNextLsn int64
+	BeforeImage []byte
+	AfterImage  []byte
+}
+
+func (lr *LogRecord) getBodySize() int {
+	return logRecordFixedBodySize + len(lr.BeforeImage) + len(lr.AfterImage)
+}
+
+func (lr *LogRecord) getSize() int {
+	return logRecordHeaderSize + lr.getBodySize()
+}
+
+// serialize writes the header and the body of the record into buf, buf should be at least getSize() long
+func (lr *LogRecord) serialize(buf []byte) {
+	body := buf[logRecordHeaderSize:lr.getSize()]
+	binary.LittleEndian.PutUint64(body[0:], uint64(lr.Lsn))
+	binary.LittleEndian.PutUint64(body[8:], uint64(lr.PrevLsn))
+	binary.LittleEndian.PutUint64(body[16:], uint64(lr.TxnId))
+	body[24] = byte(lr.RecType)
+	binary.LittleEndian.PutUint64(body[25:], uint64(lr.PageId))
+	binary.LittleEndian.PutUint32(body[33:], uint32(lr.Offset))
+	binary.LittleEndian.PutUint64(body[37:], uint64(lr.UndoNextLsn))
+	binary.LittleEndian.PutUint32(body[45:], uint32(len(lr.BeforeImage)))
+	binary.LittleEndian.PutUint32(body[49:], uint32(len(lr.AfterImage)))
+	copy(body[logRecordFixedBodySize:], lr.BeforeImage)
+	copy(body[logRecordFixedBodySize+len(lr.BeforeImage):], lr.AfterImage)
+
+	binary.LittleEndian.PutUint32(buf[0:], uint32(len(body)))
+	binary.LittleEndian.PutUint64(buf[4:], xxhash.Sum64(body))
+}
+
+func deserializeLogRecord(body []byte, checksum uint64) (logRecord *LogRecord, desErr error) {
+	if len(body) < logRecordFixedBodySize || xxhash.Sum64(body) != checksum {
+		return nil, ErrLogRecordCorrupted
+	}
+	logRecord = &LogRecord{
+		Lsn:         int64(binary.LittleEndian.Uint64(body[0:])),
+		PrevLsn:     int64(binary.LittleEndian.Uint64(body[8:])),
+		TxnId:       int64(binary.LittleEndian.Uint64(body[16:])),
+		RecType:     LogRecordType(body[24]),
+		PageId:      int(binary.LittleEndian.Uint64(body[25:])),
+		Offset:      int(binary.LittleEndian.Uint32(body[33:])),
+		UndoNextLsn: int64(binary.LittleEndian.Uint64(body[37:])),
+	}
+	beforeLen := int(binary.LittleEndian.Uint32(body[45:]))
+	afterLen := int(binary.LittleEndian.Uint32(body[49:]))
+	if logRecordFixedBodySize+beforeLen+afterLen != len(body) {
+		return nil, ErrLogRecordCorrupted
+	}
+	logRecord.BeforeImage = append([]byte(nil), body[logRecordFixedBodySize:logRecordFixedBodySize+beforeLen]...)
+	logRecord.AfterImage = append([]byte(nil), body[logRecordFixedBodySize+beforeLen:]...)
+	return logRecord, nil
+}
+
+/*
+CheckpointData is the content of a checkpoint end record: the active txn table and the dirty page table
+as they were at some point after the checkpoint begin record (fuzzy checkpoint).
+*/
+type CheckpointData struct {
+	BeginLsn   int64
+	NextTxnId  int64
+	ActiveTxns map[int64]CheckpointTxn
+	DirtyPages map[int]int64 // pageId -> recLsn
+}
+
+type CheckpointTxn struct {
+	FirstLsn  int64
+	LastLsn   int64
+	Committed bool
+}
+
+// GetCheckpointEndRecord builds the checkpoint end record, the tables are encoded in the after image
+func GetCheckpointEndRecord(ckptData *CheckpointData) *LogRecord {
+	encoded := make([]byte, 24+len(ckptData.ActiveTxns)*25+8+len(ckptData.DirtyPages)*16)
+	binary.LittleEndian.PutUint64(encoded[0:], uint64(ckptData.BeginLsn))
+	binary.LittleEndian.PutUint64(encoded[8:], uint64(ckptData.NextTxnId))
+	binary.LittleEndian.PutUint64(encoded[16:], uint64(len(ckptData.ActiveTxns)))
+	pos := 24
+	for txnId, ckptTxn := range ckptData.ActiveTxns {
+		binary.LittleEndian.PutUint64(encoded[pos:], uint64(txnId))
+		binary.LittleEndian.PutUint64(encoded[pos+8:], uint64(ckptTxn.FirstLsn))
+		binary.LittleEndian.PutUint64(encoded[pos+16:], uint64(ckptTxn.LastLsn))
+		if ckptTxn.Committed {
+			encoded[pos+24] = 1
+		}
+		pos += 25
+	}
+	binary.LittleEndian.PutUint64(encoded[pos:], uint64(len(ckptData.DirtyPages)))
+	pos += 8
+	for pageId, recLsn := range ckptData.DirtyPages {
+		binary.LittleEndian.PutUint64(encoded[pos:], uint64(pageId))
+		binary.LittleEndian.PutUint64(encoded[pos+8:], uint64(recLsn))
+		pos += 16
+	}
+	return &LogRecord{RecType: LogCheckpointEnd, AfterImage: encoded}
+}
+
+func (lr *LogRecord) GetCheckpointData() (ckptData *CheckpointData, decErr error) {
+	encoded := lr.AfterImage
+	if lr.RecType != LogCheckpointEnd {
+		return nil, fmt.Errorf("%w: lsn %d has type %d", ErrNotCheckpointEnd, lr.Lsn, lr.RecType)
+	}
+	if len(encoded) < 24 {
+		return nil, ErrLogRecordCorrupted
+	}
+	ckptData = &CheckpointData{
+		BeginLsn:   int64(binary.LittleEndian.Uint64(encoded[0:])),
+		NextTxnId:  int64(binary.LittleEndian.Uint64(encoded[8:])),
+		ActiveTxns: make(map[int64]CheckpointTxn),
+		DirtyPages: make(map[int]int64),
+	}
+	numTxns := int(binary.LittleEndian.Uint64(encoded[16:]))
+	pos := 24
+	if len(encoded) < pos+numTxns*25+8 {
+		return nil, ErrLogRecordCorrupted
+	}
+	for range numTxns {
+		ckptData.ActiveTxns[int64(binary.LittleEndian.Uint64(encoded[pos:]))] = CheckpointTxn{
+			FirstLsn:  int64(binary.LittleEndian.Uint64(encoded[pos+8:])),
+			LastLsn:   int64(binary.LittleEndian.Uint64(encoded[pos+16:])),
+			Committed: encoded[pos+24] == 1,
+		}
+		pos += 25
+	}
+	numPages := int(binary.LittleEndian.Uint64(encoded[pos:]))
+	pos += 8
+	if len(encoded) != pos+numPages*16 {
+		return nil, ErrLogRecordCorrupted
+	}
+	for range numPages {
+		ckptData.DirtyPages[int(binary.LittleEndian.Uint64(encoded[pos:]))] = int64(binary.LittleEndian.Uint64(encoded[pos+8:]))
+		pos += 16
+	}
+	return ckptData, nil
+}
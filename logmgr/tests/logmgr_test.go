@@ -0,0 +1,218 @@
+package logmgr
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/rohithputha/HymStMgr/constants"
+	"github.com/rohithputha/HymStMgr/diskmgr"
+	"github.com/rohithputha/HymStMgr/diskmgr/disktest"
+	"github.com/rohithputha/HymStMgr/logmgr"
+)
+
+func getTestLogMgr(test *testing.T, d diskmgr.DiskFileInit) (diskmgr.DiskFileMgr, logmgr.LogMgr) {
+	diskFile, _ := diskmgr.GetDiskFileMgr(d)
+	logMgr, err := logmgr.GetLogMgr(diskFile)
+	if err != nil {
+		test.Fatalf("log mgr init failed: %v", err)
+	}
+	return diskFile, logMgr
+}
+
+func TestAppendLogRecord(test *testing.T) {
+	_, logMgr := getTestLogMgr(test, disktest.GetFileInit(test))
+	lsn1, err1 := logMgr.AppendLogRecord(&logmgr.LogRecord{RecType: logmgr.LogBegin, TxnId: 1})
+	lsn2, err2 := logMgr.AppendLogRecord(&logmgr.LogRecord{RecType: logmgr.LogCommit, TxnId: 1, PrevLsn: lsn1})
+	if err1 != nil || err2 != nil || lsn1 != 1 || lsn2 != 2 {
+		test.Errorf("append log record lsns not working as expected")
+	}
+	if logMgr.GetFlushedLsn() != logmgr.InvalidLsn || logMgr.GetLastLsn() != 2 {
+		test.Errorf("append log record should not flush the log buffer")
+	}
+}
+
+func TestFlushLog(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	diskFile, logMgr := getTestLogMgr(test, d)
+	logMgr.AppendLogRecord(&logmgr.LogRecord{RecType: logmgr.LogBegin, TxnId: 1})
+	lsn, _ := logMgr.AppendLogRecord(&logmgr.LogRecord{RecType: logmgr.LogCommit, TxnId: 1})
+	if diskFile.GetLogSize() != 0 {
+		test.Errorf("log records written before flush")
+	}
+	if err := logMgr.Flush(lsn); err != nil || logMgr.GetFlushedLsn() != lsn {
+		test.Errorf("flush log not working as expected")
+	}
+	fileInfo, _ := os.Stat(d.LogFilePath)
+	if diskFile.GetLogSize() == 0 || fileInfo.Size() != diskmgr.LogHeaderSize+diskFile.GetLogSize() {
+		test.Errorf("flushed log records are not in the log file")
+	}
+}
+
+func TestFlushLogBeyondLastLsn(test *testing.T) {
+	_, logMgr := getTestLogMgr(test, disktest.GetFileInit(test))
+	lsn, _ := logMgr.AppendLogRecord(&logmgr.LogRecord{RecType: logmgr.LogBegin, TxnId: 1})
+	if err := logMgr.Flush(lsn + 1); !errors.Is(err, logmgr.ErrLsnOutOfRange) {
+		test.Errorf("flush beyond the last lsn should fail")
+	}
+}
+
+func TestLogIterator(test *testing.T) {
+	_, logMgr := getTestLogMgr(test, disktest.GetFileInit(test))
+	before := []byte{1, 2, 3}
+	after := []byte{4, 5, 6}
+	logMgr.AppendLogRecord(&logmgr.LogRecord{RecType: logmgr.LogBegin, TxnId: 7})
+	lsn, _ := logMgr.AppendLogRecord(&logmgr.LogRecord{RecType: logmgr.LogUpdate, TxnId: 7, PrevLsn: 1, PageId: 3, Offset: 100, BeforeImage: before, AfterImage: after})
+	logMgr.Flush(lsn)
+
+	logIter := logMgr.GetLogIterator()
+	rec1, err1 := logIter.Next()
+	rec2, err2 := logIter.Next()
+	_, err3 := logIter.Next()
+	if err1 != nil || err2 != nil || err3 != io.EOF {
+		test.Errorf("log iterator not working as expected")
+		return
+	}
+	if rec1.Lsn != 1 || rec1.RecType != logmgr.LogBegin || rec1.TxnId != 7 {
+		test.Errorf("log iterator begin record not as expected")
+	}
+	if rec2.Lsn != 2 || rec2.PrevLsn != 1 || rec2.PageId != 3 || rec2.Offset != 100 ||
+		!bytes.Equal(rec2.BeforeImage, before) || !bytes.Equal(rec2.AfterImage, after) {
+		test.Errorf("log iterator update record not as expected")
+	}
+}
+
+func TestLogBufferOverflow(test *testing.T) {
+	_, logMgr := getTestLogMgr(test, disktest.GetFileInit(test))
+	image := make([]byte, constants.PageSize)
+	var lastLsn int64
+	for i := 0; i < 40; i++ {
+		lastLsn, _ = logMgr.AppendLogRecord(&logmgr.LogRecord{RecType: logmgr.LogUpdate, TxnId: 1, AfterImage: image, BeforeImage: image})
+	}
+	if logMgr.GetFlushedLsn() == logmgr.InvalidLsn {
+		test.Errorf("full log buffer not written out")
+	}
+	logMgr.Flush(lastLsn)
+	count := 0
+	logIter := logMgr.GetLogIterator()
+	for _, err := logIter.Next(); err == nil; _, err = logIter.Next() {
+		count++
+	}
+	if count != 40 {
+		test.Errorf("log records lost on buffer overflow, found %d", count)
+	}
+}
+
+func TestLogMgrReopen(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	_, logMgr := getTestLogMgr(test, d)
+	logMgr.AppendLogRecord(&logmgr.LogRecord{RecType: logmgr.LogBegin, TxnId: 1})
+	lsn, _ := logMgr.AppendLogRecord(&logmgr.LogRecord{RecType: logmgr.LogCommit, TxnId: 1})
+	logMgr.Flush(lsn)
+	logMgr.AppendLogRecord(&logmgr.LogRecord{RecType: logmgr.LogBegin, TxnId: 2}) // never flushed, lost on "crash"
+
+	_, reopenedLogMgr := getTestLogMgr(test, d)
+	newLsn, _ := reopenedLogMgr.AppendLogRecord(&logmgr.LogRecord{RecType: logmgr.LogBegin, TxnId: 3})
+	if reopenedLogMgr.GetFlushedLsn() != lsn || newLsn != lsn+1 {
+		test.Errorf("log mgr reopen does not continue the lsns")
+	}
+}
+
+func TestLogMgrTornTail(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	_, logMgr := getTestLogMgr(test, d)
+	lsn, _ := logMgr.AppendLogRecord(&logmgr.LogRecord{RecType: logmgr.LogBegin, TxnId: 1})
+	logMgr.Flush(lsn)
+
+	logFile, _ := os.OpenFile(d.LogFilePath, os.O_WRONLY|os.O_APPEND, 0644)
+	logFile.Write([]byte{40, 0, 0, 0, 9, 9, 9}) // half written record
+	logFile.Close()
+
+	_, reopenedLogMgr := getTestLogMgr(test, d)
+	newLsn, _ := reopenedLogMgr.AppendLogRecord(&logmgr.LogRecord{RecType: logmgr.LogBegin, TxnId: 2})
+	reopenedLogMgr.Flush(newLsn)
+
+	logIter := reopenedLogMgr.GetLogIterator()
+	logIter.Next()
+	rec, err := logIter.Next()
+	if err != nil || rec.Lsn != newLsn || rec.TxnId != 2 {
+		test.Errorf("torn log tail not cut off on reopen")
+	}
+}
+
+func TestTruncateBefore(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	diskFile, logMgr := getTestLogMgr(test, d)
+	var lastLsn int64
+	for i := 0; i < 10; i++ {
+		lastLsn, _ = logMgr.AppendLogRecord(&logmgr.LogRecord{RecType: logmgr.LogBegin, TxnId: int64(i)})
+	}
+	logMgr.Flush(lastLsn)
+	if err := logMgr.TruncateBefore(6); err != nil || diskFile.GetLogStart() == 0 {
+		test.Errorf("truncate before not working as expected")
+	}
+	rec, err := logMgr.GetLogIterator().Next()
+	if err != nil || rec.Lsn != 6 {
+		test.Errorf("log does not start at the truncate lsn")
+	}
+
+	_, reopenedLogMgr := getTestLogMgr(test, d)
+	if reopenedLogMgr.GetLastLsn() != lastLsn {
+		test.Errorf("lsns not continued after truncate and reopen")
+	}
+}
+
+func TestTruncateBeforeUnflushed(test *testing.T) {
+	_, logMgr := getTestLogMgr(test, disktest.GetFileInit(test))
+	lsn, _ := logMgr.AppendLogRecord(&logmgr.LogRecord{RecType: logmgr.LogBegin, TxnId: 1})
+	logMgr.AppendLogRecord(&logmgr.LogRecord{RecType: logmgr.LogBegin, TxnId: 2})
+	if err := logMgr.TruncateBefore(lsn + 1); !errors.Is(err, logmgr.ErrLsnOutOfRange) {
+		test.Errorf("truncate allowed past the flushed lsn")
+	}
+}
+
+func TestTruncateBeforeKeepsLastRecord(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	_, logMgr := getTestLogMgr(test, d)
+	var lastLsn int64
+	for i := 0; i < 5; i++ {
+		lastLsn, _ = logMgr.AppendLogRecord(&logmgr.LogRecord{RecType: logmgr.LogBegin, TxnId: int64(i)})
+	}
+	logMgr.Flush(lastLsn)
+	if err := logMgr.TruncateBefore(lastLsn + 1); err != nil {
+		test.Errorf("truncate up to the flushed lsn failed: %v", err)
+	}
+	rec, err := logMgr.GetLogIterator().Next()
+	if err != nil || rec.Lsn != lastLsn {
+		test.Errorf("last record not kept by truncate")
+	}
+
+	_, reopenedLogMgr := getTestLogMgr(test, d)
+	newLsn, _ := reopenedLogMgr.AppendLogRecord(&logmgr.LogRecord{RecType: logmgr.LogBegin, TxnId: 6})
+	if newLsn != lastLsn+1 {
+		test.Errorf("lsns started over after truncating the whole log and reopening, got %d", newLsn)
+	}
+}
+
+func TestCheckpointRecord(test *testing.T) {
+	_, logMgr := getTestLogMgr(test, disktest.GetFileInit(test))
+	ckptData := &logmgr.CheckpointData{
+		BeginLsn:   3,
+		NextTxnId:  9,
+		ActiveTxns: map[int64]logmgr.CheckpointTxn{4: {FirstLsn: 1, LastLsn: 2}, 8: {FirstLsn: 2, LastLsn: 3, Committed: true}},
+		DirtyPages: map[int]int64{5: 1, 6: 3},
+	}
+	lsn, _ := logMgr.AppendLogRecord(logmgr.GetCheckpointEndRecord(ckptData))
+	logMgr.Flush(lsn)
+	rec, _ := logMgr.GetLogIterator().Next()
+	readData, err := rec.GetCheckpointData()
+	if err != nil || readData.BeginLsn != 3 || readData.NextTxnId != 9 || len(readData.ActiveTxns) != 2 ||
+		readData.ActiveTxns[8] != ckptData.ActiveTxns[8] || readData.DirtyPages[6] != 3 || len(readData.DirtyPages) != 2 {
+		test.Errorf("checkpoint record encoding not working as expected")
+	}
+	if _, err := (&logmgr.LogRecord{RecType: logmgr.LogBegin}).GetCheckpointData(); !errors.Is(err, logmgr.ErrNotCheckpointEnd) {
+		test.Errorf("checkpoint data of another record type does not return ErrNotCheckpointEnd")
+	}
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"sync"
+
+	"github.com/rohithputha/HymStMgr/constants"
+)
+
+// AccessHint tells the pool how the caller is going to use the pages it fetches
+type AccessHint int
+
+const (
+	AccessNormal         AccessHint = iota
+	AccessSequentialScan            // every page is read once, e.g. a scan over the whole db file
+	AccessBulkWrite                 // many new or changed pages are written once, e.g. a bulk load
+)
+
+/*
+AccessStrategy keeps the pages of one scan or bulk load in a small ring of frames of their own, the way the buffer access strategies
+of postgres do, so that the scan does not flood the pool and evict the hot pages.
+once the ring is full the frame of the oldest ring page is reused for the next miss, pages that come in through the ring are not
+added to the repl pol history. a ring page that is fetched without the strategy is taken over by the pool and leaves the ring.
+a strategy is used by one goroutine at a time, get a strategy for every scan.
+*/
+type AccessStrategy struct {
+	hint  AccessHint
+	rings map[*BuffPoolMgrStr]*accessRing // a ParallelBufferPool strategy has a ring in every shard
+}
+
+// accessRing is the ring of a strategy in one pool, it is only changed under the pool's bpsMux
+type accessRing struct {
+	frames []int // frame indexes, in the order they are reused
+	next   int   // the slot whose frame is reused next, once the ring is full
+	size   int
+}
+
+func NewAccessStrategy(hint AccessHint) *AccessStrategy {
+	return &AccessStrategy{hint: hint, rings: make(map[*BuffPoolMgrStr]*accessRing)}
+}
+
+// ringFrames returns the ring size of the hint, a ring never takes more than an eighth of the pool
+func ringFrames(hint AccessHint, poolFrames int) int {
+	switch hint {
+	case AccessSequentialScan:
+		return min(constants.ScanRingFrames, max(1, poolFrames/8))
+	case AccessBulkWrite:
+		return min(constants.BulkWriteRingFrames, max(1, poolFrames/8))
+	}
+	return 0
+}
+
+// ringOf returns the ring of the strategy in the pool, nil for a nil or normal strategy
+func (as *AccessStrategy) ringOf(bp *BuffPoolMgrStr) *accessRing {
+	if as == nil {
+		return nil
+	}
+	ring, ok := as.rings[bp]
+	if !ok {
+		if size := ringFrames(as.hint, len(bp.pagePool)); size > 0 {
+			ring = &accessRing{size: size}
+		}
+		as.rings[bp] = ring
+	}
+	return ring
+}
+
+/*
+reusableFrame returns the frame of the ring to reuse for the next miss, or -1 if the ring is not full yet or that frame can not be
+reused: it is pinned, or its page left the ring because it was evicted or fetched by someone else.
+*/
+func (ring *accessRing) reusableFrame(bp *BuffPoolMgrStr) int {
+	if ring == nil || len(ring.frames) < ring.size {
+		return -1
+	}
+	pageIndex := ring.frames[ring.next]
+	page := &bp.pagePool[pageIndex]
+	if mappedIndex, ok := bp.pageMap[page.PageId]; page.ring != ring || page.Pin != 0 || !ok || mappedIndex != pageIndex {
+		return -1
+	}
+	return pageIndex
+}
+
+// add puts the frame selected for a miss into the ring, in the slot of the frame it replaces
+func (ring *accessRing) add(bp *BuffPoolMgrStr, pageIndex int) {
+	bp.pagePool[pageIndex].ring = ring
+	if ring == nil {
+		return
+	}
+	if len(ring.frames) < ring.size {
+		ring.frames = append(ring.frames, pageIndex)
+		return
+	}
+	ring.frames[ring.next] = pageIndex
+	ring.next = (ring.next + 1) % ring.size
+}
+
+// FetchPageWithStrategy is FetchPage that loads a missing page into the ring of the strategy, a nil strategy is AccessNormal
+func (bp *BuffPoolMgrStr) FetchPageWithStrategy(pageId int, strategy *AccessStrategy) (page *Page, readErr error) {
+	return bp.fetchPage(pageId, false, strategy)
+}
+
+func (bp *BuffPoolMgrStr) FetchPageReadWithStrategy(pageId int, strategy *AccessStrategy) (guard *ReadPageGuard, fetchErr error) {
+	page, fetchErr := bp.fetchLatchedPage(pageId, strategy, readLatch, (*sync.RWMutex).RUnlock)
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+	return &ReadPageGuard{bp: bp, page: page}, nil
+}
+
+func (bp *BuffPoolMgrStr) FetchPageWriteWithStrategy(pageId int, strategy *AccessStrategy) (guard *WritePageGuard, fetchErr error) {
+	page, fetchErr := bp.fetchLatchedPage(pageId, strategy, writeLatch, (*sync.RWMutex).Unlock)
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+	return &WritePageGuard{bp: bp, page: page}, nil
+}
+
+// NewPageWriteWithStrategy is NewPageWrite that creates the page in the ring of the strategy, for bulk loads
+func (bp *BuffPoolMgrStr) NewPageWriteWithStrategy(strategy *AccessStrategy) (guard *WritePageGuard, newPageErr error) {
+	page, newPageErr := bp.newPage(true, strategy)
+	if newPageErr != nil {
+		return nil, newPageErr
+	}
+	return bp.newWriteGuard(page), nil
+}
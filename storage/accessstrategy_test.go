@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rohithputha/HymStMgr/constants"
+	"github.com/rohithputha/HymStMgr/diskmgr"
+	"github.com/rohithputha/HymStMgr/diskmgr/disktest"
+)
+
+/*
+getScanTestPool returns a pool of 16 frames over numPages pages, with pages 1 to 4 fetched often enough to be the hot ones.
+the pool uses plain lru, a scan floods it without a strategy (lru-k on its own already keeps the pages with k references).
+read-ahead is off, so that only the fetches of the test change which pages are cached.
+*/
+func getScanTestPool(test *testing.T, numPages int) *BuffPoolMgrStr {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{PoolFrames: 16, Replacer: ReplacerLru, ReadAheadPages: -1})
+	for range numPages {
+		bfrPool.NewPage()
+	}
+	for range 4 {
+		for pageId := 1; pageId <= 4; pageId++ {
+			bfrPool.FetchPage(pageId)
+		}
+	}
+	return bfrPool
+}
+
+func hotPagesCached(bp *BuffPoolMgrStr) bool {
+	for pageId := 1; pageId <= 4; pageId++ {
+		if _, ok := bp.pageMap[pageId]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRingFrames(test *testing.T) {
+	if ringFrames(AccessNormal, 1000) != 0 || ringFrames(AccessSequentialScan, 1000) != constants.ScanRingFrames || ringFrames(AccessSequentialScan, 16) != 2 || ringFrames(AccessBulkWrite, 4) != 1 {
+		test.Errorf("ring frames not working as expected")
+	}
+}
+
+func TestSequentialScanKeepsHotPages(test *testing.T) {
+	bfrPool := getScanTestPool(test, 64)
+	checkNoLeakedGuards(test, bfrPool)
+	scan := NewAccessStrategy(AccessSequentialScan)
+	for pageId := 5; pageId <= 64; pageId++ {
+		guard, err := bfrPool.FetchPageReadWithStrategy(pageId, scan)
+		if err != nil || guard.PageId() != pageId {
+			test.Errorf("fetch page read with strategy of pageId %d not working as expected: %v", pageId, err)
+			return
+		}
+		guard.Release()
+	}
+	if !hotPagesCached(bfrPool) {
+		test.Errorf("sequential scan evicted the hot pages")
+	}
+	ring := scan.ringOf(bfrPool)
+	if len(ring.frames) != 2 {
+		test.Errorf("sequential scan used %d ring frames, expected 2", len(ring.frames))
+	}
+	for _, pageIndex := range ring.frames {
+		if bfrPool.pagePool[pageIndex].ring != ring {
+			test.Errorf("frame %d of the ring does not hold a ring page", pageIndex)
+		}
+	}
+
+	// the same scan without a strategy floods the pool
+	for pageId := 5; pageId <= 64; pageId++ {
+		bfrPool.FetchPage(pageId)
+	}
+	if hotPagesCached(bfrPool) {
+		test.Errorf("scan without a strategy did not evict the hot pages, the test does not show anything")
+	}
+}
+
+func TestBulkWriteKeepsHotPages(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	bfrPool := getTestPool(test, d, Options{PoolFrames: 16, Replacer: ReplacerLru})
+	for range 4 {
+		bfrPool.NewPage()
+	}
+	bulkWrite := NewAccessStrategy(AccessBulkWrite)
+	for i := range 60 {
+		guard, err := bfrPool.NewPageWriteWithStrategy(bulkWrite)
+		if err != nil {
+			test.Errorf("new page write with strategy failed: %v", err)
+			return
+		}
+		guard.MutableData()[100] = byte(i + 1)
+		guard.Release()
+	}
+	if !hotPagesCached(bfrPool) || len(bulkWrite.ringOf(bfrPool).frames) != 2 {
+		test.Errorf("bulk write did not stay in its ring")
+	}
+	bfrPool.Close()
+
+	// the pages are written back as their ring frames are reused
+	reopenedPool := getTestPool(test, d, Options{})
+	for i := range 60 {
+		page, err := reopenedPool.FetchPage(5 + i)
+		if err != nil || page.pageData[100] != byte(i+1) {
+			test.Errorf("bulk written pageId %d not durable", 5+i)
+		}
+	}
+	reopenedPool.Close()
+}
+
+func TestRingPageTakenOverByPool(test *testing.T) {
+	bfrPool := getScanTestPool(test, 16)
+	scan := NewAccessStrategy(AccessSequentialScan)
+	bfrPool.FetchPageWithStrategy(5, scan)
+	page, _ := bfrPool.FetchPage(5)
+	if page.ring != nil {
+		test.Errorf("ring page fetched without the strategy is still in the ring")
+	}
+	// the ring skips the frame of page 5 now that it belongs to the pool
+	for pageId := 6; pageId <= 16; pageId++ {
+		bfrPool.FetchPageWithStrategy(pageId, scan)
+	}
+	if _, ok := bfrPool.pageMap[5]; !ok || !hotPagesCached(bfrPool) {
+		test.Errorf("scan reused the frame of a page the pool took over")
+	}
+}
+
+func TestRingSkipsPinnedFrame(test *testing.T) {
+	bfrPool := getScanTestPool(test, 16)
+	scan := NewAccessStrategy(AccessSequentialScan)
+	heldGuard, _ := bfrPool.FetchPageReadWithStrategy(5, scan)
+	for pageId := 6; pageId <= 16; pageId++ {
+		guard, err := bfrPool.FetchPageReadWithStrategy(pageId, scan)
+		if err != nil {
+			test.Errorf("fetch page read with strategy failed: %v", err)
+			return
+		}
+		guard.Release()
+	}
+	if heldGuard.Data() == nil || bfrPool.pagePool[bfrPool.pageMap[5]].PageId != 5 {
+		test.Errorf("scan reused the frame of a pinned ring page")
+	}
+	heldGuard.Release()
+}
+
+func TestNormalStrategy(test *testing.T) {
+	bfrPool := getScanTestPool(test, 16)
+	normal := NewAccessStrategy(AccessNormal)
+	page, err := bfrPool.FetchPageWithStrategy(10, normal)
+	if err != nil || page.ring != nil || normal.ringOf(bfrPool) != nil {
+		test.Errorf("normal strategy not working as expected")
+	}
+	if _, err := bfrPool.FetchPageWithStrategy(1000, nil); !errors.Is(err, diskmgr.ErrPageNotFound) {
+		test.Errorf("fetch page with strategy of a missing page does not fail")
+	}
+}
+
+func TestParallelBufferPoolStrategy(test *testing.T) {
+	parallelPool := getTestParallelPool(test, disktest.GetFileInit(test), Options{PoolFrames: 32}, 2)
+	for range 64 {
+		parallelPool.NewPage()
+	}
+	scan := NewAccessStrategy(AccessSequentialScan)
+	for pageId := 1; pageId <= 64; pageId++ {
+		guard, err := parallelPool.FetchPageReadWithStrategy(pageId, scan)
+		if err != nil {
+			test.Errorf("parallel fetch page read with strategy failed: %v", err)
+			return
+		}
+		guard.Release()
+	}
+	if len(scan.rings) != 2 {
+		test.Errorf("parallel scan does not have a ring in every shard")
+	}
+	for _, shard := range parallelPool.shards {
+		if ring := scan.ringOf(shard); ring == nil || len(ring.frames) != 2 {
+			test.Errorf("parallel scan ring not working as expected")
+		}
+	}
+}
@@ -3,53 +3,207 @@ package storage
 import (
 	"errors"
 	"fmt"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rohithputha/HymStMgr/constants"
 	"github.com/rohithputha/HymStMgr/diskmgr"
+	"github.com/rohithputha/HymStMgr/logmgr"
 	"github.com/rohithputha/HymStMgr/utils"
 )
 
-type buffPoolStats struct {
-	totalReadHit int
-	diskReadHit  int
-	bpReadHit    int
+// pageIO is a read or write back of a page that runs outside bpsMux, other users of the page wait on done and then look at err
+type pageIO struct {
+	done chan struct{}
+	err  error
+}
+
+// LogFlusher should make the log durable at least up to upToLsn, a page is written to disk only after this returns nil (WAL rule)
+type LogFlusher func(upToLsn int64) (flushErr error)
+
+func noopLogFlusher(upToLsn int64) (flushErr error) {
+	return nil
 }
 
 type BuffPoolMgrStr struct {
-	*buffPoolStats
-	replPol  ReplPol
-	pagePool []Page
-	pageMap  map[int]int //mapping from pageId to pagePool index
-	freeSet  utils.ISet[int]
-	pinSet   utils.ISet[int]
-	pagesMem int
-	bpsMux   *sync.Mutex
-	diskMgr  diskmgr.DiskFileMgr
-}
-
-func InitBuffPoolMgr(dikFileInit diskmgr.DiskFileInit) (BuffPoolMgr *BuffPoolMgrStr) {
+	stats        PoolStats // guarded by bpsMux
+	replPol      ReplPol
+	replClock    int64 // logical clock of the repl pol, ticks once for every access and eviction, guarded by bpsMux
+	pagePool     []Page
+	arena        []byte // page data of all the frames, frame i is arena[i*pageSize : (i+1)*pageSize]
+	pageSize     int
+	pageMap      map[int]int     //mapping from pageId to pagePool index
+	inFlight     map[int]*pageIO // pageId -> the read or write back of the page that is running, guarded by bpsMux
+	freeSet      utils.ISet[int]
+	pinSet       utils.ISet[int]
+	pagesMem     int
+	bpsMux       *sync.Mutex
+	allocMux     *sync.Mutex // taken before bpsMux, orders the page allocations of NewPage with the deletes
+	diskMgr      diskmgr.DiskFileMgr
+	logFlusher   LogFlusher
+	logMgr       logmgr.LogMgr
+	txnMux       *sync.Mutex
+	activeTxns   map[int64]*Txn
+	nextTxnId    int64
+	recReport    RecoveryReport
+	closed       atomic.Bool // txn calls do not take bpsMux, so the flag is atomic
+	activeGuards atomic.Int64
+
+	prefetchQueue   chan prefetchReq
+	prefetchWorkers int
+	prefetchMux     *sync.Mutex   // taken before bpsMux, guards the start and stop of the workers
+	prefetchStop    chan struct{} // closed to stop the workers, nil while they are not running
+	prefetchWg      *sync.WaitGroup
+	ownsPage        func(pageId int) bool // set on the shards of a ParallelBufferPool, a shard only prefetches the pages it owns
+
+	// sequential read-ahead, guarded by bpsMux
+	readAheadPages    int
+	readAheadStride   int
+	lastFetchedPageId int
+	seqFetches        int // number of fetches in a row that were readAheadStride apart
+	readAheadUntil    int // the last page the current run read ahead
+
+	bgWriterPages      int
+	bgWriterInterval   time.Duration
+	bgWriterDirtyRatio float64
+	bgWriterMux        *sync.Mutex   // taken before bpsMux, guards the start and stop of the background writer
+	bgWriterStop       chan struct{} // closed to stop the background writer, nil while it is not running
+	bgWriterDone       chan struct{}
+	bgWriterCursor     int // the last page the background writer wrote, only used by the writer
+}
+
+/*
+InitBuffPoolMgr opens the db and log files and runs crash recovery on them before returning the pool.
+the log manager is plugged in as the log flusher so that no dirty page reaches the disk before its log records.
+opts.PageSize, opts.SyncPolicy, opts.SyncInterval and opts.PreallocPages override the ones in dikFileInit, a zero Options{} is the default pool.
+*/
+func InitBuffPoolMgr(dikFileInit diskmgr.DiskFileInit, opts Options) (BuffPoolMgr *BuffPoolMgrStr, initErr error) {
+	if opts, initErr = opts.withDefaults(); initErr != nil {
+		return nil, initErr
+	}
+	dikFileInit.PageSize = opts.PageSize
+	dikFileInit.SyncPolicy = opts.SyncPolicy
+	dikFileInit.SyncInterval = opts.SyncInterval
+	dikFileInit.PreallocPages = opts.PreallocPages
+	diskMgr, initErr := diskmgr.GetDiskFileMgr(dikFileInit)
+	if initErr != nil {
+		return nil, initErr
+	}
+	if BuffPoolMgr, initErr = initBuffPoolMgr(diskMgr, opts); initErr != nil {
+		return nil, errors.Join(initErr, diskMgr.Close())
+	}
+	return BuffPoolMgr, nil
+}
+
+// initBuffPoolMgr takes the page size from the disk manager, opts.PageSize is only used to open the files. the disk manager stays open if it fails
+func initBuffPoolMgr(diskMgr diskmgr.DiskFileMgr, opts Options) (BuffPoolMgr *BuffPoolMgrStr, initErr error) {
+	if opts, initErr = opts.withDefaults(); initErr != nil {
+		return nil, initErr
+	}
+	logMgr, logErr := logmgr.GetLogMgr(diskMgr)
+	if logErr != nil {
+		return nil, logErr
+	}
+	buffPool := newBuffPool(diskMgr, logMgr, opts)
+	if recErr := buffPool.recover(); recErr != nil {
+		buffPool.stopPrefetch()
+		return nil, recErr
+	}
+	buffPool.startBgWriter()
+	return buffPool, nil
+}
+
+// newBuffPool builds the pool of opts.PoolFrames frames over the disk and log managers without running recovery, opts should have its defaults
+func newBuffPool(diskMgr diskmgr.DiskFileMgr, logMgr logmgr.LogMgr, opts Options) *BuffPoolMgrStr {
+	pageSize := diskMgr.GetPageSize()
 	buffPool := BuffPoolMgrStr{
-		pagePool: make([]Page, constants.BufferPoolSize), // Size and capacity both set to BufferPoolSize
-		pageMap:  make(map[int]int),
-		freeSet:  utils.GetNewSet[int](), // seems not required
-		pagesMem: 0,
-		bpsMux:   &sync.Mutex{},
-		replPol:  getLrukReplPol(),
-		pinSet:   utils.GetNewSet[int](),
-		diskMgr:  diskmgr.GetDiskFileMgr(dikFileInit),
-	}
-
-	for i := range constants.BufferPoolSize {
-		buffPool.pagePool[i].pageMux = &sync.Mutex{}
-		buffPool.freeSet.Add(i)
-		buffPool.replPol.initPageLruk(i)
+		pagePool:   make([]Page, opts.PoolFrames), // Size and capacity both set to poolSize
+		arena:      make([]byte, opts.PoolFrames*pageSize),
+		pageSize:   pageSize,
+		pageMap:    make(map[int]int),
+		inFlight:   make(map[int]*pageIO),
+		freeSet:    utils.GetNewSet[int](), // frames without a page, selectPage takes them before asking the repl pol
+		pagesMem:   0,
+		bpsMux:     &sync.Mutex{},
+		allocMux:   &sync.Mutex{},
+		replPol:    opts.getReplPol(),
+		pinSet:     utils.GetNewSet[int](),
+		diskMgr:    diskMgr,
+		logFlusher: logMgr.Flush,
+		logMgr:     logMgr,
+		txnMux:     &sync.Mutex{},
+		activeTxns: make(map[int64]*Txn),
+		nextTxnId:  1,
+
+		prefetchQueue:   make(chan prefetchReq, constants.PrefetchQueueSize),
+		prefetchWorkers: opts.PrefetchWorkers,
+		prefetchMux:     &sync.Mutex{},
+		prefetchWg:      &sync.WaitGroup{},
+		readAheadPages:  max(opts.ReadAheadPages, 0),
+		readAheadStride: 1,
+
+		bgWriterPages:      max(opts.BgWriterPages, 0),
+		bgWriterInterval:   opts.BgWriterInterval,
+		bgWriterDirtyRatio: opts.BgWriterDirtyRatio,
+		bgWriterMux:        &sync.Mutex{},
 	}
 
+	for i := range opts.PoolFrames {
+		// the full slice expression caps every frame at its own page, an append can never run into the next frame
+		buffPool.pagePool[i].pageData = buffPool.arena[i*pageSize : (i+1)*pageSize : (i+1)*pageSize]
+		buffPool.pagePool[i].pageMux = &sync.RWMutex{}
+		buffPool.freeSet.Add(i)
+		buffPool.replPol.InitPage(i, 0)
+	}
 	return &buffPool
 }
 
+/*
+Close flushes the log and every dirty page, then syncs and closes the db and log files.
+it fails with ErrPagePinned while any page is still pinned, the pool stays open then.
+txns that are still active are not committed, recovery rolls them back on the next InitBuffPoolMgr.
+every call on the pool after Close returns ErrClosed.
+*/
+func (bp *BuffPoolMgrStr) Close() (closeErr error) {
+	// the prefetch workers need bpsMux, so they are stopped before it is taken, and once more after it is released
+	// in case a fetch started them again in between
+	bp.stopPrefetch()
+	defer bp.stopPrefetch()
+	// the background writer too, it is started again if the pool stays open
+	bp.stopBgWriter()
+	defer bp.startBgWriter()
+	bp.bpsMux.Lock()
+	defer bp.bpsMux.Unlock()
+
+	if bp.closed.Load() {
+		return ErrClosed
+	}
+	if bp.pinSet.GetSize() > 0 {
+		return fmt.Errorf("%w: %d pages are still pinned", ErrPagePinned, bp.pinSet.GetSize())
+	}
+	if closeErr = bp.logMgr.Flush(bp.logMgr.GetLastLsn()); closeErr != nil {
+		return closeErr
+	}
+	if closeErr = bp.flushAllPages(); closeErr != nil {
+		return closeErr
+	}
+	bp.closed.Store(true)
+	return bp.diskMgr.Close()
+}
+
+// SetLogFlusher plugs in the log layer, passing nil goes back to the no-op flusher
+func (bp *BuffPoolMgrStr) SetLogFlusher(logFlusher LogFlusher) {
+	bp.bpsMux.Lock()
+	defer bp.bpsMux.Unlock()
+
+	if logFlusher == nil {
+		logFlusher = noopLogFlusher
+	}
+	bp.logFlusher = logFlusher
+}
+
 /*
 Fetch page should take a pageId and then return a page
 if the page is already in memory, then it should return the pointer to that page
@@ -57,101 +211,520 @@ else laod the page from disk to one of the free pages and then return the pointe
 */
 
 func (bp *BuffPoolMgrStr) FetchPage(pageId int) (page *Page, readErr error) {
+	return bp.fetchPage(pageId, false, nil)
+}
+
+/*
+fetchPage is FetchPage with an option to pin the page before the pool lock is released, so that it can not be evicted in between.
+a miss goes through the ring of the strategy, a hit on a page of another ring or of the pool takes it into the pool.
+*/
+func (bp *BuffPoolMgrStr) fetchPage(pageId int, pin bool, strategy *AccessStrategy) (page *Page, readErr error) {
+	ring := strategy.ringOf(bp)
+	var readAhead []prefetchReq
+	// deferred first so that it runs once bpsMux is released
+	defer func() { bp.queuePrefetch(readAhead) }()
 	bp.bpsMux.Lock()
-	// defer bp.bpsMux.Unlock()
-	if i, ok := bp.pageMap[pageId]; ok {
-		// this is where the first part of the logic is to be implemented
-		// i.e. if the page is already in the buffer
-		// we have to update the Hist and Last values (of K)... should be extensible to any K values >=2
-		// maybe have a select page from buffer method that does interactions with the LRU struct (Repl policy)
-		defer bp.bpsMux.Unlock()
-		bp.replPol.addPageTime(i, time.Now().UnixNano())
-		page := &bp.pagePool[i]
-		return page, nil
-	}
-	// bp.diskReadHit++
-	sPage, sPageIndex, sErr := bp.selectPage()
-	bp.pageMap[pageId] = sPageIndex
-	sPage.PageId = pageId
-	bp.bpsMux.Unlock()
-	sPage.pageMux.Lock()
-	if sErr != nil {
-		return nil, sErr
+	bp.stats.Fetches++
+	readAhead = bp.readAheadFor(pageId, ring)
+	for {
+		if bp.closed.Load() {
+			bp.bpsMux.Unlock()
+			return nil, ErrClosed
+		}
+		if pageIO, ok := bp.inFlight[pageId]; ok {
+			// the page is being read or written back, wait for it instead of reading it a second time
+			bp.bpsMux.Unlock()
+			<-pageIO.done
+			if pageIO.err != nil {
+				return nil, pageIO.err
+			}
+			bp.bpsMux.Lock()
+			continue
+		}
+		if i, ok := bp.pageMap[pageId]; ok {
+			defer bp.bpsMux.Unlock()
+			page := &bp.pagePool[i]
+			bp.stats.FetchHits++
+			if page.prefetched {
+				page.prefetched = false
+				bp.stats.PrefetchHits++
+			}
+			if page.ring == nil || page.ring != ring {
+				page.ring = nil
+				bp.replPol.AddPageTime(i, bp.tickReplClock())
+			}
+			if page.IsCorrupted {
+				return page, fmt.Errorf("%w for pageId: %d", diskmgr.ErrPageCorrupted, pageId)
+			}
+			if pin {
+				bp.pinPageByIndex(i)
+			}
+			return page, nil
+		}
+		sPage, sPageIndex, sErr := bp.selectPage(ring)
+		if sErr != nil {
+			bp.bpsMux.Unlock()
+			return nil, sErr
+		}
+		if _, ok := bp.pageMap[pageId]; ok || bp.inFlight[pageId] != nil {
+			// another fetcher loaded the page while selectPage wrote the victim back
+			bp.releaseFrame(sPageIndex)
+			continue
+		}
+		return bp.loadPage(pageId, sPage, sPageIndex, pin)
 	}
+}
 
-	err := bp.diskMgr.ReadPage(pageId, sPage.pageData[:])
-	if err != nil {
-		sPage.IsCorrupted = true
-		return nil, err
+/*
+loadPage reads the page into the frame reserved by selectPage, bpsMux is held on entry and released before the read.
+the page is in bp.inFlight till the read is done, so every other fetcher of the page waits for this read.
+*/
+func (bp *BuffPoolMgrStr) loadPage(pageId int, sPage *Page, sPageIndex int, pin bool) (page *Page, readErr error) {
+	pageIO := bp.beginLoad(pageId, sPage, sPageIndex)
+	bp.bpsMux.Unlock()
+
+	readErr = bp.diskMgr.ReadPage(pageId, sPage.pageData[:])
+	if readErr == nil {
+		sPage.loadLSN()
 	}
+
+	// the latch is still held here, so the order is the same as everywhere else: page latch before bpsMux
+	bp.bpsMux.Lock()
+	bp.endLoad(pageId, sPage, sPageIndex, pin, pageIO, readErr)
+	bp.bpsMux.Unlock()
 	sPage.pageMux.Unlock()
+
+	if errors.Is(readErr, diskmgr.ErrPageCorrupted) {
+		// the page stays in the frame marked as corrupted, it is never written back and is dropped on eviction
+		return sPage, readErr
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
 	return sPage, nil
 }
 
-func (bp *BuffPoolMgrStr) flushPageByIndex(pageIndex int) (flushErr error) {
-	if bp.pagePool[pageIndex].Pin == 0 && !bp.pagePool[pageIndex].IsCorrupted {
-		if bp.pagePool[pageIndex].IsDirty {
-			writerErr := bp.diskMgr.WritePage(pageIndex, bp.pagePool[pageIndex].pageData[:])
-			bp.pagePool[pageIndex].IsDirty = false
-			return writerErr
-		} else {
-			return nil
-		}
+// beginLoad maps the page to the frame reserved by selectPage and puts it in bp.inFlight, bpsMux should be held
+func (bp *BuffPoolMgrStr) beginLoad(pageId int, sPage *Page, sPageIndex int) *pageIO {
+	pageIO := &pageIO{done: make(chan struct{})}
+	bp.inFlight[pageId] = pageIO
+	bp.pageMap[pageId] = sPageIndex
+	sPage.PageId = pageId
+	return pageIO
+}
 
+// endLoad finishes the read of the page and wakes up the fetchers waiting on it, the frame stays latched, bpsMux should be held
+func (bp *BuffPoolMgrStr) endLoad(pageId int, sPage *Page, sPageIndex int, pin bool, pageIO *pageIO, readErr error) {
+	sPage.IsCorrupted = readErr != nil
+	sPage.IsDirty = false
+	sPage.recLSN.Store(0)
+	if !pin || readErr != nil {
+		bp.unpinPageByIndex(sPageIndex)
+	}
+	if readErr != nil && !errors.Is(readErr, diskmgr.ErrPageCorrupted) {
+		// the page could not be read at all, the frame is given back instead of caching the failure
+		delete(bp.pageMap, pageId)
+		sPage.PageId = 0
+		sPage.prefetched = false
+		bp.freeSet.Add(sPageIndex)
+		bp.replPol.InitPage(sPageIndex, 0)
+		pageIO.err = readErr
 	} else {
-		return errors.New("page does not satisfy flush conditions")
+		bp.replPol.InitPage(sPageIndex, pageId)
+		if sPage.prefetched {
+			bp.stats.Prefetches++
+		}
+		// a ring page has no history, so it is the first victim once it leaves the ring
+		if sPage.ring == nil {
+			bp.replPol.AddPageTime(sPageIndex, bp.tickReplClock())
+		}
+	}
+	delete(bp.inFlight, pageId)
+	close(pageIO.done)
+}
+
+/*
+flushPageByIndex writes the page in the frame back to its pageId on disk.
+a dirty page is written only after the log flusher reports the log is durable up to the PageLSN, else the page stays dirty in memory.
+bpsMux should be held, it is released while the page is written: the page is pinned meanwhile so that it is not evicted,
+and latched so that nobody changes it (an unpinned page is not latched by anyone, the latch is free).
+*/
+func (bp *BuffPoolMgrStr) flushPageByIndex(pageIndex int) (flushErr error) {
+	page := &bp.pagePool[pageIndex]
+	if page.IsCorrupted {
+		return fmt.Errorf("%w for pageId: %d", diskmgr.ErrPageCorrupted, page.PageId)
 	}
+	if page.Pin != 0 {
+		return fmt.Errorf("%w for pageId: %d", ErrPagePinned, page.PageId)
+	}
+	if !page.IsDirty {
+		return nil
+	}
+	bp.pinPageByIndex(pageIndex)
+	page.pageMux.Lock()
+	bp.bpsMux.Unlock()
+	flushErr = bp.writeBack(page, page.PageId)
+	bp.bpsMux.Lock()
+	page.pageMux.Unlock()
+	bp.unpinPageByIndex(pageIndex)
+	return flushErr
+}
+
+// writeBack writes the dirty page to pageId once the log is durable up to its PageLSN, the page should be latched or unpinned
+func (bp *BuffPoolMgrStr) writeBack(page *Page, pageId int) (writeErr error) {
+	if logErr := bp.logFlusher(page.PageLSN); logErr != nil {
+		return fmt.Errorf("log not durable up to page lsn %d: %w", page.PageLSN, logErr)
+	}
+	page.setLSN(page.PageLSN)
+	if writeErr = bp.diskMgr.WritePage(pageId, page.pageData[:]); writeErr != nil {
+		return writeErr
+	}
+	page.IsDirty = false
+	page.recLSN.Store(0)
+	return nil
 }
 
 /*
 FlushPage should take a pageId as input and then flush the page to the disk
-if the page is pinned and is corrupted the flush will fail
+if the page is pinned or is corrupted the flush will fail
 on successful flush, isDirty should be marked as false
 */
 func (bp *BuffPoolMgrStr) FlushPage(pageId int) (flushErr error) {
 	bp.bpsMux.Lock()
 	defer bp.bpsMux.Unlock()
-	fmt.Println(bp.pageMap[pageId])
-	if _, ok := bp.pageMap[pageId]; ok {
-		return bp.flushPageByIndex(bp.pageMap[1])
+	if bp.closed.Load() {
+		return ErrClosed
+	}
+	if pageIndex, ok := bp.pageMap[pageId]; ok {
+		return bp.flushPageByIndex(pageIndex)
 	} else {
-		return errors.New("page failed for pageId: " + fmt.Sprintf("%d", pageId))
+		return fmt.Errorf("%w for pageId: %d", ErrPageNotInPool, pageId)
+	}
+}
+
+// pageWrite is a page written by a flush or a round of the background writer, changeCount is the one of the page data that was written
+type pageWrite struct {
+	pageId      int
+	pageIndex   int
+	changeCount int64
+}
+
+/*
+FlushAllPages writes every dirty page in the pool in pageId order and syncs the db file once at the end.
+pinned pages are written too, under their page latch, corrupted pages are never written back.
+pages are written under a shared latch, a page latched exclusively (e.g. by a write guard) is skipped and stays dirty,
+waiting for it under bpsMux could deadlock with the latch holder.
+bpsMux is only held to pick, pin and unpin the pages, like bgWriteRound they are written and synced without it.
+a page is marked clean only after the sync and only if it did not change again after it was written.
+*/
+func (bp *BuffPoolMgrStr) FlushAllPages() (flushErr error) {
+	bp.bpsMux.Lock()
+	if bp.closed.Load() {
+		bp.bpsMux.Unlock()
+		return ErrClosed
+	}
+	writes := bp.latchDirtyPages()
+	bp.bpsMux.Unlock()
+	writeErr := bp.writeLatchedPages(writes)
+	bp.bpsMux.Lock()
+	bp.unlatchPages(writes)
+	bp.bpsMux.Unlock()
+	if writeErr != nil {
+		return writeErr
+	}
+	if flushErr = bp.diskMgr.Sync(); flushErr != nil {
+		return flushErr
+	}
+
+	bp.bpsMux.Lock()
+	defer bp.bpsMux.Unlock()
+	bp.markPagesWritten(writes)
+	return nil
+}
+
+// flushAllPages is FlushAllPages for Close, bpsMux is held all along so that nothing is fetched while the pool closes
+func (bp *BuffPoolMgrStr) flushAllPages() (flushErr error) {
+	writes := bp.latchDirtyPages()
+	writeErr := bp.writeLatchedPages(writes)
+	bp.unlatchPages(writes)
+	if writeErr != nil {
+		return writeErr
 	}
+	if flushErr = bp.diskMgr.Sync(); flushErr != nil {
+		return flushErr
+	}
+	bp.markPagesWritten(writes)
+	return nil
 }
 
-func (bp *BuffPoolMgrStr) allocatePageId() (pageId int) {
-	return bp.diskMgr.GetPageCount()
+// latchDirtyPages pins and latches shared the dirty pages that are not corrupted, in pageId order, bpsMux should be held
+func (bp *BuffPoolMgrStr) latchDirtyPages() (writes []pageWrite) {
+	pageIds := make([]int, 0)
+	for pageId, pageIndex := range bp.pageMap {
+		if !bp.pagePool[pageIndex].IsCorrupted {
+			pageIds = append(pageIds, pageId)
+		}
+	}
+	slices.Sort(pageIds)
+
+	for _, pageId := range pageIds {
+		pageIndex := bp.pageMap[pageId]
+		page := &bp.pagePool[pageIndex]
+		// the dirty flag is changed under the page latch, it is only checked once the latch is held
+		if !page.pageMux.TryRLock() {
+			continue
+		}
+		if !page.IsDirty {
+			page.pageMux.RUnlock()
+			continue
+		}
+		bp.pinPageByIndex(pageIndex)
+		writes = append(writes, pageWrite{pageId: pageId, pageIndex: pageIndex, changeCount: page.changeCount})
+	}
+	return writes
 }
 
+/*
+writeLatchedPages writes the latched pages with one call, so the disk manager writes the runs of neighbouring pages together,
+once the log is durable up to the largest PageLSN. the lsn in the page header is kept in step by setLSN, the page is not changed
+under the shared latch. bpsMux does not have to be held.
+*/
+func (bp *BuffPoolMgrStr) writeLatchedPages(writes []pageWrite) (writeErr error) {
+	if len(writes) == 0 {
+		return nil
+	}
+	writeIds, writeData := make([]int, len(writes)), make([][]byte, len(writes))
+	maxLsn := int64(0)
+	for i, write := range writes {
+		page := &bp.pagePool[write.pageIndex]
+		writeIds[i], writeData[i] = write.pageId, page.pageData[:]
+		maxLsn = max(maxLsn, page.PageLSN)
+	}
+	if logErr := bp.logFlusher(maxLsn); logErr != nil {
+		return fmt.Errorf("log not durable up to page lsn %d: %w", maxLsn, logErr)
+	}
+	return bp.diskMgr.WritePages(writeIds, writeData)
+}
+
+// unlatchPages releases the latches and the pins of latchDirtyPages or bgLatchPages, bpsMux should be held
+func (bp *BuffPoolMgrStr) unlatchPages(writes []pageWrite) {
+	for _, write := range writes {
+		bp.pagePool[write.pageIndex].pageMux.RUnlock()
+		bp.unpinPageByIndex(write.pageIndex)
+	}
+}
+
+/*
+markPagesWritten marks the written pages clean once they are synced and returns how many it marked. a page that changed
+after it was written, or whose frame holds another page by now, stays dirty. bpsMux should be held.
+every change to the page is made under the exclusive latch, so the shared latch is enough to check and clear the dirty flag.
+*/
+func (bp *BuffPoolMgrStr) markPagesWritten(writes []pageWrite) (written int) {
+	for _, write := range writes {
+		page := &bp.pagePool[write.pageIndex]
+		if mappedIndex, ok := bp.pageMap[write.pageId]; !ok || mappedIndex != write.pageIndex || !page.pageMux.TryRLock() {
+			continue
+		}
+		if page.changeCount == write.changeCount && page.IsDirty {
+			page.IsDirty = false
+			page.recLSN.Store(0)
+			written++
+		}
+		page.pageMux.RUnlock()
+	}
+	return written
+}
+
+// allocatePageId reuses a page from the disk manager's free list before growing the db file, reused tells which. allocMux should be held
+func (bp *BuffPoolMgrStr) allocatePageId() (pageId int, reused bool, allocErr error) {
+	reused = bp.diskMgr.GetSuperblock().FreeListHead != 0
+	pageId, allocErr = bp.diskMgr.AllocatePage()
+	return pageId, reused, allocErr
+}
+
+/*
+NewPage starts the page lsn at the last lsn of the log, a reused pageId can have older log records that redo must not apply to the new page.
+the log is made durable up to that lsn first, else the lsns after a crash could be given out again below the page lsn.
+*/
 func (bp *BuffPoolMgrStr) NewPage() (page *Page, newPageErr error) {
+	return bp.newPage(false, nil)
+}
+
+// newPage is NewPage with an option to pin the page before the pool lock is released
+func (bp *BuffPoolMgrStr) newPage(pin bool, strategy *AccessStrategy) (page *Page, newPageErr error) {
+	sPage, sPageIndex, newPageErr := bp.reserveFrame(strategy)
+	if newPageErr != nil {
+		return nil, newPageErr
+	}
+	startLsn, newPageErr := bp.flushLogForNewPage()
+	newPageId, reused := -1, false
+	if newPageErr == nil {
+		// the disk manager reserves the pageId, the new page is written without allocMux
+		bp.allocMux.Lock()
+		newPageId, reused, newPageErr = bp.allocatePageId()
+		bp.allocMux.Unlock()
+	}
+	if newPageErr != nil {
+		bp.bpsMux.Lock()
+		bp.releaseFrame(sPageIndex)
+		bp.bpsMux.Unlock()
+		return nil, newPageErr
+	}
+	return bp.installNewPage(newPageId, reused, sPage, sPageIndex, startLsn, pin)
+}
+
+// reserveFrame selects a frame for a new page, it is returned pinned and latched exclusive
+func (bp *BuffPoolMgrStr) reserveFrame(strategy *AccessStrategy) (page *Page, pageIndex int, reserveErr error) {
 	bp.bpsMux.Lock()
 	defer bp.bpsMux.Unlock()
+	if bp.closed.Load() {
+		return nil, -1, ErrClosed
+	}
+	return bp.selectPage(strategy.ringOf(bp))
+}
 
-	newPageId := bp.allocatePageId()
-	sPage, sPageIndex, sErr := bp.selectPage()
-	if sErr != nil {
-		return nil, sErr
+// flushLogForNewPage returns the lsn a new page starts at, once the log is durable up to it
+func (bp *BuffPoolMgrStr) flushLogForNewPage() (startLsn int64, flushErr error) {
+	startLsn = bp.logMgr.GetLastLsn()
+	if flushErr = bp.logFlusher(startLsn); flushErr != nil {
+		return startLsn, fmt.Errorf("log not durable up to lsn %d: %w", startLsn, flushErr)
 	}
-	bp.replPol.initPageLruk(sPageIndex)
-	sPage.NewPage()
-	writeErr := bp.diskMgr.WritePage(newPageId, sPage.pageData[:])
-	if writeErr != nil {
-		return nil, writeErr
+	return startLsn, nil
+}
+
+/*
+installNewPage writes the new page to newPageId through the frame from reserveFrame and maps it, newPageId should be allocated to the caller.
+the frame is given back if the write fails, or if another frame still holds the freed page pinned.
+a reused page is still the free page on disk and its max lsn would make redo skip every record of the new page,
+so its first write is synced before the page is handed out and any record of it can reach the log.
+*/
+func (bp *BuffPoolMgrStr) installNewPage(newPageId int, reused bool, sPage *Page, sPageIndex int, startLsn int64, pin bool) (page *Page, newPageErr error) {
+	bp.bpsMux.Lock()
+	if newPageErr = bp.dropStaleFrame(newPageId); newPageErr != nil {
+		bp.releaseFrame(sPageIndex)
+		bp.bpsMux.Unlock()
+		return nil, newPageErr
 	}
+	pageIO := &pageIO{done: make(chan struct{})}
+	bp.inFlight[newPageId] = pageIO
 	bp.pageMap[newPageId] = sPageIndex
 	sPage.PageId = newPageId
+	bp.replPol.InitPage(sPageIndex, newPageId)
+	if sPage.ring == nil {
+		bp.replPol.AddPageTime(sPageIndex, bp.tickReplClock())
+	}
+	bp.bpsMux.Unlock()
+
+	sPage.NewPage()
+	sPage.setLSN(startLsn)
+	if reused {
+		if newPageErr = bp.diskMgr.WritePageNoSync(newPageId, sPage.pageData[:]); newPageErr == nil {
+			newPageErr = bp.diskMgr.Sync()
+		}
+	} else {
+		newPageErr = bp.diskMgr.WritePage(newPageId, sPage.pageData[:])
+	}
+
+	bp.bpsMux.Lock()
+	delete(bp.inFlight, newPageId)
+	if newPageErr != nil {
+		delete(bp.pageMap, newPageId)
+		pageIO.err = newPageErr
+		bp.releaseFrame(sPageIndex)
+	} else {
+		if !pin {
+			bp.unpinPageByIndex(sPageIndex)
+		}
+		sPage.pageMux.Unlock()
+	}
+	close(pageIO.done)
+	bp.bpsMux.Unlock()
+	if newPageErr != nil {
+		return nil, newPageErr
+	}
 	return sPage, nil
 }
 
+/*
+dropStaleFrame drops the frame that still caches the freed page pageId (e.g. freed through the disk manager, not DeletePage), it is stale once the pageId is reused.
+it fails if the frame is pinned. bpsMux should be held, it is released while a read or write back of the page finishes.
+*/
+func (bp *BuffPoolMgrStr) dropStaleFrame(pageId int) (dropErr error) {
+	for bp.inFlight[pageId] != nil {
+		pageIO := bp.inFlight[pageId]
+		bp.bpsMux.Unlock()
+		<-pageIO.done
+		bp.bpsMux.Lock()
+	}
+	if staleIndex, ok := bp.pageMap[pageId]; ok {
+		if bp.pagePool[staleIndex].Pin != 0 {
+			return fmt.Errorf("%w: the freed pageId %d is still pinned", ErrPagePinned, pageId)
+		}
+		bp.dropPage(pageId, staleIndex)
+	}
+	return nil
+}
+
+/*
+DeletePage drops the page from the pool and puts it on the disk manager's free list, a pinned page can not be deleted.
+the delete is not logged, no active txn should still be changing the page.
+*/
+func (bp *BuffPoolMgrStr) DeletePage(pageId int) (deleteErr error) {
+	// the free list head only changes under allocMux
+	bp.allocMux.Lock()
+	defer bp.allocMux.Unlock()
+	return bp.deletePage(pageId)
+}
+
+// deletePage is DeletePage for a caller that holds allocMux
+func (bp *BuffPoolMgrStr) deletePage(pageId int) (deleteErr error) {
+	bp.bpsMux.Lock()
+	// a write back of the page that is still running would overwrite the free page
+	for bp.inFlight[pageId] != nil {
+		pageIO := bp.inFlight[pageId]
+		bp.bpsMux.Unlock()
+		<-pageIO.done
+		bp.bpsMux.Lock()
+	}
+	defer bp.bpsMux.Unlock()
+
+	if bp.closed.Load() {
+		return ErrClosed
+	}
+	pageIndex, inPool := bp.pageMap[pageId]
+	if inPool && bp.pagePool[pageIndex].Pin != 0 {
+		return fmt.Errorf("%w for pageId: %d", ErrPagePinned, pageId)
+	}
+	if deleteErr = bp.diskMgr.DeallocatePage(pageId); deleteErr != nil {
+		return deleteErr
+	}
+	if inPool {
+		bp.dropPage(pageId, pageIndex)
+	}
+	return nil
+}
+
+// dropPage frees the unpinned frame of the page without a write back, even if it is dirty. bpsMux should be held
+func (bp *BuffPoolMgrStr) dropPage(pageId int, pageIndex int) {
+	page := &bp.pagePool[pageIndex]
+	delete(bp.pageMap, pageId)
+	if page.prefetched {
+		page.prefetched = false
+		bp.stats.PrefetchUnused++
+	}
+	page.NewPage()
+	page.PageId = 0
+	page.IsDirty = false
+	bp.freeSet.Add(pageIndex)
+	bp.replPol.InitPage(pageIndex, 0)
+}
+
 func (bp *BuffPoolMgrStr) UnpinPage(pageId int) bool {
 	bp.bpsMux.Lock()
 	defer bp.bpsMux.Unlock()
 
-	if i, ok := bp.pageMap[pageId]; ok {
-		bp.pagePool[i].Pin--
-		if bp.pagePool[i].Pin == 0 {
-			bp.pinSet.Delete(i)
-		}
+	if i, ok := bp.pageMap[pageId]; ok && !bp.closed.Load() {
+		bp.unpinPageByIndex(i)
 		return true
 	}
 
@@ -162,27 +735,110 @@ func (bp *BuffPoolMgrStr) PinPage(pageId int) {
 	bp.bpsMux.Lock()
 	defer bp.bpsMux.Unlock()
 
-	if i, ok := bp.pageMap[pageId]; ok {
-		bp.pagePool[i].Pin++
-		bp.pinSet.Add(i)
+	if i, ok := bp.pageMap[pageId]; ok && !bp.closed.Load() {
+		bp.pinPageByIndex(i)
+	}
+}
+
+func (bp *BuffPoolMgrStr) pinPageByIndex(pageIndex int) {
+	bp.pagePool[pageIndex].Pin++
+	if bp.pagePool[pageIndex].Pin == 1 {
+		bp.pinSet.Add(pageIndex)
+		bp.replPol.SetEvictable(pageIndex, false)
+	}
+}
+
+func (bp *BuffPoolMgrStr) unpinPageByIndex(pageIndex int) {
+	bp.pagePool[pageIndex].Pin--
+	if bp.pagePool[pageIndex].Pin == 0 {
+		bp.pinSet.Delete(pageIndex)
+		bp.replPol.SetEvictable(pageIndex, true)
 	}
 }
 
 /*
 select page is responsible for selecting a page from pagePool and returnign the pointer to the page and pageIndex, err if any
 select page is NOT responsible for adding any info the page map and any other changes to the times info in lruk
+
+with a ring (of an access strategy) the frame of the oldest ring page is reused once the ring is full.
+else a free frame is taken first, the repl pol is only asked for a victim once every frame holds a page.
+bpsMux is held on entry and on return, but it is released while a dirty victim is written back.
+the selected frame is returned pinned, latched exclusive and out of the page map, the caller maps it or gives it back with releaseFrame.
 */
-func (bp *BuffPoolMgrStr) selectPage() (page *Page, freePageIndex int, selectErr error) {
+func (bp *BuffPoolMgrStr) selectPage(ring *accessRing) (page *Page, freePageIndex int, selectErr error) {
+	victimePageIndex := ring.reusableFrame(bp)
+	for victimePageIndex < 0 && bp.freeSet.GetSize() > 0 {
+		freeIndex, _ := bp.freeSet.GetAvailableElement()
+		bp.freeSet.Delete(freeIndex)
+		// a free frame is only pinned if a caller pinned it by index, it is then not free any more
+		if freePage := &bp.pagePool[freeIndex]; freePage.Pin == 0 {
+			bp.pinPageByIndex(freeIndex)
+			freePage.pageMux.Lock()
+			ring.add(bp, freeIndex)
+			return freePage, freeIndex, nil
+		}
+	}
 
-	victimePageIndex := bp.replPol.findReplPage(time.Now().UnixNano()/int64(time.Millisecond), bp.pinSet)
-	if victimePageIndex < 0 || victimePageIndex >= constants.BufferPoolSize {
-		return nil, -1, errors.New("no victim page found by the repl pol")
+	if victimePageIndex < 0 {
+		victimePageIndex = bp.replPol.FindReplPage(bp.tickReplClock(), bp.pinSet)
 	}
-	flushErr := bp.flushPageByIndex(victimePageIndex) // flush page method for pageIndex
-	if flushErr != nil {
-		return nil, -1, errors.New("no page is free on memory")
+	if victimePageIndex < 0 || victimePageIndex >= len(bp.pagePool) || bp.pagePool[victimePageIndex].Pin != 0 {
+		return nil, -1, fmt.Errorf("%w: no victim page found by the repl pol", ErrNoFreeFrame)
 	}
-	delete(bp.pageMap, bp.pagePool[victimePageIndex].PageId)
+	victimPage := &bp.pagePool[victimePageIndex]
+	victimPageId := victimPage.PageId
+	// a free frame still carries the default PageId, only drop the mapping if it points at this frame
+	mappedIndex, mapped := bp.pageMap[victimPageId]
+	mapped = mapped && mappedIndex == victimePageIndex
+	if mapped {
+		delete(bp.pageMap, victimPageId)
+	}
+	// the victim is unpinned so nobody holds its latch
+	bp.pinPageByIndex(victimePageIndex)
+	victimPage.pageMux.Lock()
+
+	if victimPage.IsDirty && !victimPage.IsCorrupted {
+		// fetchers of the victim wait on the write back, else they could read the old page from disk
+		pageIO := &pageIO{done: make(chan struct{})}
+		bp.inFlight[victimPageId] = pageIO
+		bp.stats.VictimWrites++
+		bp.bpsMux.Unlock()
+		writeErr := bp.writeBack(victimPage, victimPageId) // refuses dirty victims until the log is durable
+		bp.bpsMux.Lock()
+		delete(bp.inFlight, victimPageId)
+		close(pageIO.done)
+		if writeErr != nil {
+			if mapped {
+				bp.pageMap[victimPageId] = victimePageIndex
+			}
+			victimPage.pageMux.Unlock()
+			bp.unpinPageByIndex(victimePageIndex)
+			return nil, -1, fmt.Errorf("%w: %w", ErrNoFreeFrame, writeErr)
+		}
+	}
+	if victimPage.prefetched {
+		victimPage.prefetched = false
+		bp.stats.PrefetchUnused++
+	}
+	victimPage.PageId = 0
+	ring.add(bp, victimePageIndex)
 	// we should have the logic of page map allocation in the and page Id allocation in the page here....?
-	return &bp.pagePool[victimePageIndex], victimePageIndex, nil
+	return victimPage, victimePageIndex, nil
+}
+
+// releaseFrame gives back a frame that selectPage returned but that was not used, bpsMux should be held
+func (bp *BuffPoolMgrStr) releaseFrame(pageIndex int) {
+	page := &bp.pagePool[pageIndex]
+	page.PageId = 0
+	page.prefetched = false
+	page.pageMux.Unlock()
+	bp.unpinPageByIndex(pageIndex)
+	bp.freeSet.Add(pageIndex)
+	bp.replPol.InitPage(pageIndex, 0)
+}
+
+// tickReplClock moves the repl pol clock on and returns the new time, bpsMux should be held
+func (bp *BuffPoolMgrStr) tickReplClock() int64 {
+	bp.replClock++
+	return bp.replClock
 }
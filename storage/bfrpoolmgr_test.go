@@ -1,42 +1,105 @@
 package storage
 
 import (
+	"errors"
+	"math"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/rohithputha/HymStMgr/constants"
 	"github.com/rohithputha/HymStMgr/diskmgr"
+	"github.com/rohithputha/HymStMgr/diskmgr/disktest"
 )
 
-func TestInitBufferPoolMgr(test *testing.T) {
-	bfrPool := InitBuffPoolMgr(diskmgr.DiskFileInit{
-		DbFilePath:  test.TempDir() + "dbtest.db",
-		LogFilePath: test.TempDir() + "dblog.log",
+// getTestPool opens a pool on the files and closes it when the test ends
+func getTestPool(test testing.TB, d diskmgr.DiskFileInit, opts Options) *BuffPoolMgrStr {
+	bfrPool, initErr := InitBuffPoolMgr(d, opts)
+	if initErr != nil {
+		test.Fatalf("buffer pool failed to open: %v", initErr)
+	}
+	closeTestPool(test, bfrPool)
+	return bfrPool
+}
+
+// getTestPoolOn is getTestPool for a disk manager the test opened itself
+func getTestPoolOn(test testing.TB, diskMgr diskmgr.DiskFileMgr, opts Options) *BuffPoolMgrStr {
+	bfrPool, initErr := initBuffPoolMgr(diskMgr, opts)
+	if initErr != nil {
+		test.Fatalf("buffer pool failed to open: %v", initErr)
+	}
+	closeTestPool(test, bfrPool)
+	return bfrPool
+}
+
+func getTestParallelPool(test testing.TB, d diskmgr.DiskFileInit, opts Options, numShards int) *ParallelBufferPool {
+	parallelPool, initErr := InitParallelBuffPool(d, opts, numShards)
+	if initErr != nil {
+		test.Fatalf("parallel buffer pool failed to open: %v", initErr)
+	}
+	closeTestParallelPool(test, parallelPool)
+	return parallelPool
+}
+
+func closeTestParallelPool(test testing.TB, parallelPool *ParallelBufferPool) {
+	test.Cleanup(func() {
+		if closeErr := parallelPool.Close(); closeErr != nil && !errors.Is(closeErr, ErrClosed) {
+			for _, shard := range parallelPool.shards {
+				abandonTestPool(shard)
+			}
+			parallelPool.diskMgr.Close()
+		}
 	})
+}
+
+/*
+closeTestPool closes the pool when the test ends. a test can end with pages still pinned, or with a disk manager that fails on purpose,
+then the pool is dropped the way a crash drops it: the workers are stopped and the files are closed without a flush.
+*/
+func closeTestPool(test testing.TB, bfrPool *BuffPoolMgrStr) {
+	test.Cleanup(func() {
+		if closeErr := bfrPool.Close(); closeErr != nil && !errors.Is(closeErr, ErrClosed) {
+			abandonTestPool(bfrPool)
+			bfrPool.diskMgr.Close()
+		}
+	})
+}
+
+func abandonTestPool(bfrPool *BuffPoolMgrStr) {
+	bfrPool.closed.Store(true)
+	bfrPool.stopPrefetch()
+	bfrPool.stopBgWriter()
+}
+
+func TestInitBufferPoolMgr(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
 	if len(bfrPool.pagePool) != 500 {
 		test.Errorf("init buffer pool did not work as expected")
 	}
 }
 
 func TestSelectPageWithFreePage(test *testing.T) {
-	bfrPool := InitBuffPoolMgr(diskmgr.DiskFileInit{
-		DbFilePath:  test.TempDir() + "dbtest.db",
-		LogFilePath: test.TempDir() + "dblog.log",
-	})
-	_, pageIndex, err := bfrPool.selectPage()
-	if err != nil || !bfrPool.freeSet.Contains(pageIndex) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
+	bfrPool.bpsMux.Lock()
+	_, pageIndex, err := bfrPool.selectPage(nil)
+	bfrPool.bpsMux.Unlock()
+	// the free frame is taken out of the free set
+	if err != nil || bfrPool.freeSet.Contains(pageIndex) || bfrPool.freeSet.GetSize() != constants.BufferPoolSize-1 {
 		test.Errorf("select free page not working as expected")
 	}
 }
 
 func TestSelectPageWithNoFreePage(test *testing.T) {
-	bfrPool := InitBuffPoolMgr(diskmgr.DiskFileInit{
-		DbFilePath:  test.TempDir() + "dbtest.db",
-		LogFilePath: test.TempDir() + "dblog.log",
-	})
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
 	for i := range constants.BufferPoolSize {
 		bfrPool.freeSet.Delete(i) //deleting all the pages from free set to simulate not free pages available
 	}
-	_, pageIndex, err := bfrPool.selectPage()
+	bfrPool.bpsMux.Lock()
+	_, pageIndex, err := bfrPool.selectPage(nil)
+	bfrPool.bpsMux.Unlock()
 	if err != nil || pageIndex < 0 && pageIndex >= 500 {
 		test.Log(err)
 		test.Errorf("select free page not working as expected")
@@ -44,10 +107,7 @@ func TestSelectPageWithNoFreePage(test *testing.T) {
 }
 
 func TestPinPage(test *testing.T) {
-	bfrPool := InitBuffPoolMgr(diskmgr.DiskFileInit{
-		DbFilePath:  test.TempDir() + "dbtest.db",
-		LogFilePath: test.TempDir() + "dblog.log",
-	})
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
 	bfrPool.pageMap[1] = 1 //setting page mapping => page id 1 -> page index 1
 	bfrPool.PinPage(1)
 	bfrPool.PinPage(1)
@@ -57,10 +117,7 @@ func TestPinPage(test *testing.T) {
 }
 
 func TestUnpinPage(test *testing.T) {
-	bfrPool := InitBuffPoolMgr(diskmgr.DiskFileInit{
-		DbFilePath:  test.TempDir() + "dbtest.db",
-		LogFilePath: test.TempDir() + "dblog.log",
-	})
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
 	bfrPool.pageMap[1] = 1 //setting page mapping => page id 1 -> page index 1
 	bfrPool.PinPage(1)
 	bfrPool.PinPage(1)
@@ -76,22 +133,16 @@ func TestUnpinPage(test *testing.T) {
 }
 
 func TestAllocatePage(test *testing.T) {
-	bfrPool := InitBuffPoolMgr(diskmgr.DiskFileInit{
-		DbFilePath:  test.TempDir() + "dbtest.db",
-		LogFilePath: test.TempDir() + "dblog.log",
-	})
-	pageId := bfrPool.allocatePageId()
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
+	pageId, _, allocErr := bfrPool.allocatePageId()
 	test.Log(pageId)
-	if pageId != 0 {
+	if allocErr != nil || pageId != 1 {
 		test.Errorf("allocate page not working as expected")
 	}
 }
 
 func TestNewPage(test *testing.T) {
-	bfrPool := InitBuffPoolMgr(diskmgr.DiskFileInit{
-		DbFilePath:  test.TempDir() + "dbtest.db",
-		LogFilePath: test.TempDir() + "dblog.log",
-	})
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
 
 	newPage, err := bfrPool.NewPage()
 	if err != nil || newPage.pageData[0] != 1 {
@@ -100,93 +151,557 @@ func TestNewPage(test *testing.T) {
 }
 
 func TestNewPageMultiple(test *testing.T) {
-	bfrPool := InitBuffPoolMgr(diskmgr.DiskFileInit{
-		DbFilePath:  test.TempDir() + "dbtest.db",
-		LogFilePath: test.TempDir() + "dblog.log",
-	})
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
 
 	bfrPool.NewPage()
 	newPage, _ := bfrPool.NewPage()
-	if newPage.pageData[0] != 1 || bfrPool.diskMgr.GetPageCount() != 2 {
+	if newPage.pageData[0] != 1 || bfrPool.diskMgr.GetPageCount() != 3 {
 		test.Errorf("new page multiple creation not working as expected")
 	}
 }
 
 func TestFlushPageByIndex(test *testing.T) {
-	bfrPool := InitBuffPoolMgr(diskmgr.DiskFileInit{
-		DbFilePath:  test.TempDir() + "dbtest.db",
-		LogFilePath: test.TempDir() + "dblog.log",
-	})
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
 	// setting up the bfrpool page data to all 1s (4096 bytes)
-	bfrPool.pageMap[0] = 0
+	bfrPool.pageMap[1] = 0
+	bfrPool.pagePool[0].PageId = 1
 	for i := range bfrPool.pagePool[0].pageData {
 		bfrPool.pagePool[0].pageData[i] = 1
 	}
 	bfrPool.pagePool[0].IsDirty = true
+	bfrPool.bpsMux.Lock()
 	flushErr := bfrPool.flushPageByIndex(0)
-	if flushErr != nil || bfrPool.diskMgr.GetPageCount() != 1 {
+	bfrPool.bpsMux.Unlock()
+	if flushErr != nil || bfrPool.diskMgr.GetPageCount() != 2 {
 		test.Errorf("flush page by index is not working as expected")
 	}
 
 }
 func TestFlushPageByIndexPageNotDirty(test *testing.T) {
-	bfrPool := InitBuffPoolMgr(diskmgr.DiskFileInit{
-		DbFilePath:  test.TempDir() + "dbtest.db",
-		LogFilePath: test.TempDir() + "dblog.log",
-	})
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
 	// setting up the bfrpool page data to all 1s (4096 bytes)
-	bfrPool.pageMap[0] = 0
+	bfrPool.pageMap[1] = 0
+	bfrPool.pagePool[0].PageId = 1
 	for i := range bfrPool.pagePool[0].pageData {
 		bfrPool.pagePool[0].pageData[i] = 1
 	}
 	bfrPool.pagePool[0].IsDirty = false
+	bfrPool.bpsMux.Lock()
 	flushErr := bfrPool.flushPageByIndex(0)
-	if flushErr != nil || bfrPool.diskMgr.GetPageCount() != 0 {
+	bfrPool.bpsMux.Unlock()
+	if flushErr != nil || bfrPool.diskMgr.GetPageCount() != 1 {
 		test.Errorf("flush page by index is not working as expected")
 	}
 
 }
 
 func TestFlushPage(test *testing.T) {
-	bfrPool := InitBuffPoolMgr(diskmgr.DiskFileInit{
-		DbFilePath:  test.TempDir() + "dbtest.db",
-		LogFilePath: test.TempDir() + "dblog.log",
-	})
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
 	// setting up the bfrpool page data to all 1s (4096 bytes)
 	bfrPool.pageMap[1] = 0
+	bfrPool.pagePool[0].PageId = 1
 	for i := range bfrPool.pagePool[bfrPool.pageMap[1]].pageData {
 		bfrPool.pagePool[bfrPool.pageMap[1]].pageData[i] = 1
 	}
 	bfrPool.pagePool[bfrPool.pageMap[1]].IsDirty = true
 	flushErr := bfrPool.FlushPage(1)
-	if flushErr != nil || bfrPool.diskMgr.GetPageCount() != 1 {
+	if flushErr != nil || bfrPool.diskMgr.GetPageCount() != 2 {
 		test.Errorf("flush page by page id is not working as expected")
 	}
 }
 
 func TestFetchPage(test *testing.T) {
-	bfrPool := InitBuffPoolMgr(diskmgr.DiskFileInit{
-		DbFilePath:  test.TempDir() + "dbtest.db",
-		LogFilePath: test.TempDir() + "dblog.log",
-	})
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
 
 	newPage, _ := bfrPool.NewPage()
-	fetchedPage, fetchErr := bfrPool.FetchPage(0)
+	fetchedPage, fetchErr := bfrPool.FetchPage(newPage.PageId)
 	if fetchErr != nil || newPage != fetchedPage {
 		test.Errorf("fetch page already in buffer not working as expected")
 	}
 }
 
 func TestFetchPageNotInBuffer(test *testing.T) {
-	bfrPool := InitBuffPoolMgr(diskmgr.DiskFileInit{
-		DbFilePath:  test.TempDir() + "dbtest.db",
-		LogFilePath: test.TempDir() + "dblog.log",
-	})
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
 
-	bfrPool.NewPage()
-	delete(bfrPool.pageMap, 0)
-	fetchedPage, fetchErr := bfrPool.FetchPage(0)
+	newPage, _ := bfrPool.NewPage()
+	delete(bfrPool.pageMap, newPage.PageId)
+	fetchedPage, fetchErr := bfrPool.FetchPage(newPage.PageId)
 	if fetchErr != nil || fetchedPage.pageData[0] != 1 {
 		test.Errorf("fetch page already in buffer not working as expected")
 	}
 }
+
+func TestFlushPageByIndexWaitsForLog(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
+	flushedUpTo := int64(0)
+	bfrPool.SetLogFlusher(func(upToLsn int64) error {
+		flushedUpTo = upToLsn
+		return nil
+	})
+	bfrPool.pageMap[1] = 0
+	bfrPool.pagePool[0].PageId = 1
+	bfrPool.pagePool[0].PageLSN = 42
+	bfrPool.pagePool[0].IsDirty = true
+	bfrPool.bpsMux.Lock()
+	flushErr := bfrPool.flushPageByIndex(0)
+	bfrPool.bpsMux.Unlock()
+	if flushErr != nil || flushedUpTo != 42 || bfrPool.pagePool[0].IsDirty {
+		test.Errorf("flush page by index does not flush the log up to the page lsn")
+	}
+}
+
+func TestFlushPageByIndexLogNotDurable(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
+	bfrPool.SetLogFlusher(func(upToLsn int64) error {
+		return errors.New("log device failed")
+	})
+	bfrPool.pageMap[1] = 0
+	bfrPool.pagePool[0].PageId = 1
+	bfrPool.pagePool[0].PageLSN = 7
+	bfrPool.pagePool[0].IsDirty = true
+	bfrPool.bpsMux.Lock()
+	flushErr := bfrPool.flushPageByIndex(0)
+	bfrPool.bpsMux.Unlock()
+	if flushErr == nil || !bfrPool.pagePool[0].IsDirty || bfrPool.diskMgr.GetPageCount() != 1 {
+		test.Errorf("dirty page written before the log is durable")
+	}
+}
+
+func TestSelectPageLogNotDurable(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
+	bfrPool.SetLogFlusher(func(upToLsn int64) error {
+		return errors.New("log device failed")
+	})
+	for i := range constants.BufferPoolSize {
+		bfrPool.freeSet.Delete(i) // every frame holds a dirty page
+		bfrPool.pagePool[i].IsDirty = true
+		bfrPool.pagePool[i].PageLSN = int64(i + 1)
+	}
+	bfrPool.bpsMux.Lock()
+	_, _, err := bfrPool.selectPage(nil)
+	bfrPool.bpsMux.Unlock()
+	if err == nil || bfrPool.diskMgr.GetPageCount() != 1 || bfrPool.pinSet.GetSize() != 0 {
+		test.Errorf("select page evicted a dirty page before the log is durable")
+	}
+}
+
+func TestFetchPageCorrupted(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	bfrPool := getTestPool(test, d, Options{})
+	newPage, _ := bfrPool.NewPage()
+	delete(bfrPool.pageMap, newPage.PageId)
+
+	dbFile, _ := os.OpenFile(d.DbFilePath, os.O_RDWR, 0644)
+	dbFile.WriteAt([]byte{0xff}, int64(newPage.PageId*constants.PageSize)+1000)
+	dbFile.Close()
+
+	fetchedPage, fetchErr := bfrPool.FetchPage(newPage.PageId)
+	if !errors.Is(fetchErr, diskmgr.ErrPageCorrupted) || fetchedPage == nil || !fetchedPage.IsCorrupted {
+		test.Errorf("fetch page does not mark the corrupted page")
+		return
+	}
+	if _, fetchErr = bfrPool.FetchPage(newPage.PageId); !errors.Is(fetchErr, diskmgr.ErrPageCorrupted) {
+		test.Errorf("fetch page of a corrupted page in the buffer does not return an error")
+	}
+}
+
+func TestDeletePage(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
+	bfrPool.NewPage()
+	deletedPage, _ := bfrPool.NewPage()
+	deletedPageId := deletedPage.PageId
+	if err := bfrPool.DeletePage(deletedPageId); err != nil {
+		test.Errorf("delete page failed: %v", err)
+	}
+	if _, ok := bfrPool.pageMap[deletedPageId]; ok {
+		test.Errorf("delete page does not drop the page from the pool")
+	}
+
+	newPage, _ := bfrPool.NewPage()
+	if newPage.PageId != deletedPageId || bfrPool.diskMgr.GetPageCount() != 3 {
+		test.Errorf("new page does not reuse the deleted page")
+	}
+}
+
+// a deleted page can not be fetched or written, until a new page reuses its pageId
+func TestFetchDeletedPage(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
+	deletedPage, _ := bfrPool.NewPage()
+	deletedPageId := deletedPage.PageId
+	bfrPool.DeletePage(deletedPageId)
+	if _, err := bfrPool.FetchPage(deletedPageId); !errors.Is(err, diskmgr.ErrPageNotFound) || !errors.Is(err, diskmgr.ErrPageFree) {
+		test.Errorf("fetch of a deleted page does not return ErrPageNotFound: %v", err)
+	}
+	if _, err := bfrPool.FetchPageWrite(deletedPageId); !errors.Is(err, diskmgr.ErrPageNotFound) {
+		test.Errorf("fetch page write of a deleted page does not return ErrPageNotFound: %v", err)
+	}
+	txn, _ := bfrPool.BeginTxn()
+	if err := bfrPool.WritePageData(txn, deletedPageId, 100, []byte{1}); !errors.Is(err, diskmgr.ErrPageNotFound) {
+		test.Errorf("write of a deleted page does not return ErrPageNotFound: %v", err)
+	}
+	bfrPool.CommitTxn(txn)
+	if err := bfrPool.FlushAllPages(); err != nil {
+		test.Errorf("flush all pages after the writes of a deleted page failed: %v", err)
+	}
+
+	newPage, _ := bfrPool.NewPage()
+	page, err := bfrPool.FetchPage(deletedPageId)
+	if newPage.PageId != deletedPageId || err != nil || page != newPage || page.PageLSN == math.MaxInt64 {
+		test.Errorf("fetch of a reused pageId does not return the new page: %v", err)
+	}
+}
+
+// the page is freed on disk while the pool still caches it, the new page on its pageId drops that stale frame
+func TestNewPageDropsStaleFrame(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
+	stalePage, _ := bfrPool.NewPage()
+	stalePageId := stalePage.PageId
+	if err := bfrPool.diskMgr.DeallocatePage(stalePageId); err != nil {
+		test.Errorf("deallocate page failed: %v", err)
+		return
+	}
+
+	newPage, _ := bfrPool.NewPage()
+	framesOfPage := 0
+	for i := range bfrPool.pagePool {
+		if bfrPool.pagePool[i].PageId == stalePageId {
+			framesOfPage++
+		}
+	}
+	page, err := bfrPool.FetchPage(stalePageId)
+	if newPage.PageId != stalePageId || framesOfPage != 1 || err != nil || page != newPage {
+		test.Errorf("new page on a freed pageId does not drop the stale frame: %d frames", framesOfPage)
+	}
+}
+
+func TestNewPageStaleFramePinned(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
+	stalePage, _ := bfrPool.NewPage()
+	stalePageId := stalePage.PageId
+	bfrPool.PinPage(stalePageId)
+	bfrPool.diskMgr.DeallocatePage(stalePageId)
+	if _, err := bfrPool.NewPage(); !errors.Is(err, ErrPagePinned) {
+		test.Errorf("new page over a pinned stale frame does not return ErrPagePinned: %v", err)
+	}
+	bfrPool.UnpinPage(stalePageId)
+}
+
+func TestDeletePagePinned(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
+	newPage, _ := bfrPool.NewPage()
+	bfrPool.PinPage(newPage.PageId)
+	if err := bfrPool.DeletePage(newPage.PageId); err == nil || bfrPool.diskMgr.GetSuperblock().FreeListHead != 0 {
+		test.Errorf("delete page of a pinned page does not fail")
+	}
+}
+
+func TestInitBufferPoolMgrError(test *testing.T) {
+	bfrPool, err := InitBuffPoolMgr(diskmgr.DiskFileInit{
+		DbFilePath:  test.TempDir() + "dbtest.txt",
+		LogFilePath: test.TempDir() + "dblog.log",
+	}, Options{})
+	if bfrPool != nil || !errors.Is(err, diskmgr.ErrInvalidDbFilePath) {
+		test.Errorf("init buffer pool with a bad path does not return an error")
+	}
+}
+
+// recovery fails on a page that is garbage on disk, the files opened for the pool are closed again
+func TestInitBufferPoolMgrRecoveryErrorClosesFiles(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	bfrPool := getTestPool(test, d, Options{})
+	newPage, _ := bfrPool.NewPage()
+	txn, _ := bfrPool.BeginTxn()
+	bfrPool.WritePageData(txn, newPage.PageId, 100, []byte{7})
+	bfrPool.CommitTxn(txn)
+	abandonTestPool(bfrPool)
+	bfrPool.diskMgr.Close()
+
+	dbFile, _ := os.OpenFile(d.DbFilePath, os.O_RDWR, 0)
+	dbFile.WriteAt([]byte{0xff, 0xff, 0xff, 0xff}, int64(newPage.PageId*constants.PageSize+200))
+	dbFile.Close()
+	if _, err := InitBuffPoolMgr(d, Options{}); !errors.Is(err, diskmgr.ErrPageCorrupted) {
+		test.Errorf("recovery on a corrupted page does not return ErrPageCorrupted: %v", err)
+	}
+
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		test.Skipf("open files can not be listed: %v", err)
+	}
+	for _, fd := range fds {
+		if path, _ := os.Readlink("/proc/self/fd/" + fd.Name()); path == d.DbFilePath || path == d.LogFilePath {
+			test.Errorf("%s is still open after init failed", path)
+		}
+	}
+}
+
+func TestSelectPageAllPinned(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
+	for i := range constants.BufferPoolSize {
+		bfrPool.pinPageByIndex(i)
+	}
+	if _, err := bfrPool.NewPage(); !errors.Is(err, ErrNoFreeFrame) {
+		test.Errorf("new page with every page pinned does not return ErrNoFreeFrame")
+	}
+}
+
+func TestFlushPageByIndexPinned(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
+	newPage, _ := bfrPool.NewPage()
+	bfrPool.PinPage(newPage.PageId)
+	if err := bfrPool.FlushPage(newPage.PageId); !errors.Is(err, ErrPagePinned) {
+		test.Errorf("flush of a pinned page does not return ErrPagePinned")
+	}
+	if err := bfrPool.DeletePage(newPage.PageId); !errors.Is(err, ErrPagePinned) {
+		test.Errorf("delete of a pinned page does not return ErrPagePinned")
+	}
+}
+
+func TestClose(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	bfrPool := getTestPool(test, d, Options{})
+	newPage, _ := bfrPool.NewPage()
+	txn, _ := bfrPool.BeginTxn()
+	bfrPool.WritePageData(txn, newPage.PageId, 100, []byte{5})
+	bfrPool.CommitTxn(txn)
+
+	bfrPool.PinPage(newPage.PageId)
+	if err := bfrPool.Close(); !errors.Is(err, ErrPagePinned) {
+		test.Errorf("close with a pinned page does not return ErrPagePinned")
+	}
+	bfrPool.UnpinPage(newPage.PageId)
+	if err := bfrPool.Close(); err != nil {
+		test.Errorf("close failed: %v", err)
+	}
+
+	if _, err := bfrPool.FetchPage(newPage.PageId); !errors.Is(err, ErrClosed) {
+		test.Errorf("fetch page after close does not return ErrClosed")
+	}
+	if _, err := bfrPool.NewPage(); !errors.Is(err, ErrClosed) {
+		test.Errorf("new page after close does not return ErrClosed")
+	}
+	if _, err := bfrPool.BeginTxn(); !errors.Is(err, ErrClosed) {
+		test.Errorf("begin txn after close does not return ErrClosed")
+	}
+	if err := bfrPool.Close(); !errors.Is(err, ErrClosed) {
+		test.Errorf("second close does not return ErrClosed")
+	}
+
+	// the dirty page was written by close, so recovery has nothing to redo
+	reopenedPool := getTestPool(test, d, Options{})
+	page, fetchErr := reopenedPool.FetchPage(newPage.PageId)
+	if fetchErr != nil || page.pageData[100] != 5 || reopenedPool.GetRecoveryReport().RedoneRecords != 0 {
+		test.Errorf("page not durable after close")
+	}
+	reopenedPool.Close()
+}
+
+// gatedDiskMgr holds the reads and writes of gatedPageId until gate is closed, every held call is reported on entered
+type gatedDiskMgr struct {
+	diskmgr.DiskFileMgr
+	gatedPageId atomic.Int64
+	gate        chan struct{}
+	entered     chan int
+	numReads    atomic.Int64
+}
+
+func (gd *gatedDiskMgr) ReadPage(pageId int, read []byte) error {
+	if int64(pageId) == gd.gatedPageId.Load() {
+		gd.numReads.Add(1)
+		gd.entered <- pageId
+		<-gd.gate
+	}
+	return gd.DiskFileMgr.ReadPage(pageId, read)
+}
+
+func (gd *gatedDiskMgr) WritePage(pageId int, writeData []byte) error {
+	if int64(pageId) == gd.gatedPageId.Load() {
+		gd.entered <- pageId
+		<-gd.gate
+	}
+	return gd.DiskFileMgr.WritePage(pageId, writeData)
+}
+
+// getGatedPool creates numPages pages and reopens the files with a pool of poolFrames frames over a gatedDiskMgr
+func getGatedPool(test *testing.T, numPages int, poolFrames int) (*BuffPoolMgrStr, *gatedDiskMgr) {
+	d := disktest.GetFileInit(test)
+	setupPool := getTestPool(test, d, Options{})
+	for range numPages {
+		setupPool.NewPage()
+	}
+	setupPool.Close()
+
+	diskMgr, _ := diskmgr.GetDiskFileMgr(d)
+	gatedDiskMgr := &gatedDiskMgr{DiskFileMgr: diskMgr, gate: make(chan struct{}), entered: make(chan int, 1000)}
+	bfrPool := getTestPoolOn(test, gatedDiskMgr, Options{PoolFrames: poolFrames})
+	return bfrPool, gatedDiskMgr
+}
+
+func TestFetchPageConcurrentMiss(test *testing.T) {
+	bfrPool, gatedDiskMgr := getGatedPool(test, 2, 8)
+	cachedPage, _ := bfrPool.FetchPage(2)
+	bfrPool.PinPage(2) // the lru-k fallback can evict any unpinned frame
+	gatedDiskMgr.gatedPageId.Store(1)
+
+	const numFetchers = 50
+	pages := make([]*Page, numFetchers)
+	var wg sync.WaitGroup
+	for i := range numFetchers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			page, err := bfrPool.FetchPage(1)
+			if err != nil {
+				test.Errorf("concurrent fetch page failed: %v", err)
+			}
+			pages[i] = page
+		}()
+	}
+	<-gatedDiskMgr.entered
+
+	// the read of pageId 1 is held, a hit on another page does not wait for it
+	hit := make(chan *Page)
+	go func() {
+		page, _ := bfrPool.FetchPage(2)
+		hit <- page
+	}()
+	select {
+	case page := <-hit:
+		if page != cachedPage {
+			test.Errorf("fetch page hit during a miss returned the wrong page")
+		}
+	case <-time.After(5 * time.Second):
+		test.Fatalf("fetch page hit waits for the read of another page")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(gatedDiskMgr.gate)
+	wg.Wait()
+	if gatedDiskMgr.numReads.Load() != 1 {
+		test.Errorf("page read %d times by concurrent fetchers", gatedDiskMgr.numReads.Load())
+	}
+	for _, page := range pages {
+		if page != pages[0] || page.PageId != 1 {
+			test.Errorf("concurrent fetchers did not get the same page")
+			break
+		}
+	}
+}
+
+func TestFetchPageWaitsForWriteBack(test *testing.T) {
+	bfrPool, gatedDiskMgr := getGatedPool(test, 2, 1)
+	guard, _ := bfrPool.FetchPageWrite(1)
+	guard.MutableData()[100] = 6
+	guard.Release()
+	gatedDiskMgr.gatedPageId.Store(1)
+
+	// fetching page 2 evicts page 1 from the only frame, its write back is held
+	go bfrPool.FetchPage(2)
+	<-gatedDiskMgr.entered
+	gatedDiskMgr.gatedPageId.Store(0)
+
+	fetched := make(chan *Page)
+	go func() {
+		for {
+			page, err := bfrPool.FetchPageRead(1)
+			if errors.Is(err, ErrNoFreeFrame) {
+				continue
+			}
+			if err != nil {
+				test.Errorf("fetch page read after the write back failed: %v", err)
+				fetched <- nil
+				return
+			}
+			pageData := append([]byte(nil), page.Data()...)
+			page.Release()
+			fetched <- &Page{pageData: pageData}
+		}
+	}()
+	select {
+	case <-fetched:
+		test.Fatalf("page fetched while its write back is still running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(gatedDiskMgr.gate)
+	if page := <-fetched; page == nil || page.pageData[100] != 6 {
+		test.Errorf("fetch page did not see the written back page")
+	}
+}
+
+/*
+BenchmarkFetchPageParallel fetches pages from a pool that holds a quarter of them, so that hits and misses run side by side.
+run it with -cpu 1,2,4,8 to see the throughput scale with GOMAXPROCS.
+*/
+func BenchmarkFetchPageParallel(b *testing.B) {
+	const numPages, poolFrames = 256, 64
+	d := disktest.GetFileInit(b)
+	bfrPool := getTestPool(b, d, Options{PoolFrames: poolFrames, SyncPolicy: diskmgr.SyncOnFlush})
+	for range numPages {
+		bfrPool.NewPage()
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		rng := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			// most of the fetches go to the first pages, like a hot index root
+			pageId := 1 + rng.Intn(poolFrames/2)
+			if rng.Intn(4) == 0 {
+				pageId = 1 + rng.Intn(numPages)
+			}
+			guard, err := bfrPool.FetchPageRead(pageId)
+			if err != nil {
+				continue
+			}
+			guard.Release()
+		}
+	})
+}
+
+func TestNewPageConcurrent(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{PoolFrames: 64, SyncPolicy: diskmgr.SyncOnFlush})
+	const numWorkers, pagesPerWorker = 8, 5
+	pageIds := make(chan int, numWorkers*pagesPerWorker)
+	var wg sync.WaitGroup
+	for range numWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range pagesPerWorker {
+				guard, err := bfrPool.NewPageWrite()
+				if err != nil {
+					test.Errorf("concurrent new page failed: %v", err)
+					return
+				}
+				pageIds <- guard.PageId()
+				guard.Release()
+			}
+		}()
+	}
+	wg.Wait()
+	close(pageIds)
+
+	seen := make(map[int]bool)
+	for pageId := range pageIds {
+		if seen[pageId] {
+			test.Errorf("pageId %d given out twice by concurrent new pages", pageId)
+		}
+		seen[pageId] = true
+	}
+	if bfrPool.diskMgr.GetPageCount() != numWorkers*pagesPerWorker+1 {
+		test.Errorf("concurrent new pages not all written to the db file")
+	}
+}
+
+func TestReplClockTicksPerAccess(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{PoolFrames: 2})
+	pageIds := make([]int, 3)
+	for i := range pageIds {
+		newPage, _ := bfrPool.NewPage()
+		pageIds[i] = newPage.PageId
+	}
+	before := bfrPool.replClock
+	bfrPool.FetchPage(pageIds[2]) // hit
+	bfrPool.FetchPage(pageIds[0]) // miss, one tick for the eviction and one for the access
+	if bfrPool.replClock != before+3 {
+		test.Errorf("repl clock not working as expected: %d ticks for one hit and one miss", bfrPool.replClock-before)
+	}
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"slices"
+	"time"
+)
+
+/*
+the background writer trickles dirty pages out to disk so that an eviction finds a clean victim and a fetch miss does not pay for
+a write and a sync. every bgWriterInterval it writes up to bgWriterPages dirty unpinned pages, once more than bgWriterDirtyRatio of
+the frames are dirty. the pages are written in pageId order, every round goes on after the last page of the round before,
+and the db file is synced once per round.
+a page is pinned and latched shared while it is written, it is marked clean only after the sync and only if it did not change meanwhile.
+*/
+
+// startBgWriter starts the background writer if it is turned on and not running
+func (bp *BuffPoolMgrStr) startBgWriter() {
+	bp.bgWriterMux.Lock()
+	defer bp.bgWriterMux.Unlock()
+	if bp.bgWriterPages == 0 || bp.bgWriterStop != nil || bp.closed.Load() {
+		return
+	}
+	bp.bgWriterStop = make(chan struct{})
+	bp.bgWriterDone = make(chan struct{})
+	go bp.bgWriter(bp.bgWriterStop, bp.bgWriterDone)
+}
+
+// stopBgWriter stops the background writer and waits for the round it is running, bpsMux should not be held
+func (bp *BuffPoolMgrStr) stopBgWriter() {
+	bp.bgWriterMux.Lock()
+	defer bp.bgWriterMux.Unlock()
+	if bp.bgWriterStop == nil {
+		return
+	}
+	close(bp.bgWriterStop)
+	<-bp.bgWriterDone
+	bp.bgWriterStop = nil
+	bp.bgWriterDone = nil
+}
+
+func (bp *BuffPoolMgrStr) bgWriter(stop chan struct{}, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(bp.bgWriterInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// a failed write leaves the page dirty, the next round or the eviction of the page tries again
+			bp.bgWriteRound()
+		}
+	}
+}
+
+/*
+bgWriteRound writes one round of dirty pages and returns how many were written and marked clean.
+bpsMux is only held to pick the pages and to pin and unpin them, the pages are written with one call and synced without it.
+*/
+func (bp *BuffPoolMgrStr) bgWriteRound() (written int, writeErr error) {
+	bp.bpsMux.Lock()
+	if bp.closed.Load() {
+		bp.bpsMux.Unlock()
+		return 0, ErrClosed
+	}
+	writes := bp.bgLatchPages()
+	bp.bpsMux.Unlock()
+	if len(writes) == 0 {
+		return 0, nil
+	}
+
+	writeErr = bp.writeLatchedPages(writes)
+	bp.bpsMux.Lock()
+	bp.unlatchPages(writes)
+	bp.bpsMux.Unlock()
+	if writeErr != nil {
+		return 0, writeErr
+	}
+	bp.bgWriterCursor = writes[len(writes)-1].pageId
+	if writeErr = bp.diskMgr.Sync(); writeErr != nil {
+		return 0, writeErr
+	}
+
+	bp.bpsMux.Lock()
+	defer bp.bpsMux.Unlock()
+	written = bp.markPagesWritten(writes)
+	bp.stats.BgWrites += int64(written)
+	return written, nil
+}
+
+/*
+bgLatchPages picks the dirty unpinned pages the round writes, in pageId order after the cursor and then from the start,
+or none while the pool is below the dirty ratio. the pages are returned pinned, so that they are not evicted while they are written
+without bpsMux, and latched shared. bpsMux should be held.
+*/
+func (bp *BuffPoolMgrStr) bgLatchPages() (writes []pageWrite) {
+	dirtyPageIds := make([]int, 0)
+	for pageId, pageIndex := range bp.pageMap {
+		// the dirty flag is changed under the page latch, a page latched exclusive is left for the next round
+		page := &bp.pagePool[pageIndex]
+		if bp.inFlight[pageId] != nil || !page.pageMux.TryRLock() {
+			continue
+		}
+		if page.IsDirty && !page.IsCorrupted {
+			dirtyPageIds = append(dirtyPageIds, pageId)
+		}
+		page.pageMux.RUnlock()
+	}
+	if float64(len(dirtyPageIds)) <= bp.bgWriterDirtyRatio*float64(len(bp.pagePool)) {
+		return nil
+	}
+	slices.Sort(dirtyPageIds)
+	start, _ := slices.BinarySearch(dirtyPageIds, bp.bgWriterCursor+1)
+	// the pages after the cursor and the ones from the start are written by separate runs, each in pageId order
+	dirtyPageIds = append(dirtyPageIds[start:], dirtyPageIds[:start]...)
+
+	for _, pageId := range dirtyPageIds {
+		if len(writes) == bp.bgWriterPages {
+			break
+		}
+		pageIndex := bp.pageMap[pageId]
+		page := &bp.pagePool[pageIndex]
+		if page.Pin != 0 || !page.pageMux.TryRLock() {
+			continue
+		}
+		bp.pinPageByIndex(pageIndex)
+		writes = append(writes, pageWrite{pageId: pageId, pageIndex: pageIndex, changeCount: page.changeCount})
+	}
+	return writes
+}
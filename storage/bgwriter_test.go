@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rohithputha/HymStMgr/diskmgr"
+	"github.com/rohithputha/HymStMgr/diskmgr/disktest"
+)
+
+// getBgWriterTestPool returns a pool of 16 frames with 10 pages, the writer does not tick on its own so the rounds are run by the test
+func getBgWriterTestPool(test *testing.T, opts Options) (*BuffPoolMgrStr, *recordingDiskMgr, []*Page) {
+	diskMgr, _ := diskmgr.GetDiskFileMgr(disktest.GetFileInit(test))
+	recDiskMgr := &recordingDiskMgr{DiskFileMgr: diskMgr}
+	opts.PoolFrames = 16
+	opts.BgWriterInterval = time.Hour
+	bfrPool := getTestPoolOn(test, recDiskMgr, opts)
+	pages := make([]*Page, 0)
+	for range 10 {
+		newPage, _ := bfrPool.NewPage()
+		pages = append(pages, newPage)
+	}
+	recDiskMgr.writtenPageIds, recDiskMgr.numSyncs = nil, 0
+	return bfrPool, recDiskMgr, pages
+}
+
+func TestBgWriteRound(test *testing.T) {
+	bfrPool, recDiskMgr, pages := getBgWriterTestPool(test, Options{BgWriterPages: 4})
+	for _, i := range []int{8, 1, 6, 3, 0, 5} {
+		fillPage(pages[i], byte(i+1))
+	}
+
+	// pageId order, the second round goes on after the last page of the first
+	written, err := bfrPool.bgWriteRound()
+	expected := []int{pages[0].PageId, pages[1].PageId, pages[3].PageId, pages[5].PageId}
+	if err != nil || written != 4 || !slices.Equal(recDiskMgr.writtenPageIds, expected) || recDiskMgr.numSyncs != 1 {
+		test.Errorf("background write round wrote %v with %d syncs, expected %v with 1 sync: %v", recDiskMgr.writtenPageIds, recDiskMgr.numSyncs, expected, err)
+	}
+	recDiskMgr.writtenPageIds = nil
+	fillPage(pages[0], 9)
+	written, _ = bfrPool.bgWriteRound()
+	expected = []int{pages[6].PageId, pages[8].PageId, pages[0].PageId}
+	if written != 3 || !slices.Equal(recDiskMgr.writtenPageIds, expected) {
+		test.Errorf("second background write round wrote %v, expected %v", recDiskMgr.writtenPageIds, expected)
+	}
+	for _, page := range pages {
+		if page.IsDirty {
+			test.Errorf("pageId %d still dirty after the background write rounds", page.PageId)
+		}
+	}
+	if bfrPool.GetStats().BgWrites != 7 {
+		test.Errorf("background writes not counted as expected: %+v", bfrPool.GetStats())
+	}
+
+	readData := make([]byte, bfrPool.pageSize)
+	if bfrPool.diskMgr.ReadPage(pages[0].PageId, readData) != nil || readData[100] != 9 {
+		test.Errorf("page written by the background writer not on disk")
+	}
+}
+
+func TestBgWriteRoundDirtyRatio(test *testing.T) {
+	bfrPool, recDiskMgr, pages := getBgWriterTestPool(test, Options{BgWriterPages: 4, BgWriterDirtyRatio: 0.25})
+	for i := range 4 {
+		fillPage(pages[i], 1)
+	}
+	if written, _ := bfrPool.bgWriteRound(); written != 0 || len(recDiskMgr.writtenPageIds) != 0 {
+		test.Errorf("background writer wrote with a quarter of the frames dirty")
+	}
+	fillPage(pages[4], 1)
+	if written, _ := bfrPool.bgWriteRound(); written != 4 {
+		test.Errorf("background writer did not write above the dirty ratio")
+	}
+}
+
+func TestBgWriteRoundSkipsPinnedAndLatched(test *testing.T) {
+	bfrPool, recDiskMgr, pages := getBgWriterTestPool(test, Options{BgWriterPages: 4})
+	for i := range 4 {
+		fillPage(pages[i], 1)
+	}
+	bfrPool.PinPage(pages[0].PageId)
+	guard, _ := bfrPool.FetchPageWrite(pages[1].PageId)
+	written, _ := bfrPool.bgWriteRound()
+	if expected := []int{pages[2].PageId, pages[3].PageId}; written != 2 || !slices.Equal(recDiskMgr.writtenPageIds, expected) {
+		test.Errorf("background writer wrote %v, expected %v", recDiskMgr.writtenPageIds, expected)
+	}
+	if !pages[0].IsDirty || !pages[1].IsDirty {
+		test.Errorf("pinned or latched pages marked clean by the background writer")
+	}
+	guard.Release()
+	bfrPool.UnpinPage(pages[0].PageId)
+}
+
+// syncHookDiskMgr runs onSync before every sync
+type syncHookDiskMgr struct {
+	*recordingDiskMgr
+	onSync func()
+}
+
+func (sd *syncHookDiskMgr) Sync() error {
+	sd.onSync()
+	return sd.recordingDiskMgr.Sync()
+}
+
+func TestBgWriteRoundPageChanged(test *testing.T) {
+	bfrPool, recDiskMgr, pages := getBgWriterTestPool(test, Options{BgWriterPages: 4})
+	for i := range 2 {
+		fillPage(pages[i], 1)
+	}
+	// a change made between the write and the sync keeps the page dirty
+	bfrPool.diskMgr = &syncHookDiskMgr{recordingDiskMgr: recDiskMgr, onSync: func() {
+		guard, _ := bfrPool.FetchPageWrite(pages[0].PageId)
+		guard.MutableData()[100] = 2
+		guard.Release()
+	}}
+	if written, err := bfrPool.bgWriteRound(); err != nil || written != 1 || !pages[0].IsDirty || pages[1].IsDirty {
+		test.Errorf("background writer marked a changed page clean: %v", err)
+	}
+	bfrPool.diskMgr = recDiskMgr
+}
+
+// the dirty ratio is below one frame, so the writer writes every dirty page
+func TestBgWriterCleansVictims(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{PoolFrames: 16, BgWriterPages: 16, BgWriterInterval: time.Millisecond, BgWriterDirtyRatio: 0.01})
+	pageIds := make([]int, 0)
+	for range 16 {
+		guard, _ := bfrPool.NewPageWrite()
+		guard.MutableData()[100] = 1
+		pageIds = append(pageIds, guard.PageId())
+		guard.Release()
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for bfrPool.GetStats().BgWrites < 16 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	bfrPool.stopBgWriter()
+	for range 16 {
+		bfrPool.NewPage()
+	}
+	if stats := bfrPool.GetStats(); stats.BgWrites != 16 || stats.VictimWrites != 0 {
+		test.Errorf("evictions after the background writer did not find clean frames: %+v", stats)
+	}
+	for _, pageId := range pageIds {
+		if page, err := bfrPool.FetchPage(pageId); err != nil || page.pageData[100] != 1 {
+			test.Errorf("pageId %d written by the background writer not durable", pageId)
+		}
+	}
+	bfrPool.Close()
+}
+
+func TestCloseStopsBgWriter(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{PoolFrames: 16, BgWriterPages: 4, BgWriterInterval: time.Millisecond})
+	if bfrPool.bgWriterStop == nil {
+		test.Errorf("background writer not started")
+	}
+	page, _ := bfrPool.NewPage()
+	bfrPool.PinPage(page.PageId)
+	if bfrPool.Close() == nil || bfrPool.bgWriterStop == nil {
+		test.Errorf("background writer not running after a failed close")
+	}
+	bfrPool.UnpinPage(page.PageId)
+	if err := bfrPool.Close(); err != nil || bfrPool.bgWriterStop != nil {
+		test.Errorf("close did not stop the background writer: %v", err)
+	}
+
+	noBgWriterPool := getTestPool(test, disktest.GetFileInit(test), Options{BgWriterPages: -1})
+	if noBgWriterPool.bgWriterStop != nil {
+		test.Errorf("background writer runs although it is turned off")
+	}
+	noBgWriterPool.Close()
+}
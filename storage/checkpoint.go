@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"github.com/rohithputha/HymStMgr/logmgr"
+)
+
+/*
+Checkpoint takes a fuzzy checkpoint: a begin record, then the active txn table and the dirty page table are copied
+under their own short locks (txns and page changes keep going meanwhile, no page is flushed), the db file is synced and the end record carries the tables.
+once the end record is durable, every log record before the oldest lsn that recovery can still need is truncated:
+that is the smallest of the checkpoint begin lsn, the recLsn of the dirty pages and the first lsn of the active txns.
+*/
+func (bp *BuffPoolMgrStr) Checkpoint() (ckptErr error) {
+	if bp.closed.Load() {
+		return ErrClosed
+	}
+	beginLsn, ckptErr := bp.logMgr.AppendLogRecord(&logmgr.LogRecord{RecType: logmgr.LogCheckpointBegin})
+	if ckptErr != nil {
+		return ckptErr
+	}
+	ckptData := &logmgr.CheckpointData{
+		BeginLsn:   beginLsn,
+		ActiveTxns: make(map[int64]logmgr.CheckpointTxn),
+		DirtyPages: make(map[int]int64),
+	}
+
+	/*
+		a txn is committed once its commit record is in the log, not only once the record is durable: analysis starts at the
+		begin record and does not see a commit record before it. the end record carries the table, it is only durable after the commit.
+	*/
+	bp.txnMux.Lock()
+	ckptData.NextTxnId = bp.nextTxnId
+	for txnId, txn := range bp.activeTxns {
+		ckptData.ActiveTxns[txnId] = logmgr.CheckpointTxn{
+			FirstLsn:  txn.firstLsn,
+			LastLsn:   txn.prevLsn,
+			Committed: txn.commitLsn != logmgr.InvalidLsn,
+		}
+	}
+	bp.txnMux.Unlock()
+
+	bp.bpsMux.Lock()
+	for pageIndex := range bp.pagePool {
+		page := &bp.pagePool[pageIndex]
+		/*
+			recLSN is set under the page latch and cleared by write-backs that run without bpsMux, so it is read once.
+			the frame of a victim is out of pageMap while its write-back runs, its page is dirty until the write is done,
+			so every frame with a recLSN counts, whatever pageMap says.
+		*/
+		recLsn := page.recLSN.Load()
+		if recLsn == logmgr.InvalidLsn {
+			continue
+		}
+		if dirtyLsn, ok := ckptData.DirtyPages[page.PageId]; !ok || recLsn < dirtyLsn {
+			ckptData.DirtyPages[page.PageId] = recLsn
+		}
+	}
+	bp.bpsMux.Unlock()
+
+	/*
+		write-backs under SyncOnFlush and SyncPeriodic clear the recLSN before their page is synced, so a page missing from the table
+		is only clean on disk after this sync. it runs before the end record is appended: once that is durable, recovery redoes no page from before the table.
+	*/
+	if ckptErr = bp.diskMgr.Sync(); ckptErr != nil {
+		return ckptErr
+	}
+	endLsn, ckptErr := bp.logMgr.AppendLogRecord(logmgr.GetCheckpointEndRecord(ckptData))
+	if ckptErr != nil {
+		return ckptErr
+	}
+	if ckptErr = bp.logMgr.Flush(endLsn); ckptErr != nil {
+		return ckptErr
+	}
+	truncLsn := beginLsn
+	for _, recLsn := range ckptData.DirtyPages {
+		truncLsn = min(truncLsn, recLsn)
+	}
+	for _, ckptTxn := range ckptData.ActiveTxns {
+		truncLsn = min(truncLsn, ckptTxn.FirstLsn)
+	}
+	return bp.logMgr.TruncateBefore(truncLsn)
+}
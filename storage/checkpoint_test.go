@@ -0,0 +1,338 @@
+package storage
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/rohithputha/HymStMgr/diskmgr"
+	"github.com/rohithputha/HymStMgr/diskmgr/disktest"
+	"github.com/rohithputha/HymStMgr/logmgr"
+)
+
+func TestCheckpointTruncatesLog(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	bfrPool := getTestPool(test, d, Options{})
+	newPage, _ := bfrPool.NewPage()
+	for i := 0; i < 50; i++ {
+		txn, _ := bfrPool.BeginTxn()
+		bfrPool.WritePageData(txn, newPage.PageId, 100, []byte{byte(i)})
+		bfrPool.CommitTxn(txn)
+	}
+	bfrPool.FlushPage(newPage.PageId)
+	logStart := bfrPool.diskMgr.GetLogStart()
+
+	if ckptErr := bfrPool.Checkpoint(); ckptErr != nil {
+		test.Errorf("checkpoint failed: %v", ckptErr)
+		return
+	}
+	if bfrPool.diskMgr.GetLogStart() <= logStart {
+		test.Errorf("checkpoint did not truncate the log: %d <= %d", bfrPool.diskMgr.GetLogStart(), logStart)
+	}
+	rec, _ := bfrPool.logMgr.GetLogIterator().Next()
+	if rec.RecType != logmgr.LogCheckpointBegin {
+		test.Errorf("log after truncate does not start at the checkpoint")
+	}
+}
+
+func TestCheckpointKeepsActiveTxnLog(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	bfrPool := getTestPool(test, d, Options{})
+	newPage, _ := bfrPool.NewPage()
+	activeTxn, _ := bfrPool.BeginTxn()
+	bfrPool.WritePageData(activeTxn, newPage.PageId, 200, []byte{9})
+	for i := 0; i < 10; i++ {
+		txn, _ := bfrPool.BeginTxn()
+		bfrPool.WritePageData(txn, newPage.PageId, 100, []byte{byte(i)})
+		bfrPool.CommitTxn(txn)
+	}
+	bfrPool.Checkpoint()
+
+	rec, _ := bfrPool.logMgr.GetLogIterator().Next()
+	if rec.Lsn != activeTxn.firstLsn {
+		test.Errorf("checkpoint truncated the log of an active txn")
+	}
+}
+
+func TestRecoverFromCheckpoint(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	bfrPool := getTestPool(test, d, Options{})
+	newPage, _ := bfrPool.NewPage()
+	committedTxn, _ := bfrPool.BeginTxn()
+	bfrPool.WritePageData(committedTxn, newPage.PageId, 100, []byte{1})
+	bfrPool.CommitTxn(committedTxn)
+	loserTxn, _ := bfrPool.BeginTxn()
+	bfrPool.WritePageData(loserTxn, newPage.PageId, 200, []byte{2})
+	bfrPool.Checkpoint()
+	ckptRec, _ := bfrPool.logMgr.GetLogIterator().Next()
+	bfrPool.WritePageData(loserTxn, newPage.PageId, 201, []byte{3})
+	bfrPool.logMgr.Flush(bfrPool.logMgr.GetLastLsn())
+	bfrPool.FlushPage(newPage.PageId)
+	// crash with the uncommitted changes on disk and the committed one only in the log
+
+	reopenedPool := getTestPool(test, d, Options{})
+	report := reopenedPool.GetRecoveryReport()
+	page, _ := reopenedPool.FetchPage(newPage.PageId)
+	if page.pageData[100] != 1 || page.pageData[200] != 0 || page.pageData[201] != 0 {
+		test.Errorf("recovery from checkpoint did not restore the committed state")
+	}
+	if report.AnalysisStartLsn == logmgr.InvalidLsn || report.AnalysisStartLsn < ckptRec.Lsn {
+		test.Errorf("analysis did not start from the last checkpoint: %+v", report)
+	}
+	if len(report.RolledBackTxns) != 1 || report.RolledBackTxns[0] != loserTxn.TxnId {
+		test.Errorf("loser txn from the checkpoint not rolled back: %+v", report)
+	}
+}
+
+// checkpoints run while txns write pages that get evicted and written back, the log kept by the checkpoints still recovers every commit
+func TestCheckpointConcurrentTxns(test *testing.T) {
+	const numPages, numWorkers, numRounds = 16, 4, 50
+	d := disktest.GetFileInit(test)
+	bfrPool := getTestPool(test, d, Options{PoolFrames: 4})
+	pageIds := make([]int, numPages)
+	for i := range pageIds {
+		guard, _ := bfrPool.NewPageWrite()
+		pageIds[i] = guard.PageId()
+		guard.Release()
+	}
+
+	lastValues := make([][numPages]byte, numWorkers)
+	var wg sync.WaitGroup
+	for worker := range numWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for round := range numRounds {
+				i := (worker + round) % numPages
+				txn, err := bfrPool.BeginTxn()
+				if err == nil {
+					err = bfrPool.WritePageData(txn, pageIds[i], 100+worker, []byte{byte(round + 1)})
+				}
+				if err == nil {
+					err = bfrPool.CommitTxn(txn)
+				}
+				if err != nil {
+					test.Errorf("txn of worker %d failed: %v", worker, err)
+					return
+				}
+				lastValues[worker][i] = byte(round + 1)
+			}
+		}()
+	}
+	stop := make(chan struct{})
+	ckptDone := make(chan struct{})
+	go func() {
+		defer close(ckptDone)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := bfrPool.Checkpoint(); err != nil {
+				test.Errorf("checkpoint during txns failed: %v", err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	<-ckptDone
+	// crash, the pages left in the pool never reach the disk
+	abandonTestPool(bfrPool)
+	bfrPool.diskMgr.Close()
+
+	reopenedPool := getTestPool(test, d, Options{PoolFrames: 4})
+	for i, pageId := range pageIds {
+		guard, err := reopenedPool.FetchPageRead(pageId)
+		if err != nil {
+			test.Errorf("fetch of page %d after recovery failed: %v", pageId, err)
+			continue
+		}
+		for worker := range numWorkers {
+			if guard.Data()[100+worker] != lastValues[worker][i] {
+				test.Errorf("page %d lost the last commit of worker %d", pageId, worker)
+			}
+		}
+		guard.Release()
+	}
+}
+
+// blockFlushLogMgr holds the flush of blockLsn until release is closed
+type blockFlushLogMgr struct {
+	logmgr.LogMgr
+	blockLsn int64
+	flushing chan struct{}
+	release  chan struct{}
+}
+
+func (bl *blockFlushLogMgr) Flush(upToLsn int64) error {
+	if upToLsn == bl.blockLsn {
+		close(bl.flushing)
+		<-bl.release
+	}
+	return bl.LogMgr.Flush(upToLsn)
+}
+
+// a checkpoint between the append of a commit record and its flush begins after the commit record, the txn is still committed
+func TestCheckpointDuringCommit(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	bfrPool := getTestPool(test, d, Options{})
+	newPage, _ := bfrPool.NewPage()
+	txn, _ := bfrPool.BeginTxn()
+	bfrPool.WritePageData(txn, newPage.PageId, 100, []byte{6})
+
+	logMgr := bfrPool.logMgr
+	blockLogMgr := &blockFlushLogMgr{LogMgr: logMgr, blockLsn: logMgr.GetLastLsn() + 1, flushing: make(chan struct{}), release: make(chan struct{})}
+	bfrPool.logMgr = blockLogMgr
+	commitDone := make(chan struct{})
+	go func() {
+		defer close(commitDone)
+		bfrPool.CommitTxn(txn)
+	}()
+	<-blockLogMgr.flushing
+	ckptErr := bfrPool.Checkpoint()
+	// crash before the end record of the txn is in the log
+	abandonTestPool(bfrPool)
+	bfrPool.diskMgr.Close()
+	close(blockLogMgr.release)
+	<-commitDone
+	if ckptErr != nil {
+		test.Errorf("checkpoint during a commit failed: %v", ckptErr)
+		return
+	}
+
+	reopenedPool := getTestPool(test, d, Options{})
+	page, err := reopenedPool.FetchPage(newPage.PageId)
+	if err != nil || page.pageData[100] != 6 || len(reopenedPool.GetRecoveryReport().RolledBackTxns) != 0 {
+		test.Errorf("txn committed before a checkpoint rolled back by recovery: %+v", reopenedPool.GetRecoveryReport())
+	}
+}
+
+// blockWriteDiskMgr holds the write of blockPageId until release is closed
+type blockWriteDiskMgr struct {
+	diskmgr.DiskFileMgr
+	blockPageId int
+	writing     chan struct{}
+	release     chan struct{}
+}
+
+func (bd *blockWriteDiskMgr) WritePage(pageId int, writeData []byte) error {
+	if pageId == bd.blockPageId {
+		close(bd.writing)
+		<-bd.release
+	}
+	return bd.DiskFileMgr.WritePage(pageId, writeData)
+}
+
+func (bd *blockWriteDiskMgr) WritePages(pageIds []int, pagesData [][]byte) error {
+	if slices.Contains(pageIds, bd.blockPageId) {
+		close(bd.writing)
+		<-bd.release
+	}
+	return bd.DiskFileMgr.WritePages(pageIds, pagesData)
+}
+
+func TestCheckpointInFlightVictim(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{PoolFrames: 2})
+	dirtyPage, _ := bfrPool.NewPage()
+	txn, _ := bfrPool.BeginTxn()
+	bfrPool.WritePageData(txn, dirtyPage.PageId, 100, []byte{1})
+	bfrPool.CommitTxn(txn)
+	// the frame holds the new page once the write back is done
+	dirtyPageId, recLsn := dirtyPage.PageId, dirtyPage.recLSN.Load()
+	pinnedPage, _ := bfrPool.NewPage()
+	bfrPool.PinPage(pinnedPage.PageId)
+
+	diskMgr := bfrPool.diskMgr
+	blockDiskMgr := &blockWriteDiskMgr{DiskFileMgr: diskMgr, blockPageId: dirtyPageId, writing: make(chan struct{}), release: make(chan struct{})}
+	bfrPool.diskMgr = blockDiskMgr
+	newPageDone := make(chan error)
+	go func() {
+		// the dirty page is the only victim
+		_, err := bfrPool.NewPage()
+		newPageDone <- err
+	}()
+	<-blockDiskMgr.writing
+	ckptErr := bfrPool.Checkpoint()
+	close(blockDiskMgr.release)
+	if err := <-newPageDone; err != nil {
+		test.Errorf("new page that evicts the dirty page failed: %v", err)
+	}
+	bfrPool.diskMgr = diskMgr
+	bfrPool.UnpinPage(pinnedPage.PageId)
+	if ckptErr != nil {
+		test.Errorf("checkpoint during a victim write back failed: %v", ckptErr)
+		return
+	}
+
+	logIter := bfrPool.logMgr.GetLogIterator()
+	firstRec, _ := logIter.Next()
+	for logRecord := firstRec; logRecord != nil; logRecord, _ = logIter.Next() {
+		if logRecord.RecType != logmgr.LogCheckpointEnd {
+			continue
+		}
+		ckptData, _ := logRecord.GetCheckpointData()
+		if ckptData.DirtyPages[dirtyPageId] != recLsn {
+			test.Errorf("checkpoint missed the page in its write back: %v", ckptData.DirtyPages)
+		}
+	}
+	if firstRec.Lsn > recLsn {
+		test.Errorf("checkpoint truncated the log of the page in its write back")
+	}
+}
+
+// syncOrderDiskMgr records the page writes, the syncs of the db file and the truncations of the log in the order they happen
+type syncOrderDiskMgr struct {
+	diskmgr.DiskFileMgr
+	calls []string
+}
+
+func (sd *syncOrderDiskMgr) WritePage(pageId int, writeData []byte) error {
+	sd.calls = append(sd.calls, fmt.Sprintf("write %d", pageId))
+	return sd.DiskFileMgr.WritePage(pageId, writeData)
+}
+
+func (sd *syncOrderDiskMgr) WritePageNoSync(pageId int, writeData []byte) error {
+	sd.calls = append(sd.calls, fmt.Sprintf("write %d", pageId))
+	return sd.DiskFileMgr.WritePageNoSync(pageId, writeData)
+}
+
+func (sd *syncOrderDiskMgr) Sync() error {
+	sd.calls = append(sd.calls, "sync")
+	return sd.DiskFileMgr.Sync()
+}
+
+func (sd *syncOrderDiskMgr) DiscardLogPrefix(offset int64) error {
+	sd.calls = append(sd.calls, "truncate")
+	return sd.DiskFileMgr.DiscardLogPrefix(offset)
+}
+
+// the victim write back does not sync under SyncOnFlush, the checkpoint has to sync it before the log of the page goes
+func TestCheckpointSyncsBeforeTruncate(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	d.SyncPolicy = diskmgr.SyncOnFlush
+	orderDiskMgr := &syncOrderDiskMgr{DiskFileMgr: disktest.GetDiskMgr(test, d)}
+	bfrPool := getTestPoolOn(test, orderDiskMgr, Options{PoolFrames: 1})
+	dirtyPage, _ := bfrPool.NewPage()
+	txn, _ := bfrPool.BeginTxn()
+	bfrPool.WritePageData(txn, dirtyPage.PageId, 100, []byte{1})
+	bfrPool.CommitTxn(txn)
+	dirtyPageId := dirtyPage.PageId
+	bfrPool.NewPage()
+	if _, inPool := bfrPool.pageMap[dirtyPageId]; inPool {
+		test.Errorf("dirty page not evicted")
+		return
+	}
+
+	orderDiskMgr.calls = nil
+	if err := bfrPool.Checkpoint(); err != nil {
+		test.Errorf("checkpoint failed: %v", err)
+		return
+	}
+	truncateAt := slices.Index(orderDiskMgr.calls, "truncate")
+	if truncateAt < 0 || !slices.Contains(orderDiskMgr.calls[:truncateAt], "sync") {
+		test.Errorf("checkpoint truncated the log before a sync of the db file: %v", orderDiskMgr.calls)
+	}
+}
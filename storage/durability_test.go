@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rohithputha/HymStMgr/constants"
+	"github.com/rohithputha/HymStMgr/diskmgr"
+	"github.com/rohithputha/HymStMgr/diskmgr/disktest"
+)
+
+// recordingDiskMgr records the pages written and the number of syncs
+type recordingDiskMgr struct {
+	diskmgr.DiskFileMgr
+	writtenPageIds []int
+	numSyncs       int
+	numWriteCalls  int // calls of WritePages
+}
+
+func (rd *recordingDiskMgr) WritePage(pageId int, writeData []byte) error {
+	rd.writtenPageIds = append(rd.writtenPageIds, pageId)
+	rd.numSyncs++
+	return rd.DiskFileMgr.WritePage(pageId, writeData)
+}
+
+func (rd *recordingDiskMgr) WritePageNoSync(pageId int, writeData []byte) error {
+	rd.writtenPageIds = append(rd.writtenPageIds, pageId)
+	return rd.DiskFileMgr.WritePageNoSync(pageId, writeData)
+}
+
+func (rd *recordingDiskMgr) WritePages(pageIds []int, pagesData [][]byte) error {
+	rd.writtenPageIds = append(rd.writtenPageIds, pageIds...)
+	rd.numWriteCalls++
+	return rd.DiskFileMgr.WritePages(pageIds, pagesData)
+}
+
+func (rd *recordingDiskMgr) Sync() error {
+	rd.numSyncs++
+	return rd.DiskFileMgr.Sync()
+}
+
+// fillPage changes the page data outside of a txn, only a flush makes such a change durable
+func fillPage(page *Page, value byte) {
+	for i := constants.PageHeaderSize; i < constants.PageSize; i++ {
+		page.pageData[i] = value
+	}
+	page.IsDirty = true
+}
+
+func pageFilledWith(page *Page, value byte) bool {
+	for i := constants.PageHeaderSize; i < constants.PageSize; i++ {
+		if page.pageData[i] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func TestFlushPageDurable(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	bfrPool := getTestPool(test, d, Options{})
+	firstPage, _ := bfrPool.NewPage()
+	secondPage, _ := bfrPool.NewPage()
+	fillPage(firstPage, 7)
+	fillPage(secondPage, 9)
+	if err := bfrPool.FlushPage(secondPage.PageId); err != nil || secondPage.IsDirty || !firstPage.IsDirty {
+		test.Errorf("flush page does not flush only the requested page")
+	}
+
+	// no close: only what was flushed is on disk
+	reopenedPool := getTestPool(test, d, Options{})
+	page, _ := reopenedPool.FetchPage(secondPage.PageId)
+	if !pageFilledWith(page, 9) {
+		test.Errorf("flushed page not durable across reopen")
+	}
+	page, _ = reopenedPool.FetchPage(firstPage.PageId)
+	if !pageFilledWith(page, 0) {
+		test.Errorf("flush page wrote a page that was not requested")
+	}
+}
+
+func TestFlushPageNotInPool(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
+	if err := bfrPool.FlushPage(42); !errors.Is(err, ErrPageNotInPool) {
+		test.Errorf("flush page of a page not in the pool does not return ErrPageNotInPool")
+	}
+}
+
+func TestFlushAllPagesDurable(test *testing.T) {
+	const numPages = 20
+	d := disktest.GetFileInit(test)
+	bfrPool := getTestPool(test, d, Options{})
+	pageIds := make([]int, numPages)
+	for i := range numPages {
+		newPage, _ := bfrPool.NewPage()
+		pageIds[i] = newPage.PageId
+		fillPage(newPage, byte(i+1))
+		if i == 3 {
+			bfrPool.PinPage(newPage.PageId) // pinned pages are written too
+		}
+	}
+	if err := bfrPool.FlushAllPages(); err != nil {
+		test.Errorf("flush all pages failed: %v", err)
+	}
+	for _, pageIndex := range bfrPool.pageMap {
+		if bfrPool.pagePool[pageIndex].IsDirty {
+			test.Errorf("page %d still dirty after flush all pages", bfrPool.pagePool[pageIndex].PageId)
+		}
+	}
+
+	reopenedPool := getTestPool(test, d, Options{})
+	for i, pageId := range pageIds {
+		page, fetchErr := reopenedPool.FetchPage(pageId)
+		if fetchErr != nil || !pageFilledWith(page, byte(i+1)) {
+			test.Errorf("page %d not durable across reopen", pageId)
+		}
+	}
+}
+
+func TestFlushOutsideBpsMux(test *testing.T) {
+	for _, flushAll := range []bool{false, true} {
+		bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
+		dirtyPage, _ := bfrPool.NewPage()
+		otherPage, _ := bfrPool.NewPage()
+		bfrPool.FlushAllPages()
+		fillPage(dirtyPage, 3)
+
+		diskMgr := bfrPool.diskMgr
+		blockDiskMgr := &blockWriteDiskMgr{DiskFileMgr: diskMgr, blockPageId: dirtyPage.PageId, writing: make(chan struct{}), release: make(chan struct{})}
+		bfrPool.diskMgr = blockDiskMgr
+		flushDone := make(chan error)
+		go func() {
+			if flushAll {
+				flushDone <- bfrPool.FlushAllPages()
+			} else {
+				flushDone <- bfrPool.FlushPage(dirtyPage.PageId)
+			}
+		}()
+		<-blockDiskMgr.writing
+
+		fetchDone := make(chan error)
+		go func() {
+			_, err := bfrPool.FetchPage(otherPage.PageId)
+			fetchDone <- err
+		}()
+		select {
+		case err := <-fetchDone:
+			if err != nil {
+				test.Errorf("fetch during a flush failed: %v", err)
+			}
+			bfrPool.UnpinPage(otherPage.PageId)
+		case <-time.After(5 * time.Second):
+			test.Errorf("fetch waits for the write of a flush (flush all: %v)", flushAll)
+			defer func() { <-fetchDone; bfrPool.UnpinPage(otherPage.PageId) }()
+		}
+		close(blockDiskMgr.release)
+		if err := <-flushDone; err != nil || dirtyPage.IsDirty {
+			test.Errorf("flush failed after the write was released: %v", err)
+		}
+		bfrPool.diskMgr = diskMgr
+	}
+}
+
+func TestFlushAllPagesOrderAndSync(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	diskMgr, _ := diskmgr.GetDiskFileMgr(d)
+	recDiskMgr := &recordingDiskMgr{DiskFileMgr: diskMgr}
+	bfrPool := getTestPoolOn(test, recDiskMgr, Options{PoolFrames: 16})
+	pages := make([]*Page, 0)
+	for range 10 {
+		newPage, _ := bfrPool.NewPage()
+		bfrPool.PinPage(newPage.PageId) // kept pinned so that no page is evicted (and written) before the flush
+		pages = append(pages, newPage)
+	}
+	// dirty the pages in an order unrelated to their ids
+	for _, i := range []int{7, 2, 9, 0, 5, 3} {
+		fillPage(pages[i], byte(i))
+	}
+	recDiskMgr.writtenPageIds, recDiskMgr.numSyncs = nil, 0
+
+	if err := bfrPool.FlushAllPages(); err != nil {
+		test.Errorf("flush all pages failed: %v", err)
+	}
+	expected := []int{pages[0].PageId, pages[2].PageId, pages[3].PageId, pages[5].PageId, pages[7].PageId, pages[9].PageId}
+	if !slices.Equal(recDiskMgr.writtenPageIds, expected) || recDiskMgr.numSyncs != 1 {
+		test.Errorf("flush all pages wrote %v with %d syncs, expected %v with 1 sync", recDiskMgr.writtenPageIds, recDiskMgr.numSyncs, expected)
+	}
+
+	if recDiskMgr.numWriteCalls != 1 {
+		test.Errorf("flush all pages wrote the pages with %d write pages calls, expected 1", recDiskMgr.numWriteCalls)
+	}
+
+	recDiskMgr.writtenPageIds, recDiskMgr.numSyncs = nil, 0
+	bfrPool.FlushAllPages()
+	if len(recDiskMgr.writtenPageIds) != 0 {
+		test.Errorf("flush all pages wrote clean pages")
+	}
+}
+
+func TestFlushAllPagesWithEviction(test *testing.T) {
+	const numPages, poolSize = 12, 4
+	d := disktest.GetFileInit(test)
+	diskMgr, _ := diskmgr.GetDiskFileMgr(d)
+	bfrPool := getTestPoolOn(test, diskMgr, Options{PoolFrames: poolSize})
+	pageIds := make([]int, numPages)
+	for i := range numPages {
+		newPage, _ := bfrPool.NewPage()
+		pageIds[i] = newPage.PageId
+		fillPage(newPage, byte(i+1)) // dirty pages get evicted (and written) by the next NewPage calls
+	}
+	if err := bfrPool.FlushAllPages(); err != nil {
+		test.Errorf("flush all pages failed: %v", err)
+	}
+	diskMgr.Close()
+
+	reopenedPool := getTestPool(test, d, Options{})
+	for i, pageId := range pageIds {
+		page, fetchErr := reopenedPool.FetchPage(pageId)
+		if fetchErr != nil || !pageFilledWith(page, byte(i+1)) {
+			test.Errorf("page %d not durable across reopen", pageId)
+		}
+	}
+}
+
+func TestTxnDurableAfterFlushAllPages(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	bfrPool := getTestPool(test, d, Options{})
+	newPage, _ := bfrPool.NewPage()
+	txn, _ := bfrPool.BeginTxn()
+	bfrPool.WritePageData(txn, newPage.PageId, 200, []byte{1, 2, 3})
+	bfrPool.CommitTxn(txn)
+	bfrPool.FlushAllPages()
+
+	// the page on disk is already up to date, so recovery redoes nothing
+	reopenedPool := getTestPool(test, d, Options{})
+	page, _ := reopenedPool.FetchPage(newPage.PageId)
+	if page.pageData[200] != 1 || page.pageData[202] != 3 || reopenedPool.GetRecoveryReport().RedoneRecords != 0 {
+		test.Errorf("txn change not durable after flush all pages")
+	}
+}
@@ -0,0 +1,24 @@
+package storage
+
+import (
+	"errors"
+
+	"github.com/rohithputha/HymStMgr/diskmgr"
+)
+
+// errors returned by the buffer pool, the disk manager errors (diskmgr.ErrPageNotFound, ...) are passed up wrapped or as is
+var (
+	ErrNoFreeFrame   = errors.New("no page is free on memory")
+	ErrPagePinned    = errors.New("page is pinned")
+	ErrPageNotInPool = errors.New("page is not in the buffer pool")
+	ErrPageLatched   = errors.New("page latch is held by another guard")
+
+	ErrInvalidOptions = errors.New("buffer pool options are not valid")
+
+	ErrTxnNotActive = errors.New("txn is not active")
+	// ErrOutOfRange is returned by WritePageData for a write that is not inside the page data, past the page header
+	ErrOutOfRange = errors.New("write is outside the page data area")
+
+	// ErrClosed is the same error as diskmgr.ErrClosed, so errors.Is works whichever layer noticed the close
+	ErrClosed = diskmgr.ErrClosed
+)
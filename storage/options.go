@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rohithputha/HymStMgr/constants"
+	"github.com/rohithputha/HymStMgr/diskmgr"
+)
+
+// ReplacerType selects the ReplPol of a pool, see the get...ReplPol funcs for how each one evicts
+type ReplacerType int
+
+const (
+	ReplacerLruK ReplacerType = iota
+	ReplacerLru
+	ReplacerClock
+	Replacer2Q
+	ReplacerArc
+)
+
+/*
+Options configures a buffer pool at InitBuffPoolMgr, a zero field takes its default from constants.
+PageSize, SyncPolicy, SyncInterval and PreallocPages are passed on to the disk manager, PageSize has to match the page size the db file was created with.
+*/
+type Options struct {
+	PoolFrames           int
+	PageSize             int
+	Replacer             ReplacerType
+	SyncPolicy           diskmgr.SyncPolicy
+	SyncInterval         time.Duration                // time between the syncs of diskmgr.SyncPeriodic
+	PreallocPages        int                          // pages the db file is grown by at once when new pages run past its end, 0 grows it one page at a time
+	LrukK                int                          // number of references lru-k keeps per page
+	LrukCorrelatedPeriod int64                        // in ticks of the pool's logical clock, every page access and eviction is one tick
+	NewReplPol           func(poolFrames int) ReplPol // a policy of the caller's own, Replacer is ignored when this is set
+	PrefetchWorkers      int                          // goroutines that run the prefetches
+	ReadAheadPages       int                          // pages read ahead once the fetches run sequentially, a negative value turns read-ahead off
+	BgWriterPages        int                          // dirty pages the background writer writes per round, a negative value turns the background writer off
+	BgWriterInterval     time.Duration                // time between two rounds of the background writer
+	BgWriterDirtyRatio   float64                      // share of the frames that has to be dirty before the background writer writes, from 0 to 1
+}
+
+// withDefaults fills in the zero fields and checks the rest
+func (opts Options) withDefaults() (Options, error) {
+	if opts.PoolFrames == 0 {
+		opts.PoolFrames = constants.BufferPoolSize
+	}
+	if opts.PageSize == 0 {
+		opts.PageSize = constants.PageSize
+	}
+	if opts.LrukK == 0 {
+		opts.LrukK = constants.LrukK
+	}
+	if opts.LrukCorrelatedPeriod == 0 {
+		opts.LrukCorrelatedPeriod = constants.LrukCorrelatedPeriod
+	}
+	if opts.PrefetchWorkers == 0 {
+		opts.PrefetchWorkers = constants.PrefetchWorkers
+	}
+	if opts.ReadAheadPages == 0 {
+		opts.ReadAheadPages = min(constants.ReadAheadPages, opts.PoolFrames/8)
+	}
+	if opts.BgWriterPages == 0 {
+		opts.BgWriterPages = min(constants.BgWriterPages, opts.PoolFrames/8)
+	}
+	if opts.BgWriterInterval == 0 {
+		opts.BgWriterInterval = constants.BgWriterInterval
+	}
+	if opts.BgWriterDirtyRatio == 0 {
+		opts.BgWriterDirtyRatio = constants.BgWriterDirtyRatio
+	}
+	if opts.PoolFrames < 0 || opts.LrukK < 0 || opts.LrukCorrelatedPeriod < 0 || opts.PrefetchWorkers < 0 {
+		return opts, fmt.Errorf("%w: pool frames, lru-k k, correlated period and prefetch workers can not be negative", ErrInvalidOptions)
+	}
+	if opts.BgWriterInterval < 0 || opts.BgWriterDirtyRatio < 0 || opts.BgWriterDirtyRatio > 1 {
+		return opts, fmt.Errorf("%w: background writer interval can not be negative, the dirty ratio has to be from 0 to 1", ErrInvalidOptions)
+	}
+	if opts.NewReplPol == nil && (opts.Replacer < ReplacerLruK || opts.Replacer > ReplacerArc) {
+		return opts, fmt.Errorf("%w: unknown replacer %d", ErrInvalidOptions, opts.Replacer)
+	}
+	return opts, nil
+}
+
+// getReplPol makes a new policy for a pool of opts.PoolFrames frames, every pool (and every shard) needs its own
+func (opts Options) getReplPol() ReplPol {
+	if opts.NewReplPol != nil {
+		return opts.NewReplPol(opts.PoolFrames)
+	}
+	switch opts.Replacer {
+	case ReplacerLru:
+		return getLruReplPol()
+	case ReplacerClock:
+		return getClockReplPol()
+	case Replacer2Q:
+		return get2QReplPol(opts.PoolFrames)
+	case ReplacerArc:
+		return getArcReplPol(opts.PoolFrames)
+	default:
+		return getLrukReplPol(opts.LrukK, opts.LrukCorrelatedPeriod)
+	}
+}
@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rohithputha/HymStMgr/constants"
+	"github.com/rohithputha/HymStMgr/diskmgr"
+	"github.com/rohithputha/HymStMgr/diskmgr/disktest"
+)
+
+func TestInitBuffPoolMgrDefaultOptions(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
+	if len(bfrPool.pagePool) != constants.BufferPoolSize || bfrPool.pageSize != constants.PageSize || len(bfrPool.arena) != constants.BufferPoolSize*constants.PageSize {
+		test.Errorf("default options not working as expected")
+	}
+	if bfrPool.readAheadPages != constants.ReadAheadPages {
+		test.Errorf("read-ahead not on by default")
+	}
+	if noReadAheadPool := getTestPool(test, disktest.GetFileInit(test), Options{ReadAheadPages: -1}); noReadAheadPool.readAheadPages != 0 {
+		test.Errorf("negative read-ahead pages do not turn read-ahead off")
+	}
+	if bfrPool.bgWriterPages != constants.BgWriterPages || bfrPool.bgWriterStop == nil {
+		test.Errorf("background writer not on by default")
+	}
+	if smallPool := getTestPool(test, disktest.GetFileInit(test), Options{PoolFrames: 64}); smallPool.bgWriterPages != 8 {
+		test.Errorf("default background writer pages not bounded by the pool size: %d", smallPool.bgWriterPages)
+	}
+}
+
+func TestInitBuffPoolMgrOptions(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	opts := Options{PoolFrames: 16, PageSize: 8192, LrukK: 2, LrukCorrelatedPeriod: 10}
+	bfrPool, initErr := InitBuffPoolMgr(d, opts)
+	if initErr != nil || len(bfrPool.pagePool) != 16 || len(bfrPool.arena) != 16*8192 {
+		test.Errorf("init buffer pool with options not working as expected: %v", initErr)
+		return
+	}
+	// every frame is its own page of the arena
+	for i := range bfrPool.pagePool {
+		if len(bfrPool.pagePool[i].pageData) != 8192 || cap(bfrPool.pagePool[i].pageData) != 8192 || &bfrPool.pagePool[i].pageData[0] != &bfrPool.arena[i*8192] {
+			test.Errorf("frame %d is not backed by the arena", i)
+		}
+	}
+
+	newPage, _ := bfrPool.NewPage()
+	txn, _ := bfrPool.BeginTxn()
+	if err := bfrPool.WritePageData(txn, newPage.PageId, 8000, []byte{4, 2}); err != nil {
+		test.Errorf("write past the default page size failed: %v", err)
+	}
+	bfrPool.CommitTxn(txn)
+	bfrPool.Close()
+
+	reopenedPool := getTestPool(test, d, opts)
+	page, fetchErr := reopenedPool.FetchPage(newPage.PageId)
+	if fetchErr != nil || page.pageData[8000] != 4 || page.pageData[8001] != 2 {
+		test.Errorf("page of a custom page size not durable across reopen")
+	}
+	reopenedPool.Close()
+
+	if _, err := InitBuffPoolMgr(d, Options{}); !errors.Is(err, diskmgr.ErrPageSizeMismatch) {
+		test.Errorf("reopen with another page size does not return ErrPageSizeMismatch")
+	}
+}
+
+func TestInitBuffPoolMgrInvalidOptions(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	if _, err := InitBuffPoolMgr(d, Options{PoolFrames: -1}); !errors.Is(err, ErrInvalidOptions) {
+		test.Errorf("negative pool frames do not return ErrInvalidOptions")
+	}
+	if _, err := InitBuffPoolMgr(d, Options{Replacer: ReplacerType(99)}); !errors.Is(err, ErrInvalidOptions) {
+		test.Errorf("unknown replacer does not return ErrInvalidOptions")
+	}
+	if _, err := InitBuffPoolMgr(d, Options{BgWriterDirtyRatio: 1.5}); !errors.Is(err, ErrInvalidOptions) {
+		test.Errorf("background writer dirty ratio above 1 does not return ErrInvalidOptions")
+	}
+	if _, err := InitBuffPoolMgr(d, Options{BgWriterInterval: -time.Second}); !errors.Is(err, ErrInvalidOptions) {
+		test.Errorf("negative background writer interval does not return ErrInvalidOptions")
+	}
+	if _, err := InitBuffPoolMgr(d, Options{PageSize: 1000}); !errors.Is(err, diskmgr.ErrInvalidPageSize) {
+		test.Errorf("page size that is not a power of two does not return ErrInvalidPageSize")
+	}
+}
+
+func TestInitBuffPoolMgrReplacers(test *testing.T) {
+	for _, replacer := range []ReplacerType{ReplacerLruK, ReplacerLru, ReplacerClock, Replacer2Q, ReplacerArc} {
+		d := disktest.GetFileInit(test)
+		bfrPool, initErr := InitBuffPoolMgr(d, Options{PoolFrames: 4, Replacer: replacer})
+		if initErr != nil {
+			test.Errorf("init buffer pool with replacer %d failed: %v", replacer, initErr)
+			continue
+		}
+		// more pages than frames, so every replacer has to pick victims
+		for i := range 12 {
+			guard, err := bfrPool.NewPageWrite()
+			if err != nil {
+				test.Errorf("replacer %d: new page write failed: %v", replacer, err)
+				break
+			}
+			guard.MutableData()[100] = byte(i + 1)
+			guard.Release()
+		}
+		for pageId := 1; pageId <= 12; pageId++ {
+			guard, err := bfrPool.FetchPageRead(pageId)
+			if err != nil || guard.Data()[100] != byte(pageId) {
+				test.Errorf("replacer %d: page %d not read back as expected: %v", replacer, pageId, err)
+				break
+			}
+			guard.Release()
+		}
+		bfrPool.Close()
+	}
+}
+
+func TestInitBuffPoolMgrNewReplPol(test *testing.T) {
+	poolFrames := 0
+	opts := Options{PoolFrames: 6, Replacer: ReplacerType(99), NewReplPol: func(n int) ReplPol {
+		poolFrames = n
+		return getClockReplPol()
+	}}
+	bfrPool, initErr := InitBuffPoolMgr(disktest.GetFileInit(test), opts)
+	if initErr != nil || poolFrames != 6 {
+		test.Errorf("init buffer pool with new repl pol not working as expected: %v", initErr)
+		return
+	}
+	closeTestPool(test, bfrPool)
+	if _, ok := bfrPool.replPol.(*clock); !ok {
+		test.Errorf("pool does not use the policy made by new repl pol")
+	}
+}
+
+func TestInitBuffPoolMgrSyncPolicies(test *testing.T) {
+	for _, syncPolicy := range []diskmgr.SyncPolicy{diskmgr.SyncEveryWrite, diskmgr.SyncOnFlush, diskmgr.SyncPeriodic, diskmgr.SyncNever} {
+		d := disktest.GetFileInit(test)
+		opts := Options{PoolFrames: 4, SyncPolicy: syncPolicy, SyncInterval: time.Millisecond}
+		bfrPool, initErr := InitBuffPoolMgr(d, opts)
+		if initErr != nil {
+			test.Errorf("init buffer pool with sync policy %d failed: %v", syncPolicy, initErr)
+			continue
+		}
+		for i := range 8 {
+			guard, _ := bfrPool.NewPageWrite()
+			guard.MutableData()[100] = byte(i + 1)
+			guard.Release()
+		}
+		if err := bfrPool.Close(); err != nil {
+			test.Errorf("close with sync policy %d failed: %v", syncPolicy, err)
+		}
+		reopenedPool := getTestPool(test, d, opts)
+		for i := range 8 {
+			if page, err := reopenedPool.FetchPage(i + 1); err != nil || page.pageData[100] != byte(i+1) {
+				test.Errorf("pageId %d written with sync policy %d not there after reopen", i+1, syncPolicy)
+			}
+		}
+		reopenedPool.Close()
+	}
+	if _, err := InitBuffPoolMgr(disktest.GetFileInit(test), Options{SyncPolicy: diskmgr.SyncPolicy(99)}); !errors.Is(err, diskmgr.ErrInvalidSyncPolicy) {
+		test.Errorf("unknown sync policy does not return ErrInvalidSyncPolicy")
+	}
+}
+
+func TestInitBuffPoolMgrPreallocPages(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	opts := Options{PoolFrames: 4, PreallocPages: 16}
+	bfrPool := getTestPool(test, d, opts)
+	for i := range 8 {
+		guard, _ := bfrPool.NewPageWrite()
+		guard.MutableData()[100] = byte(i + 1)
+		guard.Release()
+	}
+	if dbFileInfo, _ := os.Stat(d.DbFilePath); bfrPool.diskMgr.GetPageCount() != 9 || dbFileInfo.Size() != int64(17*bfrPool.pageSize) {
+		test.Errorf("new pages did not grow the db file by the preallocated pages")
+	}
+	bfrPool.Close()
+	reopenedPool := getTestPool(test, d, opts)
+	if page, err := reopenedPool.NewPage(); err != nil || page.PageId != 9 {
+		test.Errorf("new page after a reopen did not follow the written pages")
+	}
+	reopenedPool.Close()
+	if _, err := InitBuffPoolMgr(disktest.GetFileInit(test), Options{PreallocPages: -1}); !errors.Is(err, diskmgr.ErrInvalidPreallocPages) {
+		test.Errorf("negative preallocated pages not passed on to the disk manager")
+	}
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+)
+
+/*
+page guards pin a page and hold its latch until Release, so that callers do not have to pair Fetch with Unpin by hand.
+a guard is not safe to share between goroutines, and the goroutine holding a guard should not change the same page
+through WritePageData or take a second guard on it, the page latch is not reentrant.
+*/
+
+// ReadPageGuard gives read access to a pinned and latched page
+type ReadPageGuard struct {
+	bp       *BuffPoolMgrStr
+	page     *Page
+	released bool
+}
+
+// WritePageGuard gives write access to a pinned and latched page, the page is marked dirty once MutableData is called
+type WritePageGuard struct {
+	bp       *BuffPoolMgrStr
+	page     *Page
+	released bool
+}
+
+// FetchPageRead fetches the page, pins it and latches it shared, so that many readers can hold the page at once
+func (bp *BuffPoolMgrStr) FetchPageRead(pageId int) (guard *ReadPageGuard, fetchErr error) {
+	page, fetchErr := bp.fetchLatchedPage(pageId, nil, readLatch, (*sync.RWMutex).RUnlock)
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+	return &ReadPageGuard{bp: bp, page: page}, nil
+}
+
+// FetchPageWrite fetches the page, pins it and latches it exclusive
+func (bp *BuffPoolMgrStr) FetchPageWrite(pageId int) (guard *WritePageGuard, fetchErr error) {
+	page, fetchErr := bp.fetchLatchedPage(pageId, nil, writeLatch, (*sync.RWMutex).Unlock)
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+	return &WritePageGuard{bp: bp, page: page}, nil
+}
+
+/*
+TryFetchPageRead is FetchPageRead that does not wait for the latch, it returns ErrPageLatched if a writer holds the page.
+latch crabbing code uses the try variants to back off instead of waiting on a child page while it holds the parent.
+*/
+func (bp *BuffPoolMgrStr) TryFetchPageRead(pageId int) (guard *ReadPageGuard, fetchErr error) {
+	page, fetchErr := bp.fetchLatchedPage(pageId, nil, (*sync.RWMutex).TryRLock, (*sync.RWMutex).RUnlock)
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+	return &ReadPageGuard{bp: bp, page: page}, nil
+}
+
+// TryFetchPageWrite is FetchPageWrite that does not wait for the latch, it returns ErrPageLatched if the page is held by anyone
+func (bp *BuffPoolMgrStr) TryFetchPageWrite(pageId int) (guard *WritePageGuard, fetchErr error) {
+	page, fetchErr := bp.fetchLatchedPage(pageId, nil, (*sync.RWMutex).TryLock, (*sync.RWMutex).Unlock)
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+	return &WritePageGuard{bp: bp, page: page}, nil
+}
+
+// NewPageWrite is NewPage that returns the new page pinned and latched exclusive
+func (bp *BuffPoolMgrStr) NewPageWrite() (guard *WritePageGuard, newPageErr error) {
+	page, newPageErr := bp.newPage(true, nil)
+	if newPageErr != nil {
+		return nil, newPageErr
+	}
+	return bp.newWriteGuard(page), nil
+}
+
+// newWriteGuard latches the pinned page exclusive and wraps it in a guard
+func (bp *BuffPoolMgrStr) newWriteGuard(page *Page) *WritePageGuard {
+	page.pageMux.Lock()
+	bp.activeGuards.Add(1)
+	return &WritePageGuard{bp: bp, page: page}
+}
+
+// the latch funcs return false only when a try latch could not be taken
+func readLatch(latch *sync.RWMutex) bool {
+	latch.RLock()
+	return true
+}
+
+func writeLatch(latch *sync.RWMutex) bool {
+	latch.Lock()
+	return true
+}
+
+/*
+fetchLatchedPage pins the page and then takes its latch with latchFn.
+a fetch of the same page that missed can fail while we wait for the latch, the frame is checked again once the latch is held.
+*/
+func (bp *BuffPoolMgrStr) fetchLatchedPage(pageId int, strategy *AccessStrategy, latchFn func(*sync.RWMutex) bool, unlatchFn func(*sync.RWMutex)) (page *Page, fetchErr error) {
+	page, fetchErr = bp.fetchPage(pageId, true, strategy)
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+	if !latchFn(page.pageMux) {
+		bp.UnpinPage(pageId)
+		return nil, fmt.Errorf("%w for pageId: %d", ErrPageLatched, pageId)
+	}
+	if page.IsCorrupted || page.PageId != pageId {
+		unlatchFn(page.pageMux)
+		bp.UnpinPage(pageId)
+		return nil, fmt.Errorf("%w: load of pageId %d failed", ErrPageNotInPool, pageId)
+	}
+	bp.activeGuards.Add(1)
+	return page, nil
+}
+
+// ActiveGuards returns the number of guards that are not released yet, tests use it to find leaked pins
+func (bp *BuffPoolMgrStr) ActiveGuards() int {
+	return int(bp.activeGuards.Load())
+}
+
+func (rg *ReadPageGuard) PageId() int {
+	return rg.page.PageId
+}
+
+// Data returns the page data, it is only valid until Release and should not be changed
+func (rg *ReadPageGuard) Data() []byte {
+	if rg.released {
+		return nil
+	}
+	return rg.page.pageData
+}
+
+// Release unlatches and unpins the page, calling it more than once does nothing
+func (rg *ReadPageGuard) Release() {
+	if rg.released {
+		return
+	}
+	rg.released = true
+	rg.page.pageMux.RUnlock()
+	rg.bp.releaseGuard(rg.page)
+}
+
+func (wg *WritePageGuard) PageId() int {
+	return wg.page.PageId
+}
+
+// Data returns the page data without marking the page dirty, it is only valid until Release
+func (wg *WritePageGuard) Data() []byte {
+	if wg.released {
+		return nil
+	}
+	return wg.page.pageData
+}
+
+/*
+MutableData marks the page dirty and returns the page data, it is only valid until Release.
+changes made through it are not logged, so they are not undone on abort nor redone by recovery, txns should use WritePageData.
+the page header (the first constants.PageHeaderSize bytes) belongs to the pool and should not be changed.
+*/
+func (wg *WritePageGuard) MutableData() []byte {
+	if wg.released {
+		return nil
+	}
+	wg.page.IsDirty = true
+	wg.page.changeCount++
+	return wg.page.pageData
+}
+
+// Release unlatches and unpins the page, calling it more than once does nothing
+func (wg *WritePageGuard) Release() {
+	if wg.released {
+		return
+	}
+	wg.released = true
+	wg.page.pageMux.Unlock()
+	wg.bp.releaseGuard(wg.page)
+}
+
+// releaseGuard unpins the page of a guard whose latch was just released
+func (bp *BuffPoolMgrStr) releaseGuard(page *Page) {
+	// the page is pinned till here, so the frame still holds the page
+	bp.UnpinPage(page.PageId)
+	bp.activeGuards.Add(-1)
+}
@@ -0,0 +1,298 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rohithputha/HymStMgr/diskmgr"
+	"github.com/rohithputha/HymStMgr/diskmgr/disktest"
+)
+
+// checkNoLeakedGuards fails the test if a guard or a pin is still held when it ends, once the read-ahead of the test is done
+func checkNoLeakedGuards(test *testing.T, bp *BuffPoolMgrStr) {
+	test.Cleanup(func() {
+		bp.stopPrefetch()
+		bp.bpsMux.Lock()
+		pinnedPages := bp.pinSet.GetSize()
+		bp.bpsMux.Unlock()
+		if n := bp.ActiveGuards(); n != 0 || pinnedPages != 0 {
+			test.Errorf("%d page guards and %d pinned pages leaked", n, pinnedPages)
+		}
+	})
+}
+
+func TestFetchPageRead(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
+	checkNoLeakedGuards(test, bfrPool)
+	newPage, _ := bfrPool.NewPage()
+	fillPage(newPage, 7)
+
+	guard, err := bfrPool.FetchPageRead(newPage.PageId)
+	if err != nil || guard.PageId() != newPage.PageId || guard.Data()[100] != 7 {
+		test.Errorf("fetch page read not working as expected")
+		return
+	}
+	if newPage.Pin != 1 || bfrPool.ActiveGuards() != 1 || newPage.pageMux.TryLock() {
+		test.Errorf("read guard does not pin and latch the page")
+	}
+	guard.Release()
+	guard.Release()
+	if newPage.Pin != 0 || bfrPool.ActiveGuards() != 0 || guard.Data() != nil {
+		test.Errorf("read guard release not working as expected")
+	}
+	if !newPage.pageMux.TryLock() {
+		test.Errorf("read guard release does not unlatch the page")
+	}
+	newPage.pageMux.Unlock()
+}
+
+func TestFetchPageWrite(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	bfrPool := getTestPool(test, d, Options{})
+	checkNoLeakedGuards(test, bfrPool)
+	newPage, _ := bfrPool.NewPage()
+	bfrPool.FlushPage(newPage.PageId)
+
+	guard, err := bfrPool.FetchPageWrite(newPage.PageId)
+	if err != nil {
+		test.Errorf("fetch page write failed: %v", err)
+		return
+	}
+	if guard.Data(); newPage.IsDirty {
+		test.Errorf("write guard marks the page dirty before it is changed")
+	}
+	guard.MutableData()[100] = 9
+	guard.Release()
+	if !newPage.IsDirty || guard.MutableData() != nil {
+		test.Errorf("write guard does not mark the page dirty")
+	}
+
+	bfrPool.FlushPage(newPage.PageId)
+	readData := make([]byte, bfrPool.pageSize)
+	if bfrPool.diskMgr.ReadPage(newPage.PageId, readData) != nil || readData[100] != 9 {
+		test.Errorf("change through the write guard not written back")
+	}
+}
+
+func TestNewPageWrite(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
+	checkNoLeakedGuards(test, bfrPool)
+	guard, err := bfrPool.NewPageWrite()
+	if err != nil || guard.PageId() != 1 || bfrPool.pagePool[bfrPool.pageMap[1]].Pin != 1 {
+		test.Errorf("new page write not working as expected")
+		return
+	}
+	guard.MutableData()[200] = 3
+	guard.Release()
+
+	readGuard, _ := bfrPool.FetchPageRead(1)
+	if readGuard.Data()[200] != 3 {
+		test.Errorf("new page write change not visible to a read guard")
+	}
+	readGuard.Release()
+}
+
+func TestPageGuardErrors(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
+	checkNoLeakedGuards(test, bfrPool)
+	if guard, err := bfrPool.FetchPageRead(42); guard != nil || !errors.Is(err, diskmgr.ErrPageNotFound) {
+		test.Errorf("fetch page read of a missing page not working as expected")
+	}
+	// a failed load does not stay cached or latched in the frame
+	if guard, err := bfrPool.FetchPageWrite(42); guard != nil || !errors.Is(err, diskmgr.ErrPageNotFound) {
+		test.Errorf("fetch page write of a missing page not working as expected")
+	}
+	if _, ok := bfrPool.pageMap[42]; ok {
+		test.Errorf("frame of a page that failed to load is not given back")
+	}
+	for i := range bfrPool.pagePool {
+		if !bfrPool.pagePool[i].pageMux.TryLock() {
+			test.Errorf("latch of frame %d is leaked", i)
+			continue
+		}
+		bfrPool.pagePool[i].pageMux.Unlock()
+	}
+}
+
+func TestTryFetchPage(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
+	checkNoLeakedGuards(test, bfrPool)
+	writeGuard, _ := bfrPool.NewPageWrite()
+	pageId := writeGuard.PageId()
+
+	if guard, err := bfrPool.TryFetchPageRead(pageId); guard != nil || !errors.Is(err, ErrPageLatched) {
+		test.Errorf("try fetch page read of a write latched page does not return ErrPageLatched")
+	}
+	if guard, err := bfrPool.TryFetchPageWrite(pageId); guard != nil || !errors.Is(err, ErrPageLatched) {
+		test.Errorf("try fetch page write of a write latched page does not return ErrPageLatched")
+	}
+	if bfrPool.pagePool[bfrPool.pageMap[pageId]].Pin != 1 {
+		test.Errorf("failed try fetch does not unpin the page")
+	}
+	writeGuard.Release()
+
+	readGuard, err := bfrPool.TryFetchPageRead(pageId)
+	if err != nil {
+		test.Errorf("try fetch page read of a free page failed: %v", err)
+		return
+	}
+	otherReadGuard, err := bfrPool.TryFetchPageRead(pageId)
+	if err != nil {
+		test.Errorf("try fetch page read of a read latched page failed: %v", err)
+		return
+	}
+	if guard, err := bfrPool.TryFetchPageWrite(pageId); guard != nil || !errors.Is(err, ErrPageLatched) {
+		test.Errorf("try fetch page write of a read latched page does not return ErrPageLatched")
+	}
+	readGuard.Release()
+	otherReadGuard.Release()
+
+	writeGuard, err = bfrPool.TryFetchPageWrite(pageId)
+	if err != nil {
+		test.Errorf("try fetch page write of a free page failed: %v", err)
+		return
+	}
+	writeGuard.Release()
+}
+
+func TestPageGuardSharedReaders(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
+	checkNoLeakedGuards(test, bfrPool)
+	newPage, _ := bfrPool.NewPage()
+	pageId := newPage.PageId
+
+	// every reader holds its guard till all of them have it, this only finishes if the readers share the latch
+	const numReaders = 100
+	var acquired, released sync.WaitGroup
+	acquired.Add(numReaders)
+	released.Add(numReaders)
+	for range numReaders {
+		go func() {
+			defer released.Done()
+			guard, err := bfrPool.FetchPageRead(pageId)
+			acquired.Done()
+			if err != nil {
+				test.Errorf("fetch page read failed: %v", err)
+				return
+			}
+			acquired.Wait()
+			guard.Release()
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		released.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		test.Fatalf("read guards do not share the page latch")
+	}
+}
+
+/*
+TestPageGuardHammer runs hundreds of goroutines over a few hot pages in a pool smaller than the pages, so that pages are evicted
+and loaded back while they are latched by others. every writer bumps two counters on the page and every reader checks they are equal.
+run it with -race.
+*/
+func TestPageGuardHammer(test *testing.T) {
+	const numPages, numWorkers, numRounds = 12, 200, 40
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{PoolFrames: 8})
+	checkNoLeakedGuards(test, bfrPool)
+	pageIds := make([]int, numPages)
+	for i := range pageIds {
+		guard, _ := bfrPool.NewPageWrite()
+		pageIds[i] = guard.PageId()
+		guard.Release()
+	}
+
+	var writes atomic.Int64
+	var wg sync.WaitGroup
+	for w := range numWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range numRounds {
+				pageId := pageIds[(w+r)%numPages]
+				if (w+r)%4 == 0 {
+					guard, err := bfrPool.FetchPageWrite(pageId)
+					if errors.Is(err, ErrNoFreeFrame) {
+						continue
+					}
+					if err != nil {
+						test.Errorf("fetch page write failed: %v", err)
+						return
+					}
+					data := guard.MutableData()
+					binary.LittleEndian.PutUint64(data[100:], binary.LittleEndian.Uint64(data[100:])+1)
+					binary.LittleEndian.PutUint64(data[200:], binary.LittleEndian.Uint64(data[200:])+1)
+					writes.Add(1)
+					guard.Release()
+				} else {
+					guard, err := bfrPool.FetchPageRead(pageId)
+					if errors.Is(err, ErrNoFreeFrame) {
+						continue
+					}
+					if err != nil {
+						test.Errorf("fetch page read failed: %v", err)
+						return
+					}
+					if data := guard.Data(); binary.LittleEndian.Uint64(data[100:]) != binary.LittleEndian.Uint64(data[200:]) {
+						test.Errorf("reader saw a half done write on pageId %d", pageId)
+					}
+					guard.Release()
+				}
+				if r%10 == 0 {
+					if err := bfrPool.FlushAllPages(); err != nil {
+						test.Errorf("flush all pages failed: %v", err)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	total := int64(0)
+	for _, pageId := range pageIds {
+		guard, _ := bfrPool.FetchPageRead(pageId)
+		total += int64(binary.LittleEndian.Uint64(guard.Data()[100:]))
+		guard.Release()
+	}
+	if total != writes.Load() {
+		test.Errorf("%d writes made but %d found on the pages", writes.Load(), total)
+	}
+}
+
+func TestPageGuardBlocksClose(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
+	guard, _ := bfrPool.NewPageWrite()
+	if err := bfrPool.Close(); !errors.Is(err, ErrPagePinned) {
+		test.Errorf("close with a page guard held does not return ErrPagePinned")
+	}
+	guard.Release()
+	if err := bfrPool.Close(); err != nil {
+		test.Errorf("close after the guard release failed: %v", err)
+	}
+}
+
+func TestFlushAllPagesSkipsLatchedPage(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
+	checkNoLeakedGuards(test, bfrPool)
+	guard, _ := bfrPool.NewPageWrite()
+	otherPage, _ := bfrPool.NewPage()
+	guard.MutableData()[100] = 1
+	otherPage.IsDirty = true
+
+	if err := bfrPool.FlushAllPages(); err != nil {
+		test.Errorf("flush all pages failed: %v", err)
+	}
+	if !bfrPool.pagePool[bfrPool.pageMap[guard.PageId()]].IsDirty || otherPage.IsDirty {
+		test.Errorf("flush all pages with a latched page not working as expected")
+	}
+	guard.Release()
+}
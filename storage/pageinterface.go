@@ -1,25 +1,59 @@
 package storage
 
 import (
+	"encoding/binary"
 	"sync"
+	"sync/atomic"
 
 	"github.com/rohithputha/HymStMgr/constants"
 )
 
 type Page struct {
 	PageId      int
-	pageData    [constants.PageSize]byte // this will be a copy of page data
+	PageLSN     int64        // lsn of the latest log record that changed this page
+	recLSN      atomic.Int64 // lsn of the first log record that made the page dirty since it was last written out, a checkpoint reads it without the page latch
+	pageData    []byte       // this will be a copy of page data, a slice of the pool's arena
+	changeCount int64        // counts the changes to the page data, lets a flush tell if the page changed after it was written
 	Pin         int
 	IsDirty     bool
 	IsFlushed   bool
 	IsCorrupted bool
 	IsOccupied  bool
-	pageMux     *sync.Mutex
+	pageMux     *sync.RWMutex // shared for reads of the page data, exclusive for changes
+	ring        *accessRing   // the access strategy ring the page was loaded through, nil for the pages of the pool
+	prefetched  bool          // read in by a prefetch and not fetched since
 }
 
 func (ps *Page) NewPage() {
-	for i := range ps.pageData {
-		ps.pageData[i] = 0
-	}
+	clear(ps.pageData)
 	ps.pageData[0] = 1
+	ps.PageLSN = 0
+	ps.recLSN.Store(0)
+	ps.IsCorrupted = false
+}
+
+// setLSN updates the lsn both on the struct and in the page header, so that it reaches the disk with the page
+func (ps *Page) setLSN(lsn int64) {
+	ps.PageLSN = lsn
+	binary.LittleEndian.PutUint64(ps.pageData[constants.PageLSNOffset:], uint64(lsn))
+}
+
+func (ps *Page) loadLSN() {
+	ps.PageLSN = int64(binary.LittleEndian.Uint64(ps.pageData[constants.PageLSNOffset:]))
+}
+
+/*
+beginDirty is called before the log record of a change is appended, nextLsn is the lsn the record will at least get.
+the recLsn is then never after the record, so a checkpoint taken between the append and the change still covers the page.
+*/
+func (ps *Page) beginDirty(nextLsn int64) {
+	ps.recLSN.CompareAndSwap(0, nextLsn)
+}
+
+// markDirty records a logged change at lsn on the page
+func (ps *Page) markDirty(lsn int64) {
+	ps.beginDirty(lsn)
+	ps.IsDirty = true
+	ps.changeCount++
+	ps.setLSN(lsn)
 }
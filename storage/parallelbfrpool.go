@@ -0,0 +1,309 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rohithputha/HymStMgr/diskmgr"
+	"github.com/rohithputha/HymStMgr/logmgr"
+)
+
+/*
+ParallelBufferPool splits the frames over independent BuffPoolMgrStr shards, the page pageId lives in shard pageId % len(shards)
+unless shardOf says otherwise. every shard has its own bpsMux, page map and replacer, the disk and log managers are shared.
+NewPage creates the pages round robin across the shards, a page that does not land in its pageId % len(shards) shard
+(e.g. a pageId reused from the free list) is put in shardOf until it is deleted. the pool is reopened without shardOf,
+nothing is cached then.
+txns and checkpoints are not routed across shards, they run on a single BuffPoolMgrStr.
+*/
+type ParallelBufferPool struct {
+	shards    []*BuffPoolMgrStr
+	shardOf   sync.Map // pageId -> shard index, changed under allocMux
+	nextShard atomic.Int64
+	diskMgr   diskmgr.DiskFileMgr
+	logMgr    logmgr.LogMgr
+	allocMux  *sync.Mutex // shared by all the shards, the free list and the end of the file only change under it
+	recReport RecoveryReport
+}
+
+/*
+InitParallelBuffPool opens the db and log files, runs crash recovery once and splits opts.PoolFrames over numShards shards.
+recovery runs on a pool of its own that is flushed and dropped before the shards are built, so no page is cached outside its shard.
+*/
+func InitParallelBuffPool(dikFileInit diskmgr.DiskFileInit, opts Options, numShards int) (parallelPool *ParallelBufferPool, initErr error) {
+	if opts, initErr = opts.withDefaults(); initErr != nil {
+		return nil, initErr
+	}
+	if numShards < 1 || numShards > opts.PoolFrames {
+		return nil, fmt.Errorf("%w: %d shards for %d pool frames", ErrInvalidOptions, numShards, opts.PoolFrames)
+	}
+	dikFileInit.PageSize = opts.PageSize
+	dikFileInit.SyncPolicy = opts.SyncPolicy
+	dikFileInit.SyncInterval = opts.SyncInterval
+	dikFileInit.PreallocPages = opts.PreallocPages
+	diskMgr, initErr := diskmgr.GetDiskFileMgr(dikFileInit)
+	if initErr != nil {
+		return nil, initErr
+	}
+	logMgr, initErr := logmgr.GetLogMgr(diskMgr)
+	if initErr != nil {
+		return nil, errors.Join(initErr, diskMgr.Close())
+	}
+
+	shardOpts := opts
+	shardOpts.PoolFrames = opts.PoolFrames / numShards
+	recPool := newBuffPool(diskMgr, logMgr, shardOpts)
+	if initErr = recPool.recover(); initErr == nil {
+		initErr = recPool.FlushAllPages()
+	}
+	recPool.stopPrefetch()
+	if initErr != nil {
+		return nil, errors.Join(initErr, diskMgr.Close())
+	}
+
+	parallelPool = &ParallelBufferPool{
+		shards:    make([]*BuffPoolMgrStr, numShards),
+		diskMgr:   diskMgr,
+		logMgr:    logMgr,
+		allocMux:  &sync.Mutex{},
+		recReport: recPool.GetRecoveryReport(),
+	}
+	// the fresh pageIds of the file are sequential, starting at its page count keeps them in their pageId % numShards shard
+	parallelPool.nextShard.Store(int64(diskMgr.GetPageCount()))
+	for i := range numShards {
+		shardOpts.PoolFrames = opts.PoolFrames / numShards
+		if i < opts.PoolFrames%numShards {
+			shardOpts.PoolFrames++
+		}
+		parallelPool.shards[i] = newBuffPool(diskMgr, logMgr, shardOpts)
+		parallelPool.shards[i].allocMux = parallelPool.allocMux
+		parallelPool.shards[i].readAheadStride = numShards
+		parallelPool.shards[i].ownsPage = func(pageId int) bool { return parallelPool.getShardIndex(pageId) == i }
+		parallelPool.shards[i].startBgWriter()
+	}
+	return parallelPool, nil
+}
+
+func (pp *ParallelBufferPool) getShardIndex(pageId int) int {
+	if shardIndex, ok := pp.shardOf.Load(pageId); ok {
+		return shardIndex.(int)
+	}
+	return pageId % len(pp.shards)
+}
+
+func (pp *ParallelBufferPool) getShard(pageId int) *BuffPoolMgrStr {
+	return pp.shards[pp.getShardIndex(pageId)]
+}
+
+func (pp *ParallelBufferPool) GetRecoveryReport() RecoveryReport {
+	return pp.recReport
+}
+
+func (pp *ParallelBufferPool) FetchPage(pageId int) (page *Page, readErr error) {
+	return pp.getShard(pageId).FetchPage(pageId)
+}
+
+func (pp *ParallelBufferPool) FetchPageRead(pageId int) (guard *ReadPageGuard, fetchErr error) {
+	return pp.getShard(pageId).FetchPageRead(pageId)
+}
+
+func (pp *ParallelBufferPool) FetchPageWrite(pageId int) (guard *WritePageGuard, fetchErr error) {
+	return pp.getShard(pageId).FetchPageWrite(pageId)
+}
+
+func (pp *ParallelBufferPool) NewPage() (page *Page, newPageErr error) {
+	_, page, newPageErr = pp.newPage(false, nil)
+	return page, newPageErr
+}
+
+func (pp *ParallelBufferPool) NewPageWrite() (guard *WritePageGuard, newPageErr error) {
+	shard, page, newPageErr := pp.newPage(true, nil)
+	if newPageErr != nil {
+		return nil, newPageErr
+	}
+	return shard.newWriteGuard(page), nil
+}
+
+/*
+newPage takes the next shard in turn and reserves a frame there before a pageId is taken, so a shard with no free frame
+does not take a page off the free list. the shard does not depend on the pageId, so the frame is reserved (which can write back
+a victim) without allocMux and only the allocation holds it. a reused pageId can still be cached in the shard it lived in before,
+that stale frame is dropped before the page is created in its new shard.
+*/
+func (pp *ParallelBufferPool) newPage(pin bool, strategy *AccessStrategy) (shard *BuffPoolMgrStr, page *Page, newPageErr error) {
+	shardIndex := int((pp.nextShard.Add(1) - 1) % int64(len(pp.shards)))
+	shard = pp.shards[shardIndex]
+	sPage, sPageIndex, newPageErr := shard.reserveFrame(strategy)
+	if newPageErr != nil {
+		return nil, nil, newPageErr
+	}
+	startLsn, newPageErr := shard.flushLogForNewPage()
+	newPageId, reused := -1, false
+	if newPageErr == nil {
+		pp.allocMux.Lock()
+		if newPageId, reused, newPageErr = shard.allocatePageId(); newPageErr == nil {
+			newPageErr = pp.movePage(newPageId, shardIndex)
+		}
+		pp.allocMux.Unlock()
+	}
+	if newPageErr != nil {
+		shard.bpsMux.Lock()
+		shard.releaseFrame(sPageIndex)
+		shard.bpsMux.Unlock()
+		return nil, nil, newPageErr
+	}
+	page, newPageErr = shard.installNewPage(newPageId, reused, sPage, sPageIndex, startLsn, pin)
+	return shard, page, newPageErr
+}
+
+// movePage drops a stale frame of the new pageId from the shard it lived in before and routes it to shardIndex, allocMux should be held
+func (pp *ParallelBufferPool) movePage(newPageId int, shardIndex int) (moveErr error) {
+	if prevShard := pp.getShard(newPageId); prevShard != pp.shards[shardIndex] {
+		prevShard.bpsMux.Lock()
+		moveErr = prevShard.dropStaleFrame(newPageId)
+		prevShard.bpsMux.Unlock()
+		if moveErr != nil {
+			return moveErr
+		}
+	}
+	if shardIndex == newPageId%len(pp.shards) {
+		pp.shardOf.Delete(newPageId)
+	} else {
+		pp.shardOf.Store(newPageId, shardIndex)
+	}
+	return nil
+}
+
+func (pp *ParallelBufferPool) FetchPageWithStrategy(pageId int, strategy *AccessStrategy) (page *Page, readErr error) {
+	return pp.getShard(pageId).FetchPageWithStrategy(pageId, strategy)
+}
+
+func (pp *ParallelBufferPool) FetchPageReadWithStrategy(pageId int, strategy *AccessStrategy) (guard *ReadPageGuard, fetchErr error) {
+	return pp.getShard(pageId).FetchPageReadWithStrategy(pageId, strategy)
+}
+
+func (pp *ParallelBufferPool) FetchPageWriteWithStrategy(pageId int, strategy *AccessStrategy) (guard *WritePageGuard, fetchErr error) {
+	return pp.getShard(pageId).FetchPageWriteWithStrategy(pageId, strategy)
+}
+
+func (pp *ParallelBufferPool) NewPageWriteWithStrategy(strategy *AccessStrategy) (guard *WritePageGuard, newPageErr error) {
+	shard, page, newPageErr := pp.newPage(true, strategy)
+	if newPageErr != nil {
+		return nil, newPageErr
+	}
+	return shard.newWriteGuard(page), nil
+}
+
+// PrefetchPages queues every page in the shard it lives in
+func (pp *ParallelBufferPool) PrefetchPages(pageIds []int) (prefetchErr error) {
+	shardPageIds := make([][]int, len(pp.shards))
+	for _, pageId := range pageIds {
+		shardIndex := pp.getShardIndex(pageId)
+		shardPageIds[shardIndex] = append(shardPageIds[shardIndex], pageId)
+	}
+	for i, shard := range pp.shards {
+		if prefetchErr = shard.PrefetchPages(shardPageIds[i]); prefetchErr != nil {
+			return prefetchErr
+		}
+	}
+	return nil
+}
+
+// GetStats adds up the stats of the shards
+func (pp *ParallelBufferPool) GetStats() (stats PoolStats) {
+	for _, shard := range pp.shards {
+		shardStats := shard.GetStats()
+		stats.Fetches += shardStats.Fetches
+		stats.FetchHits += shardStats.FetchHits
+		stats.Prefetches += shardStats.Prefetches
+		stats.PrefetchHits += shardStats.PrefetchHits
+		stats.PrefetchUnused += shardStats.PrefetchUnused
+		stats.VictimWrites += shardStats.VictimWrites
+		stats.BgWrites += shardStats.BgWrites
+	}
+	return stats
+}
+
+// DeletePage deletes the page in its shard, the pageId then goes back to the shard pageId % len(shards) until it is reused
+func (pp *ParallelBufferPool) DeletePage(pageId int) (deleteErr error) {
+	pp.allocMux.Lock()
+	defer pp.allocMux.Unlock()
+	if deleteErr = pp.getShard(pageId).deletePage(pageId); deleteErr != nil {
+		return deleteErr
+	}
+	pp.shardOf.Delete(pageId)
+	return nil
+}
+
+func (pp *ParallelBufferPool) PinPage(pageId int) {
+	pp.getShard(pageId).PinPage(pageId)
+}
+
+func (pp *ParallelBufferPool) UnpinPage(pageId int) bool {
+	return pp.getShard(pageId).UnpinPage(pageId)
+}
+
+func (pp *ParallelBufferPool) FlushPage(pageId int) (flushErr error) {
+	return pp.getShard(pageId).FlushPage(pageId)
+}
+
+// FlushAllPages flushes the shards one after the other, every shard syncs the db file once
+func (pp *ParallelBufferPool) FlushAllPages() (flushErr error) {
+	for _, shard := range pp.shards {
+		if flushErr = shard.FlushAllPages(); flushErr != nil {
+			return flushErr
+		}
+	}
+	return nil
+}
+
+func (pp *ParallelBufferPool) ActiveGuards() int {
+	activeGuards := 0
+	for _, shard := range pp.shards {
+		activeGuards += shard.ActiveGuards()
+	}
+	return activeGuards
+}
+
+/*
+Close is Close of BuffPoolMgrStr for all the shards at once: it fails with ErrPagePinned while a page is pinned in any shard,
+else it flushes the log and every shard, and closes the files once.
+*/
+func (pp *ParallelBufferPool) Close() (closeErr error) {
+	for _, shard := range pp.shards {
+		// see Close of BuffPoolMgrStr, the prefetch workers are stopped before bpsMux is taken and after it is released
+		shard.stopPrefetch()
+		defer shard.stopPrefetch()
+		shard.stopBgWriter()
+		defer shard.startBgWriter()
+	}
+	for _, shard := range pp.shards {
+		shard.bpsMux.Lock()
+		defer shard.bpsMux.Unlock()
+	}
+
+	if pp.shards[0].closed.Load() {
+		return ErrClosed
+	}
+	pinnedPages := 0
+	for _, shard := range pp.shards {
+		pinnedPages += shard.pinSet.GetSize()
+	}
+	if pinnedPages > 0 {
+		return fmt.Errorf("%w: %d pages are still pinned", ErrPagePinned, pinnedPages)
+	}
+	if closeErr = pp.logMgr.Flush(pp.logMgr.GetLastLsn()); closeErr != nil {
+		return closeErr
+	}
+	for _, shard := range pp.shards {
+		if closeErr = shard.flushAllPages(); closeErr != nil {
+			return closeErr
+		}
+	}
+	for _, shard := range pp.shards {
+		shard.closed.Store(true)
+	}
+	return pp.diskMgr.Close()
+}
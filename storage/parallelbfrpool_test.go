@@ -0,0 +1,337 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rohithputha/HymStMgr/diskmgr"
+	"github.com/rohithputha/HymStMgr/diskmgr/disktest"
+)
+
+func TestInitParallelBuffPool(test *testing.T) {
+	parallelPool, err := InitParallelBuffPool(disktest.GetFileInit(test), Options{PoolFrames: 10}, 4)
+	if err != nil || len(parallelPool.shards) != 4 {
+		test.Errorf("init parallel buffer pool not working as expected: %v", err)
+		return
+	}
+	closeTestParallelPool(test, parallelPool)
+	numFrames := 0
+	for i, shard := range parallelPool.shards {
+		numFrames += len(shard.pagePool)
+		if shard.allocMux != parallelPool.allocMux || (i > 0 && shard.diskMgr != parallelPool.shards[0].diskMgr) {
+			test.Errorf("shard %d does not share the disk manager and alloc lock", i)
+		}
+	}
+	if numFrames != 10 || len(parallelPool.shards[0].pagePool) != 3 || len(parallelPool.shards[3].pagePool) != 2 {
+		test.Errorf("pool frames not split over the shards as expected")
+	}
+}
+
+func TestInitParallelBuffPoolInvalidShards(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	if _, err := InitParallelBuffPool(d, Options{PoolFrames: 4}, 0); !errors.Is(err, ErrInvalidOptions) {
+		test.Errorf("zero shards do not return ErrInvalidOptions")
+	}
+	if _, err := InitParallelBuffPool(d, Options{PoolFrames: 4}, 5); !errors.Is(err, ErrInvalidOptions) {
+		test.Errorf("more shards than frames do not return ErrInvalidOptions")
+	}
+}
+
+func TestParallelBufferPoolNewPageRoundRobin(test *testing.T) {
+	parallelPool := getTestParallelPool(test, disktest.GetFileInit(test), Options{PoolFrames: 16}, 4)
+	for i := range 8 {
+		page, err := parallelPool.NewPage()
+		if err != nil || page.PageId != i+1 {
+			test.Errorf("parallel new page not working as expected: %v", err)
+			return
+		}
+		for shardIndex, shard := range parallelPool.shards {
+			if _, ok := shard.pageMap[page.PageId]; ok != (shardIndex == (i+1)%4) {
+				test.Errorf("new pageId %d not created in shard %d", page.PageId, (i+1)%4)
+			}
+		}
+	}
+}
+
+func TestParallelBufferPoolRouting(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	parallelPool := getTestParallelPool(test, d, Options{PoolFrames: 8}, 4)
+	pageIds := make([]int, 6)
+	for i := range pageIds {
+		guard, _ := parallelPool.NewPageWrite()
+		guard.MutableData()[100] = byte(i + 1)
+		pageIds[i] = guard.PageId()
+		guard.Release()
+	}
+	for i, pageId := range pageIds {
+		page, err := parallelPool.FetchPage(pageId)
+		if err != nil || page.pageData[100] != byte(i+1) {
+			test.Errorf("parallel fetch page of pageId %d not working as expected", pageId)
+		}
+		parallelPool.PinPage(pageId)
+		if !parallelPool.getShard(pageId).pinSet.Contains(parallelPool.getShard(pageId).pageMap[pageId]) {
+			test.Errorf("parallel pin page not routed to the shard of pageId %d", pageId)
+		}
+		if !parallelPool.UnpinPage(pageId) {
+			test.Errorf("parallel unpin page of pageId %d not working as expected", pageId)
+		}
+		if err := parallelPool.FlushPage(pageId); err != nil {
+			test.Errorf("parallel flush page of pageId %d failed: %v", pageId, err)
+		}
+	}
+	if err := parallelPool.FlushPage(42); !errors.Is(err, ErrPageNotInPool) {
+		test.Errorf("parallel flush of a page not in the pool does not return ErrPageNotInPool")
+	}
+	if err := parallelPool.Close(); err != nil {
+		test.Errorf("parallel close failed: %v", err)
+	}
+
+	reopenedPool := getTestPool(test, d, Options{})
+	for i, pageId := range pageIds {
+		page, err := reopenedPool.FetchPage(pageId)
+		if err != nil || page.pageData[100] != byte(i+1) {
+			test.Errorf("page %d written through the parallel pool not durable", pageId)
+		}
+	}
+	reopenedPool.Close()
+}
+
+func TestParallelBufferPoolDeletePage(test *testing.T) {
+	parallelPool := getTestParallelPool(test, disktest.GetFileInit(test), Options{PoolFrames: 8}, 4)
+	for range 4 {
+		parallelPool.NewPage()
+	}
+	if err := parallelPool.DeletePage(3); err != nil {
+		test.Errorf("parallel delete page failed: %v", err)
+	}
+	if _, err := parallelPool.FetchPage(3); !errors.Is(err, diskmgr.ErrPageFree) {
+		test.Errorf("parallel fetch of a deleted page does not fail: %v", err)
+	}
+	// the rotation creates the next page in shard 1
+	page, err := parallelPool.NewPage()
+	if err != nil || page.PageId != 3 {
+		test.Errorf("parallel new page does not reuse the deleted page")
+		return
+	}
+	_, inShard1 := parallelPool.shards[1].pageMap[3]
+	_, inShard3 := parallelPool.shards[3].pageMap[3]
+	if !inShard1 || inShard3 || parallelPool.getShard(3) != parallelPool.shards[1] {
+		test.Errorf("reused pageId 3 is not only in shard 1")
+	}
+	if fetchedPage, err := parallelPool.FetchPage(3); err != nil || fetchedPage != page || fetchedPage.PageLSN == math.MaxInt64 {
+		test.Errorf("parallel fetch of the reused pageId does not return the new page: %v", err)
+	}
+
+	if err := parallelPool.DeletePage(3); err != nil || parallelPool.getShard(3) != parallelPool.shards[3] {
+		test.Errorf("deleted pageId 3 not routed back to shard 3: %v", err)
+	}
+}
+
+func TestParallelBufferPoolNewPageRotatesShards(test *testing.T) {
+	parallelPool := getTestParallelPool(test, disktest.GetFileInit(test), Options{PoolFrames: 16}, 4)
+	for range 8 {
+		parallelPool.NewPage()
+	}
+	parallelPool.DeletePage(2)
+	parallelPool.DeletePage(6)
+	// pageId 6 and then 2 come off the free list, the shards keep rotating from shard 1
+	for i, pageId := range []int{6, 2, 9} {
+		page, err := parallelPool.NewPage()
+		if err != nil || page.PageId != pageId || parallelPool.getShard(pageId) != parallelPool.shards[(i+1)%4] {
+			test.Errorf("new pageId %d not created in shard %d: %v", pageId, (i+1)%4, err)
+		}
+		if _, ok := parallelPool.shards[(i+1)%4].pageMap[pageId]; !ok {
+			test.Errorf("new pageId %d not cached in shard %d", pageId, (i+1)%4)
+		}
+	}
+}
+
+// the page is freed on disk while shard 2 still caches it, the new page on its pageId in shard 1 drops that stale frame
+func TestParallelBufferPoolNewPageDropsStaleFrame(test *testing.T) {
+	parallelPool := getTestParallelPool(test, disktest.GetFileInit(test), Options{PoolFrames: 8}, 4)
+	for range 4 {
+		parallelPool.NewPage()
+	}
+	parallelPool.diskMgr.DeallocatePage(2)
+	page, err := parallelPool.NewPage()
+	if err != nil || page.PageId != 2 || parallelPool.getShard(2) != parallelPool.shards[1] {
+		test.Errorf("parallel new page does not reuse the freed page in shard 1: %v", err)
+		return
+	}
+	if _, ok := parallelPool.shards[2].pageMap[2]; ok {
+		test.Errorf("stale frame of the freed page left in shard 2")
+	}
+}
+
+// a NewPage whose victim write back is slow does not hold up a NewPage in another shard
+func TestParallelBufferPoolNewPageOutsideAllocMux(test *testing.T) {
+	parallelPool := getTestParallelPool(test, disktest.GetFileInit(test), Options{PoolFrames: 2}, 2)
+	guard, _ := parallelPool.NewPageWrite()
+	guard.MutableData()[100] = 1
+	dirtyPageId := guard.PageId()
+	guard.Release()
+	parallelPool.NewPage()
+
+	shard := parallelPool.getShard(dirtyPageId)
+	diskMgr := shard.diskMgr
+	blockDiskMgr := &blockWriteDiskMgr{DiskFileMgr: diskMgr, blockPageId: dirtyPageId, writing: make(chan struct{}), release: make(chan struct{})}
+	shard.diskMgr = blockDiskMgr
+	blockedDone := make(chan error)
+	go func() {
+		// the next page goes to the shard of the dirty page, which is its only victim
+		_, err := parallelPool.NewPage()
+		blockedDone <- err
+	}()
+	<-blockDiskMgr.writing
+
+	newPageDone := make(chan error)
+	go func() {
+		_, err := parallelPool.NewPage()
+		newPageDone <- err
+	}()
+	select {
+	case err := <-newPageDone:
+		if err != nil {
+			test.Errorf("new page in the other shard failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		test.Errorf("new page in the other shard waits for the victim write back of the first shard")
+		defer func() { <-newPageDone }()
+	}
+	close(blockDiskMgr.release)
+	if err := <-blockedDone; err != nil {
+		test.Errorf("new page that evicts the dirty page failed: %v", err)
+	}
+	shard.diskMgr = diskMgr
+}
+
+func TestParallelBufferPoolClose(test *testing.T) {
+	parallelPool := getTestParallelPool(test, disktest.GetFileInit(test), Options{PoolFrames: 8}, 4)
+	guard, _ := parallelPool.NewPageWrite()
+	if err := parallelPool.Close(); !errors.Is(err, ErrPagePinned) {
+		test.Errorf("parallel close with a pinned page does not return ErrPagePinned")
+	}
+	guard.Release()
+	if err := parallelPool.Close(); err != nil {
+		test.Errorf("parallel close failed: %v", err)
+	}
+	if _, err := parallelPool.NewPage(); !errors.Is(err, ErrClosed) {
+		test.Errorf("parallel new page after close does not return ErrClosed")
+	}
+	if _, err := parallelPool.FetchPage(1); !errors.Is(err, ErrClosed) {
+		test.Errorf("parallel fetch page after close does not return ErrClosed")
+	}
+	if err := parallelPool.Close(); !errors.Is(err, ErrClosed) {
+		test.Errorf("second parallel close does not return ErrClosed")
+	}
+}
+
+func TestParallelBufferPoolRecovery(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	bfrPool := getTestPool(test, d, Options{})
+	newPage, _ := bfrPool.NewPage()
+	txn, _ := bfrPool.BeginTxn()
+	bfrPool.WritePageData(txn, newPage.PageId, 100, []byte{8})
+	bfrPool.CommitTxn(txn)
+	// the page never reaches the disk, only the log has the change
+
+	parallelPool, err := InitParallelBuffPool(d, Options{PoolFrames: 8}, 2)
+	if err != nil || parallelPool.GetRecoveryReport().RedoneRecords == 0 {
+		test.Errorf("parallel pool did not run recovery: %v", err)
+		return
+	}
+	closeTestParallelPool(test, parallelPool)
+	page, err := parallelPool.FetchPage(newPage.PageId)
+	if err != nil || page.pageData[100] != 8 {
+		test.Errorf("committed change not recovered by the parallel pool")
+	}
+	for _, shard := range parallelPool.shards {
+		if shard != parallelPool.getShard(newPage.PageId) && len(shard.pageMap) != 0 {
+			test.Errorf("recovered page cached outside its shard")
+		}
+	}
+}
+
+func TestParallelBufferPoolConcurrent(test *testing.T) {
+	const numPages, numWorkers, numRounds = 32, 100, 40
+	parallelPool := getTestParallelPool(test, disktest.GetFileInit(test), Options{PoolFrames: 16, SyncPolicy: diskmgr.SyncOnFlush}, 4)
+	for range numPages {
+		guard, _ := parallelPool.NewPageWrite()
+		guard.Release()
+	}
+
+	writes := make([]int, numWorkers)
+	var wg sync.WaitGroup
+	for w := range numWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range numRounds {
+				guard, err := parallelPool.FetchPageWrite(1 + (w*numRounds+r)%numPages)
+				if errors.Is(err, ErrNoFreeFrame) {
+					continue
+				}
+				if err != nil {
+					test.Errorf("parallel fetch page write failed: %v", err)
+					return
+				}
+				data := guard.MutableData()
+				binary.LittleEndian.PutUint64(data[100:], binary.LittleEndian.Uint64(data[100:])+1)
+				writes[w]++
+				guard.Release()
+			}
+		}()
+	}
+	wg.Wait()
+
+	total, numWrites := 0, 0
+	for pageId := 1; pageId <= numPages; pageId++ {
+		guard, _ := parallelPool.FetchPageRead(pageId)
+		total += int(binary.LittleEndian.Uint64(guard.Data()[100:]))
+		guard.Release()
+	}
+	for _, n := range writes {
+		numWrites += n
+	}
+	if total != numWrites || parallelPool.ActiveGuards() != 0 {
+		test.Errorf("%d writes made but %d found on the pages", numWrites, total)
+	}
+}
+
+/*
+BenchmarkParallelBufferPoolFetch runs the fetches of BenchmarkFetchPageParallel over 1 and 8 shards.
+run it with -cpu 1,8,32 to compare the contention on one pool lock against the sharded locks.
+*/
+func BenchmarkParallelBufferPoolFetch(b *testing.B) {
+	const numPages, poolFrames = 256, 64
+	for _, numShards := range []int{1, 8} {
+		b.Run(fmt.Sprintf("shards-%d", numShards), func(b *testing.B) {
+			d := disktest.GetFileInit(b)
+			parallelPool := getTestParallelPool(b, d, Options{PoolFrames: poolFrames, SyncPolicy: diskmgr.SyncOnFlush}, numShards)
+			for range numPages {
+				parallelPool.NewPage()
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				rng := rand.New(rand.NewSource(rand.Int63()))
+				for pb.Next() {
+					pageId := 1 + rng.Intn(poolFrames/2)
+					if rng.Intn(4) == 0 {
+						pageId = 1 + rng.Intn(numPages)
+					}
+					guard, err := parallelPool.FetchPageRead(pageId)
+					if err != nil {
+						continue
+					}
+					guard.Release()
+				}
+			})
+		})
+	}
+}
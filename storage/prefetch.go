@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"github.com/rohithputha/HymStMgr/constants"
+)
+
+/*
+prefetches read pages into unpinned frames on background goroutines, so that the fetches of a range scan find them in the pool.
+the read of a prefetched page counts as an access in the repl pol, so a prefetch does not evict the page the one before it read.
+the workers are started by the first prefetch and stopped by Close. the requests are queued without bpsMux held, Close holds
+prefetchMux while it waits for the workers and the workers need bpsMux.
+*/
+
+// PoolStats counts the fetches, prefetches and write backs of a pool since it was opened
+type PoolStats struct {
+	Fetches        int64
+	FetchHits      int64 // fetches that found the page in the pool
+	Prefetches     int64 // pages read into the pool by PrefetchPages or read-ahead
+	PrefetchHits   int64 // prefetched pages a fetch used
+	PrefetchUnused int64 // prefetched pages evicted or deleted before any fetch used them
+	VictimWrites   int64 // dirty victims written back by an eviction
+	BgWrites       int64 // dirty pages written back by the background writer
+}
+
+// prefetchReq is a run of pages read with one ReadPages call of the disk manager
+type prefetchReq struct {
+	pageIds []int
+	ring    *accessRing // the ring of the strategy whose fetch triggered the read-ahead, nil for the pool
+}
+
+// prefetchRuns splits the pages into requests of neighbouring pageIds, in the order they are given
+func prefetchRuns(pageIds []int, ring *accessRing) (reqs []prefetchReq) {
+	for i, pageId := range pageIds {
+		if i == 0 || pageId != pageIds[i-1]+1 || len(reqs[len(reqs)-1].pageIds) == constants.IORunPages {
+			reqs = append(reqs, prefetchReq{ring: ring})
+		}
+		reqs[len(reqs)-1].pageIds = append(reqs[len(reqs)-1].pageIds, pageId)
+	}
+	return reqs
+}
+
+func (bp *BuffPoolMgrStr) GetStats() PoolStats {
+	bp.bpsMux.Lock()
+	defer bp.bpsMux.Unlock()
+	return bp.stats
+}
+
+/*
+PrefetchPages queues reads of the pages and returns without waiting for them.
+pages that are in the pool already, past the end of the file or that do not fit the queue any more are skipped, a prefetch is only a hint.
+*/
+func (bp *BuffPoolMgrStr) PrefetchPages(pageIds []int) (prefetchErr error) {
+	if bp.closed.Load() {
+		return ErrClosed
+	}
+	bp.queuePrefetch(prefetchRuns(pageIds, nil))
+	return nil
+}
+
+// queuePrefetch starts the workers if they are not running and queues the requests, bpsMux should not be held
+func (bp *BuffPoolMgrStr) queuePrefetch(reqs []prefetchReq) {
+	if len(reqs) == 0 {
+		return
+	}
+	bp.prefetchMux.Lock()
+	defer bp.prefetchMux.Unlock()
+	if bp.closed.Load() {
+		return
+	}
+	if bp.prefetchStop == nil {
+		bp.prefetchStop = make(chan struct{})
+		for range bp.prefetchWorkers {
+			bp.prefetchWg.Add(1)
+			go bp.prefetchWorker(bp.prefetchStop)
+		}
+	}
+	for _, req := range reqs {
+		select {
+		case bp.prefetchQueue <- req:
+		default:
+			return
+		}
+	}
+}
+
+func (bp *BuffPoolMgrStr) prefetchWorker(stop chan struct{}) {
+	defer bp.prefetchWg.Done()
+	for {
+		select {
+		case <-stop:
+			return
+		case req := <-bp.prefetchQueue:
+			bp.prefetchPages(req)
+		}
+	}
+}
+
+// stopPrefetch stops the workers and waits for the reads they are running, the requests still queued are run by the next workers
+func (bp *BuffPoolMgrStr) stopPrefetch() {
+	bp.prefetchMux.Lock()
+	defer bp.prefetchMux.Unlock()
+	if bp.prefetchStop == nil {
+		return
+	}
+	close(bp.prefetchStop)
+	bp.prefetchWg.Wait()
+	bp.prefetchStop = nil
+}
+
+/*
+prefetchPages reads the pages of the request into frames the way a fetch miss does, but leaves them unpinned.
+the frames are reserved first and the pages are then read with one call, so the disk manager reads neighbouring pages together.
+*/
+func (bp *BuffPoolMgrStr) prefetchPages(req prefetchReq) {
+	loadIds, loadData := make([]int, 0), make([][]byte, 0)
+	loadIndexes, loadIOs := make([]int, 0), make([]*pageIO, 0)
+	bp.bpsMux.Lock()
+	for _, pageId := range req.pageIds {
+		if !bp.prefetchable(pageId) {
+			continue
+		}
+		sPage, sPageIndex, sErr := bp.selectPage(req.ring)
+		if sErr != nil {
+			break
+		}
+		if !bp.prefetchable(pageId) {
+			// a fetch loaded the page while selectPage wrote the victim back
+			bp.releaseFrame(sPageIndex)
+			continue
+		}
+		sPage.prefetched = true
+		loadIOs = append(loadIOs, bp.beginLoad(pageId, sPage, sPageIndex))
+		loadIds = append(loadIds, pageId)
+		loadData = append(loadData, sPage.pageData[:])
+		loadIndexes = append(loadIndexes, sPageIndex)
+	}
+	bp.bpsMux.Unlock()
+	if len(loadIds) == 0 {
+		return
+	}
+
+	readErrs := bp.diskMgr.ReadPages(loadIds, loadData)
+	for i, sPageIndex := range loadIndexes {
+		if readErrs[i] == nil {
+			bp.pagePool[sPageIndex].loadLSN()
+		}
+	}
+	bp.bpsMux.Lock()
+	for i, sPageIndex := range loadIndexes {
+		bp.endLoad(loadIds[i], &bp.pagePool[sPageIndex], sPageIndex, false, loadIOs[i], readErrs[i])
+	}
+	bp.bpsMux.Unlock()
+	for _, sPageIndex := range loadIndexes {
+		bp.pagePool[sPageIndex].pageMux.Unlock()
+	}
+}
+
+// prefetchable tells if the page exists, belongs to the pool and is neither in the pool nor being read, bpsMux should be held
+func (bp *BuffPoolMgrStr) prefetchable(pageId int) bool {
+	_, cached := bp.pageMap[pageId]
+	return !bp.closed.Load() && !cached && bp.inFlight[pageId] == nil && pageId > 0 && pageId < bp.diskMgr.GetPageCount() &&
+		(bp.ownsPage == nil || bp.ownsPage(pageId))
+}
+
+/*
+readAheadFor notes the fetch of pageId and returns the pages to read ahead once the last fetches were sequential, bpsMux should be held.
+the pages are read readAheadPages ahead of the fetches, a fetch through a ring reads ahead no further than the ring can hold.
+a shard of a ParallelBufferPool only sees every readAheadStride-th page, so its pages are counted in that stride.
+*/
+func (bp *BuffPoolMgrStr) readAheadFor(pageId int, ring *accessRing) (reqs []prefetchReq) {
+	if bp.readAheadPages == 0 || pageId == bp.lastFetchedPageId {
+		return nil
+	}
+	if pageId == bp.lastFetchedPageId+bp.readAheadStride {
+		bp.seqFetches++
+	} else {
+		bp.seqFetches = 1
+		bp.readAheadUntil = 0
+	}
+	bp.lastFetchedPageId = pageId
+	if bp.seqFetches < constants.ReadAheadTrigger {
+		return nil
+	}
+	window := bp.readAheadPages
+	if ring != nil {
+		window = min(window, ring.size-1)
+	}
+	readAheadUntil := pageId + window*bp.readAheadStride
+	readAheadIds := make([]int, 0)
+	for readAheadId := max(pageId, bp.readAheadUntil) + bp.readAheadStride; readAheadId <= readAheadUntil; readAheadId += bp.readAheadStride {
+		if bp.prefetchable(readAheadId) {
+			readAheadIds = append(readAheadIds, readAheadId)
+		}
+	}
+	bp.readAheadUntil = max(bp.readAheadUntil, readAheadUntil)
+	return prefetchRuns(readAheadIds, ring)
+}
@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rohithputha/HymStMgr/constants"
+	"github.com/rohithputha/HymStMgr/diskmgr"
+	"github.com/rohithputha/HymStMgr/diskmgr/disktest"
+)
+
+// getPrefetchTestPool returns a pool of 16 frames over 40 pages, pages 1 to 24 are not in the pool. read-ahead is off unless opts sets it
+func getPrefetchTestPool(test *testing.T, opts Options) *BuffPoolMgrStr {
+	opts.PoolFrames = 16
+	if opts.ReadAheadPages == 0 {
+		opts.ReadAheadPages = -1
+	}
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), opts)
+	for range 40 {
+		bfrPool.NewPage()
+	}
+	return bfrPool
+}
+
+// waitForPages waits till the prefetches have read every page into the pool
+func waitForPages(test *testing.T, bp *BuffPoolMgrStr, pageIds []int) {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		bp.bpsMux.Lock()
+		cached := 0
+		for _, pageId := range pageIds {
+			if i, ok := bp.pageMap[pageId]; ok && bp.inFlight[pageId] == nil && bp.pagePool[i].Pin == 0 {
+				cached++
+			}
+		}
+		bp.bpsMux.Unlock()
+		if cached == len(pageIds) {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	test.Fatalf("pages %v not prefetched", pageIds)
+}
+
+func TestPrefetchPages(test *testing.T) {
+	bfrPool := getPrefetchTestPool(test, Options{})
+	pageIds := []int{1, 2, 3, 4, 5, 6, 7, 8}
+	if err := bfrPool.PrefetchPages(pageIds); err != nil {
+		test.Errorf("prefetch pages failed: %v", err)
+		return
+	}
+	waitForPages(test, bfrPool, pageIds)
+	for _, pageId := range pageIds {
+		if page := &bfrPool.pagePool[bfrPool.pageMap[pageId]]; !page.prefetched || page.IsDirty {
+			test.Errorf("prefetched pageId %d not marked as prefetched", pageId)
+		}
+	}
+	before := bfrPool.GetStats()
+	for _, pageId := range pageIds {
+		guard, err := bfrPool.FetchPageRead(pageId)
+		if err != nil || guard.PageId() != pageId {
+			test.Errorf("fetch of prefetched pageId %d not working as expected", pageId)
+			continue
+		}
+		guard.Release()
+	}
+	stats := bfrPool.GetStats()
+	if stats.Prefetches != 8 || stats.PrefetchHits != 8 || stats.FetchHits-before.FetchHits != 8 || stats.Fetches-before.Fetches != 8 {
+		test.Errorf("prefetch stats not working as expected: %+v", stats)
+	}
+}
+
+func TestPrefetchPagesSkipped(test *testing.T) {
+	bfrPool := getPrefetchTestPool(test, Options{PrefetchWorkers: 1})
+	// one worker runs the requests in order, so the skipped ones are done once page 1 is in
+	bfrPool.PrefetchPages([]int{40, 1000, 0, -3, 1})
+	waitForPages(test, bfrPool, []int{1})
+	if _, ok := bfrPool.pageMap[1000]; ok || bfrPool.GetStats().Prefetches != 1 {
+		test.Errorf("prefetch of cached or missing pages not skipped")
+	}
+}
+
+func TestPrefetchUnused(test *testing.T) {
+	bfrPool := getPrefetchTestPool(test, Options{})
+	pageIds := []int{1, 2, 3, 4}
+	bfrPool.PrefetchPages(pageIds)
+	waitForPages(test, bfrPool, pageIds)
+	bfrPool.FetchPage(1)
+	// 15 misses evict the 12 pages older than the prefetches and then the prefetched pages that were not used
+	for pageId := 10; pageId <= 24; pageId++ {
+		bfrPool.FetchPage(pageId)
+	}
+	if stats := bfrPool.GetStats(); stats.PrefetchUnused != 3 || stats.PrefetchHits != 1 {
+		test.Errorf("unused prefetches not counted as expected: %+v", stats)
+	}
+	if _, ok := bfrPool.pageMap[1]; !ok {
+		test.Errorf("prefetched page that was used is evicted before the unused ones")
+	}
+}
+
+func TestReadAhead(test *testing.T) {
+	bfrPool := getPrefetchTestPool(test, Options{ReadAheadPages: 4})
+	for pageId := 1; pageId <= 3; pageId++ {
+		bfrPool.FetchPage(pageId)
+	}
+	waitForPages(test, bfrPool, []int{4, 5, 6, 7})
+	for pageId := 4; pageId <= 20; pageId++ {
+		waitForPages(test, bfrPool, []int{pageId})
+		bfrPool.FetchPage(pageId)
+	}
+	if stats := bfrPool.GetStats(); stats.PrefetchHits != 17 {
+		test.Errorf("sequential fetches did not hit the read-ahead: %+v", stats)
+	}
+}
+
+func TestReadAheadNotSequential(test *testing.T) {
+	bfrPool := getPrefetchTestPool(test, Options{ReadAheadPages: 4})
+	for _, pageId := range []int{1, 5, 3, 9, 10, 2} {
+		bfrPool.FetchPage(pageId)
+	}
+	if bfrPool.prefetchStop != nil || bfrPool.GetStats().Prefetches != 0 {
+		test.Errorf("fetches that are not sequential started a read-ahead")
+	}
+	noReadAheadPool := getPrefetchTestPool(test, Options{})
+	for pageId := 1; pageId <= 10; pageId++ {
+		noReadAheadPool.FetchPage(pageId)
+	}
+	if noReadAheadPool.prefetchStop != nil {
+		test.Errorf("read-ahead runs although it is turned off")
+	}
+}
+
+func TestReadAheadDefault(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	bfrPool := getTestPool(test, d, Options{})
+	for range 40 {
+		bfrPool.NewPage()
+	}
+	bfrPool.Close()
+
+	// the default read-ahead of a pool of the default size
+	reopenedPool := getTestPool(test, d, Options{})
+	for pageId := 1; pageId <= 3; pageId++ {
+		reopenedPool.FetchPage(pageId)
+	}
+	readAheadIds := make([]int, 0)
+	for pageId := 4; pageId < 4+constants.ReadAheadPages; pageId++ {
+		readAheadIds = append(readAheadIds, pageId)
+	}
+	waitForPages(test, reopenedPool, readAheadIds)
+	if stats := reopenedPool.GetStats(); stats.Prefetches != int64(constants.ReadAheadPages) {
+		test.Errorf("default options do not read ahead %d pages: %+v", constants.ReadAheadPages, stats)
+	}
+
+	// a small pool reads ahead an eighth of its frames, a tiny one none
+	if smallPool := getTestPool(test, disktest.GetFileInit(test), Options{PoolFrames: 16}); smallPool.readAheadPages != 2 {
+		test.Errorf("default read-ahead of a pool of 16 frames is %d pages", smallPool.readAheadPages)
+	}
+	if tinyPool := getTestPool(test, disktest.GetFileInit(test), Options{PoolFrames: 4}); tinyPool.readAheadPages != 0 {
+		test.Errorf("default read-ahead of a pool of 4 frames is %d pages", tinyPool.readAheadPages)
+	}
+}
+
+func TestReadAheadThroughRing(test *testing.T) {
+	bfrPool := getPrefetchTestPool(test, Options{ReadAheadPages: 8})
+	scan := NewAccessStrategy(AccessSequentialScan)
+	for pageId := 1; pageId <= 3; pageId++ {
+		bfrPool.FetchPageWithStrategy(pageId, scan)
+	}
+	// the ring has 2 frames, so the read-ahead is 1 page and goes into the ring
+	waitForPages(test, bfrPool, []int{4})
+	if page := &bfrPool.pagePool[bfrPool.pageMap[4]]; page.ring != scan.ringOf(bfrPool) {
+		test.Errorf("read-ahead of a ring fetch not read into the ring")
+	}
+	if _, ok := bfrPool.pageMap[5]; ok {
+		test.Errorf("read-ahead of a ring fetch reads further than the ring holds")
+	}
+}
+
+func TestCloseStopsPrefetch(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{PoolFrames: 16})
+	for range 40 {
+		bfrPool.NewPage()
+	}
+	bfrPool.PrefetchPages([]int{1, 2, 3, 4, 5, 6, 7, 8})
+	if err := bfrPool.Close(); err != nil {
+		test.Errorf("close with prefetches running failed: %v", err)
+	}
+	if bfrPool.prefetchStop != nil {
+		test.Errorf("close did not stop the prefetch workers")
+	}
+	if err := bfrPool.PrefetchPages([]int{1}); !errors.Is(err, ErrClosed) {
+		test.Errorf("prefetch pages after close does not return ErrClosed")
+	}
+}
+
+func TestParallelBufferPoolReadAhead(test *testing.T) {
+	parallelPool := getTestParallelPool(test, disktest.GetFileInit(test), Options{PoolFrames: 32, ReadAheadPages: 4}, 2)
+	for range 80 {
+		parallelPool.NewPage()
+	}
+	for pageId := 1; pageId <= 6; pageId++ {
+		parallelPool.FetchPage(pageId)
+	}
+	// every shard sees every other page, its read-ahead runs in the same stride
+	waitForPages(test, parallelPool.shards[1], []int{7, 9, 11, 13})
+	waitForPages(test, parallelPool.shards[0], []int{8, 10, 12, 14})
+	if stats := parallelPool.GetStats(); stats.Prefetches != 8 {
+		test.Errorf("parallel read-ahead not working as expected: %+v", stats)
+	}
+	if err := parallelPool.Close(); err != nil {
+		test.Errorf("parallel close failed: %v", err)
+	}
+}
+
+// readRecordingDiskMgr records the pageIds of every ReadPages call
+type readRecordingDiskMgr struct {
+	diskmgr.DiskFileMgr
+	readMux *sync.Mutex
+	reads   [][]int
+}
+
+func (rd *readRecordingDiskMgr) ReadPages(pageIds []int, readData [][]byte) []error {
+	rd.readMux.Lock()
+	rd.reads = append(rd.reads, slices.Clone(pageIds))
+	rd.readMux.Unlock()
+	return rd.DiskFileMgr.ReadPages(pageIds, readData)
+}
+
+func TestPrefetchPagesReadTogether(test *testing.T) {
+	diskMgr, _ := diskmgr.GetDiskFileMgr(disktest.GetFileInit(test))
+	readDiskMgr := &readRecordingDiskMgr{DiskFileMgr: diskMgr, readMux: &sync.Mutex{}}
+	bfrPool := getTestPoolOn(test, readDiskMgr, Options{PoolFrames: 16, PrefetchWorkers: 1})
+	for range 40 {
+		bfrPool.NewPage()
+	}
+	pageIds := []int{1, 2, 3, 4, 5, 9, 10, 11}
+	bfrPool.PrefetchPages(pageIds)
+	waitForPages(test, bfrPool, pageIds)
+	bfrPool.Close()
+	expected := [][]int{{1, 2, 3, 4, 5}, {9, 10, 11}}
+	if !slices.EqualFunc(readDiskMgr.reads, expected, slices.Equal) {
+		test.Errorf("prefetch read %v, expected the runs %v", readDiskMgr.reads, expected)
+	}
+}
@@ -0,0 +1,294 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/rohithputha/HymStMgr/constants"
+	"github.com/rohithputha/HymStMgr/diskmgr"
+	"github.com/rohithputha/HymStMgr/logmgr"
+)
+
+// RecoveryReport is what the last recovery on InitBuffPoolMgr did, RolledBackTxns are the txn ids that were undone (losers)
+type RecoveryReport struct {
+	AnalysisStartLsn int64 // begin lsn of the checkpoint analysis started from, InvalidLsn if the whole log was read
+	RedoneRecords    int
+	UndoneRecords    int
+	RolledBackTxns   []int64
+}
+
+type recTxnEntry struct {
+	lastLsn   int64
+	committed bool
+}
+
+/*
+recover brings the pages back to a consistent state after a crash (ARIES):
+analysis scans the log to build the active txn table and the dirty page table,
+redo repeats history for every page change from the smallest recLsn in the dirty page table,
+undo rolls back the txns that never committed, logging compensation records so that a crash during recovery is also safe.
+*/
+func (bp *BuffPoolMgrStr) recover() (recErr error) {
+	txnTable, dirtyPages, lsnOffsets, recErr := bp.analysisPass()
+	if recErr != nil {
+		return recErr
+	}
+	if recErr = bp.redoPass(dirtyPages, lsnOffsets); recErr != nil {
+		return recErr
+	}
+	if recErr = bp.undoPass(txnTable, lsnOffsets); recErr != nil {
+		return recErr
+	}
+	return bp.logMgr.Flush(bp.logMgr.GetLastLsn())
+}
+
+/*
+analysisPass starts from the last complete checkpoint if there is one: the tables are seeded from the checkpoint end record
+and only the records from the checkpoint begin are replayed on top of them. lsnOffsets covers the whole log for redo and undo.
+*/
+func (bp *BuffPoolMgrStr) analysisPass() (txnTable map[int64]*recTxnEntry, dirtyPages map[int]int64, lsnOffsets map[int64]int64, analysisErr error) {
+	txnTable = make(map[int64]*recTxnEntry)
+	dirtyPages = make(map[int]int64) // pageId -> recLsn, the first lsn that may have made the page dirty
+
+	lsnOffsets, ckptData, analysisErr := bp.scanLog()
+	if analysisErr != nil {
+		return nil, nil, nil, analysisErr
+	}
+	logIter := bp.logMgr.GetLogIterator()
+	if ckptData != nil {
+		for txnId, ckptTxn := range ckptData.ActiveTxns {
+			txnTable[txnId] = &recTxnEntry{lastLsn: ckptTxn.LastLsn, committed: ckptTxn.Committed}
+		}
+		for pageId, recLsn := range ckptData.DirtyPages {
+			dirtyPages[pageId] = recLsn
+		}
+		bp.nextTxnId = max(bp.nextTxnId, ckptData.NextTxnId)
+		bp.recReport.AnalysisStartLsn = ckptData.BeginLsn
+		logIter.SetOffset(lsnOffsets[ckptData.BeginLsn])
+	}
+
+	for {
+		logRecord, readErr := logIter.Next()
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return nil, nil, nil, readErr
+		}
+		if logRecord.TxnId >= bp.nextTxnId {
+			bp.nextTxnId = logRecord.TxnId + 1
+		}
+
+		switch logRecord.RecType {
+		case logmgr.LogCheckpointBegin, logmgr.LogCheckpointEnd:
+			continue
+		case logmgr.LogEnd:
+			delete(txnTable, logRecord.TxnId)
+			continue
+		case logmgr.LogUpdate, logmgr.LogCompensate:
+			if _, ok := dirtyPages[logRecord.PageId]; !ok {
+				dirtyPages[logRecord.PageId] = logRecord.Lsn
+			}
+		}
+		entry, ok := txnTable[logRecord.TxnId]
+		if !ok {
+			entry = &recTxnEntry{}
+			txnTable[logRecord.TxnId] = entry
+		}
+		// records between the checkpoint begin and end can be older than what the checkpoint saw
+		entry.lastLsn = max(entry.lastLsn, logRecord.Lsn)
+		if logRecord.RecType == logmgr.LogCommit {
+			entry.committed = true
+		}
+	}
+	return txnTable, dirtyPages, lsnOffsets, nil
+}
+
+// scanLog maps every lsn in the log to its file offset and finds the last complete checkpoint
+func (bp *BuffPoolMgrStr) scanLog() (lsnOffsets map[int64]int64, ckptData *logmgr.CheckpointData, scanErr error) {
+	lsnOffsets = make(map[int64]int64)
+	logIter := bp.logMgr.GetLogIterator()
+	for {
+		offset := logIter.GetOffset()
+		logRecord, readErr := logIter.Next()
+		if readErr == io.EOF {
+			return lsnOffsets, ckptData, nil
+		}
+		if readErr != nil {
+			return nil, nil, readErr
+		}
+		lsnOffsets[logRecord.Lsn] = offset
+		if logRecord.RecType == logmgr.LogCheckpointEnd {
+			if ckptData, scanErr = logRecord.GetCheckpointData(); scanErr != nil {
+				return nil, nil, scanErr
+			}
+		}
+	}
+}
+
+// redoPass applies a logged change again only if the page on disk is older than the record (page lsn < record lsn)
+func (bp *BuffPoolMgrStr) redoPass(dirtyPages map[int]int64, lsnOffsets map[int64]int64) (redoErr error) {
+	if len(dirtyPages) == 0 {
+		return nil
+	}
+	redoLsn := int64(-1)
+	for _, recLsn := range dirtyPages {
+		if redoLsn == -1 || recLsn < redoLsn {
+			redoLsn = recLsn
+		}
+	}
+
+	// the recLsn of a page is only a lower bound, start at the first record that is in the log from there
+	lastLsn := bp.logMgr.GetLastLsn()
+	for _, ok := lsnOffsets[redoLsn]; !ok && redoLsn < lastLsn; _, ok = lsnOffsets[redoLsn] {
+		redoLsn++
+	}
+	logIter := bp.logMgr.GetLogIterator()
+	logIter.SetOffset(lsnOffsets[redoLsn])
+	for {
+		logRecord, readErr := logIter.Next()
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+		if logRecord.RecType != logmgr.LogUpdate && logRecord.RecType != logmgr.LogCompensate {
+			continue
+		}
+		if recLsn, ok := dirtyPages[logRecord.PageId]; !ok || logRecord.Lsn < recLsn {
+			continue
+		}
+		if redoErr = bp.redoRecord(logRecord); redoErr != nil {
+			return redoErr
+		}
+	}
+}
+
+/*
+redoRecord applies the record if the page is older. a free page is never changed by redo: a reused page is synced before
+it is handed out (see installNewPage), so the only records of a page that is still free on disk are from before the delete.
+*/
+func (bp *BuffPoolMgrStr) redoRecord(logRecord *logmgr.LogRecord) (redoErr error) {
+	page, redoErr := bp.fetchRedoPage(logRecord.PageId)
+	if redoErr != nil || page == nil {
+		return redoErr
+	}
+	defer bp.UnpinPage(logRecord.PageId)
+	page.pageMux.Lock()
+	defer page.pageMux.Unlock()
+
+	// a blank page is a new page the crash lost (see fetchRedoPage), the log has every change of it
+	if isBlankPage(page.pageData) {
+		page.NewPage()
+	} else if page.IsCorrupted {
+		return fmt.Errorf("%w for pageId: %d", diskmgr.ErrPageCorrupted, logRecord.PageId)
+	}
+	if page.PageLSN >= logRecord.Lsn {
+		return nil
+	}
+	copy(page.pageData[logRecord.Offset:], logRecord.AfterImage)
+	page.markDirty(logRecord.Lsn)
+	bp.recReport.RedoneRecords++
+	return nil
+}
+
+/*
+fetchRedoPage fetches and pins the page of a record for redo. the end of the db file is only durable once it is synced,
+so a crash can lose a page that was allocated there: the page is then past the end of the file, or reads as zeros
+(preallocated space), or is still the blank page AllocatePage wrote. the missing pages are appended blank here,
+redoRecord makes the blank ones new pages and writes the logged changes on them again.
+a page on the free list is returned as nil with no error, there is nothing to redo on it.
+*/
+func (bp *BuffPoolMgrStr) fetchRedoPage(pageId int) (page *Page, fetchErr error) {
+	page, fetchErr = bp.fetchPage(pageId, true, nil)
+	if errors.Is(fetchErr, diskmgr.ErrPageFree) {
+		return nil, nil
+	}
+	if errors.Is(fetchErr, diskmgr.ErrPageNotFound) {
+		blankPage := make([]byte, bp.pageSize)
+		for nextPageId := bp.diskMgr.GetPageCount(); nextPageId <= pageId; nextPageId++ {
+			if fetchErr = bp.diskMgr.WritePageNoSync(nextPageId, blankPage); fetchErr != nil {
+				return nil, fetchErr
+			}
+		}
+		page, fetchErr = bp.fetchPage(pageId, true, nil)
+	}
+	if errors.Is(fetchErr, diskmgr.ErrPageCorrupted) {
+		// a corrupted page stays cached unpinned, redoRecord decides if it can be made again
+		bp.PinPage(pageId)
+		return page, nil
+	}
+	return page, fetchErr
+}
+
+// isBlankPage tells if the page holds nothing but its checksum, pages with no checksum at all count too
+func isBlankPage(pageData []byte) bool {
+	for i, b := range pageData {
+		if b != 0 && (i < constants.PageChecksumOffset || i >= constants.PageChecksumOffset+8) {
+			return false
+		}
+	}
+	return true
+}
+
+// undoPass always undoes the record with the largest lsn among all the losers, so the log is read backwards only once
+func (bp *BuffPoolMgrStr) undoPass(txnTable map[int64]*recTxnEntry, lsnOffsets map[int64]int64) (undoErr error) {
+	toUndo := make(map[int64]int64) // txnId -> next lsn to undo
+	for txnId, entry := range txnTable {
+		if entry.committed {
+			// commit reached the log but the end did not, nothing to undo
+			if _, undoErr = bp.logMgr.AppendLogRecord(&logmgr.LogRecord{RecType: logmgr.LogEnd, TxnId: txnId, PrevLsn: entry.lastLsn}); undoErr != nil {
+				return undoErr
+			}
+			continue
+		}
+		toUndo[txnId] = entry.lastLsn
+		bp.recReport.RolledBackTxns = append(bp.recReport.RolledBackTxns, txnId)
+	}
+	slices.Sort(bp.recReport.RolledBackTxns)
+
+	logIter := bp.logMgr.GetLogIterator()
+	for len(toUndo) > 0 {
+		undoTxnId, undoLsn := int64(0), logmgr.InvalidLsn
+		for txnId, lsn := range toUndo {
+			if lsn > undoLsn {
+				undoTxnId, undoLsn = txnId, lsn
+			}
+		}
+		logIter.SetOffset(lsnOffsets[undoLsn])
+		logRecord, readErr := logIter.Next()
+		if readErr != nil {
+			return readErr
+		}
+
+		nextLsn := logRecord.PrevLsn
+		switch logRecord.RecType {
+		case logmgr.LogUpdate:
+			clrLsn, clrErr := bp.undoUpdate(logRecord, txnTable[undoTxnId].lastLsn)
+			if clrErr != nil {
+				return clrErr
+			}
+			txnTable[undoTxnId].lastLsn = clrLsn
+			bp.recReport.UndoneRecords++
+		case logmgr.LogCompensate:
+			nextLsn = logRecord.UndoNextLsn
+		}
+
+		if nextLsn != logmgr.InvalidLsn {
+			toUndo[undoTxnId] = nextLsn
+			continue
+		}
+		delete(toUndo, undoTxnId)
+		if _, undoErr = bp.logMgr.AppendLogRecord(&logmgr.LogRecord{RecType: logmgr.LogEnd, TxnId: undoTxnId, PrevLsn: txnTable[undoTxnId].lastLsn}); undoErr != nil {
+			return undoErr
+		}
+	}
+	return nil
+}
+
+func (bp *BuffPoolMgrStr) GetRecoveryReport() RecoveryReport {
+	return bp.recReport
+}
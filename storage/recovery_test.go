@@ -0,0 +1,443 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/rohithputha/HymStMgr/constants"
+	"github.com/rohithputha/HymStMgr/diskmgr"
+	"github.com/rohithputha/HymStMgr/diskmgr/disktest"
+)
+
+var errSimulatedCrash = errors.New("simulated crash")
+
+/*
+crashDiskMgr simulates the process getting killed: after writesLeft page/log writes and syncs every write fails and nothing more reaches the files.
+the log write that hits the crash is torn, only half of it is written. page writes are assumed atomic here, but they are only durable
+once Sync runs (as under SyncOnFlush): the crash puts back what every page held at the last Sync.
+*/
+type crashDiskMgr struct {
+	diskmgr.DiskFileMgr
+	crashMux   *sync.Mutex
+	dbFile     *os.File
+	writesLeft int
+	crashed    bool
+	preImages  map[int][]byte // pageId -> the bytes on disk at the last Sync, for the pages written since
+}
+
+func getCrashDiskMgr(test *testing.T, d diskmgr.DiskFileInit, writesLeft int) *crashDiskMgr {
+	diskMgr := disktest.GetDiskMgr(test, d)
+	dbFile, openErr := os.OpenFile(d.DbFilePath, os.O_RDWR, 0)
+	if openErr != nil {
+		test.Fatalf("db file failed to open: %v", openErr)
+	}
+	test.Cleanup(func() { dbFile.Close() })
+	return &crashDiskMgr{DiskFileMgr: diskMgr, crashMux: &sync.Mutex{}, dbFile: dbFile, writesLeft: writesLeft, preImages: map[int][]byte{}}
+}
+
+// takeWrite counts a write, the one that hits the crash puts the pages back to the last Sync. crashMux is held
+func (cd *crashDiskMgr) takeWrite() bool {
+	if cd.crashed {
+		return false
+	}
+	if cd.writesLeft == 0 {
+		cd.crashed = true
+		pageSize := cd.GetPageSize()
+		for pageId, preImage := range cd.preImages {
+			cd.dbFile.WriteAt(preImage, int64(pageId*pageSize))
+		}
+		return false
+	}
+	cd.writesLeft--
+	return true
+}
+
+// keepPreImages saves what the pages hold on disk before their first write since the last Sync. crashMux is held
+func (cd *crashDiskMgr) keepPreImages(pageIds ...int) {
+	pageSize := cd.GetPageSize()
+	for _, pageId := range pageIds {
+		if _, ok := cd.preImages[pageId]; ok {
+			continue
+		}
+		preImage := make([]byte, pageSize)
+		cd.dbFile.ReadAt(preImage, int64(pageId*pageSize))
+		cd.preImages[pageId] = preImage
+	}
+}
+
+func (cd *crashDiskMgr) WritePage(pageId int, writeData []byte) error {
+	cd.crashMux.Lock()
+	defer cd.crashMux.Unlock()
+	if !cd.takeWrite() {
+		return errSimulatedCrash
+	}
+	cd.keepPreImages(pageId)
+	return cd.DiskFileMgr.WritePage(pageId, writeData)
+}
+
+func (cd *crashDiskMgr) WritePageNoSync(pageId int, writeData []byte) error {
+	cd.crashMux.Lock()
+	defer cd.crashMux.Unlock()
+	if !cd.takeWrite() {
+		return errSimulatedCrash
+	}
+	cd.keepPreImages(pageId)
+	return cd.DiskFileMgr.WritePageNoSync(pageId, writeData)
+}
+
+func (cd *crashDiskMgr) WritePages(pageIds []int, pagesData [][]byte) error {
+	cd.crashMux.Lock()
+	defer cd.crashMux.Unlock()
+	if !cd.takeWrite() {
+		return errSimulatedCrash
+	}
+	cd.keepPreImages(pageIds...)
+	return cd.DiskFileMgr.WritePages(pageIds, pagesData)
+}
+
+func (cd *crashDiskMgr) Sync() error {
+	cd.crashMux.Lock()
+	defer cd.crashMux.Unlock()
+	if !cd.takeWrite() {
+		return errSimulatedCrash
+	}
+	if syncErr := cd.DiskFileMgr.Sync(); syncErr != nil {
+		return syncErr
+	}
+	clear(cd.preImages)
+	return nil
+}
+
+func (cd *crashDiskMgr) WriteLog(logData []byte) error {
+	cd.crashMux.Lock()
+	defer cd.crashMux.Unlock()
+	if cd.crashed {
+		return errSimulatedCrash
+	}
+	if cd.writesLeft == 0 {
+		cd.DiskFileMgr.WriteLog(logData[:len(logData)/2])
+		cd.takeWrite()
+		return errSimulatedCrash
+	}
+	cd.writesLeft--
+	return cd.DiskFileMgr.WriteLog(logData)
+}
+
+func (cd *crashDiskMgr) DiscardLogPrefix(offset int64) error {
+	cd.crashMux.Lock()
+	defer cd.crashMux.Unlock()
+	if !cd.takeWrite() {
+		return errSimulatedCrash
+	}
+	return cd.DiskFileMgr.DiscardLogPrefix(offset)
+}
+
+func TestRecoverCommittedTxn(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	bfrPool := getTestPool(test, d, Options{})
+	newPage, _ := bfrPool.NewPage()
+	txn, _ := bfrPool.BeginTxn()
+	bfrPool.WritePageData(txn, newPage.PageId, 100, []byte{5, 6})
+	bfrPool.CommitTxn(txn)
+	// crash: the dirty page never reaches the db file
+
+	reopenedPool := getTestPool(test, d, Options{})
+	page, fetchErr := reopenedPool.FetchPage(newPage.PageId)
+	if fetchErr != nil || page.pageData[100] != 5 || page.pageData[101] != 6 {
+		test.Errorf("committed txn not redone on recovery")
+	}
+	if len(reopenedPool.GetRecoveryReport().RolledBackTxns) != 0 || reopenedPool.GetRecoveryReport().RedoneRecords != 1 {
+		test.Errorf("recovery report not as expected: %+v", reopenedPool.GetRecoveryReport())
+	}
+}
+
+func TestRecoverUncommittedTxn(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	bfrPool := getTestPool(test, d, Options{})
+	newPage, _ := bfrPool.NewPage()
+	txn, _ := bfrPool.BeginTxn()
+	bfrPool.WritePageData(txn, newPage.PageId, 100, []byte{5})
+	// steal: the uncommitted change reaches the disk before the crash
+	bfrPool.FlushPage(newPage.PageId)
+
+	reopenedPool := getTestPool(test, d, Options{})
+	page, _ := reopenedPool.FetchPage(newPage.PageId)
+	if page.pageData[100] != 0 {
+		test.Errorf("uncommitted txn not undone on recovery")
+	}
+	if !slices.Equal(reopenedPool.GetRecoveryReport().RolledBackTxns, []int64{txn.TxnId}) {
+		test.Errorf("rolled back txns not reported: %+v", reopenedPool.GetRecoveryReport())
+	}
+
+	// recovery logged the rollback, so a second restart has nothing more to undo
+	reopenedPool.FlushPage(newPage.PageId)
+	secondPool := getTestPool(test, d, Options{})
+	if len(secondPool.GetRecoveryReport().RolledBackTxns) != 0 {
+		test.Errorf("txn rolled back twice")
+	}
+}
+
+func TestRecoverNextTxnId(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	bfrPool := getTestPool(test, d, Options{})
+	txn, _ := bfrPool.BeginTxn()
+	bfrPool.CommitTxn(txn)
+
+	reopenedPool := getTestPool(test, d, Options{})
+	newTxn, _ := reopenedPool.BeginTxn()
+	if newTxn.TxnId <= txn.TxnId {
+		test.Errorf("txn ids reused after recovery")
+	}
+}
+
+/*
+runCrashScenario runs interleaved txns from three slots on a small pool (so dirty pages get evicted mid txn)
+until the simulated crash, optionally taking checkpoints along the way and crashing again during recovery, then reopens and checks that exactly the committed changes survived.
+every slot writes to its own region of the pages, so the txns never touch the same bytes.
+*/
+func runCrashScenario(test *testing.T, seed int64, crashAfterWrites int, recoveryCrashWrites int, withCheckpoints bool) {
+	const numPages, numSlots, poolSize = 12, 3, 4
+	regionSize := (constants.PageSize - constants.PageHeaderSize) / numSlots
+	rng := rand.New(rand.NewSource(seed))
+	d := disktest.GetFileInit(test)
+	d.SyncPolicy = diskmgr.SyncOnFlush
+
+	setupPool := getTestPoolOn(test, disktest.GetDiskMgr(test, d), Options{PoolFrames: poolSize})
+	pageIds := make([]int, numPages)
+	model := make(map[int][]byte)
+	for i := range numPages {
+		newPage, _ := setupPool.NewPage()
+		pageIds[i] = newPage.PageId
+		model[newPage.PageId] = make([]byte, constants.PageSize)
+	}
+
+	type pendingWrite struct {
+		pageId int
+		offset int
+		data   []byte
+	}
+	type slot struct {
+		txn    *Txn
+		writes []pendingWrite
+	}
+	slots := make([]slot, numSlots)
+	committed := map[int64]bool{}
+	started := map[int64]bool{}
+
+	crashPool, initErr := initBuffPoolMgr(getCrashDiskMgr(test, d, crashAfterWrites), Options{PoolFrames: poolSize})
+	if initErr == nil {
+		closeTestPool(test, crashPool)
+	}
+	for step := 0; initErr == nil && step < 300; step++ {
+		if withCheckpoints && rng.Intn(20) == 0 {
+			if crashPool.Checkpoint() != nil {
+				break
+			}
+			continue
+		}
+		s := rng.Intn(numSlots)
+		if slots[s].txn == nil {
+			txn, beginErr := crashPool.BeginTxn()
+			if beginErr != nil {
+				break
+			}
+			slots[s].txn = txn
+			started[txn.TxnId] = true
+			continue
+		}
+		switch op := rng.Intn(10); {
+		case op < 7:
+			w := pendingWrite{
+				pageId: pageIds[rng.Intn(numPages)],
+				offset: constants.PageHeaderSize + s*regionSize + rng.Intn(regionSize-8),
+				data:   make([]byte, 8),
+			}
+			rng.Read(w.data)
+			if crashPool.WritePageData(slots[s].txn, w.pageId, w.offset, w.data) != nil {
+				step = 300
+				continue
+			}
+			slots[s].writes = append(slots[s].writes, w)
+		case op < 9:
+			if crashPool.CommitTxn(slots[s].txn) != nil {
+				step = 300
+				continue
+			}
+			committed[slots[s].txn.TxnId] = true
+			for _, w := range slots[s].writes {
+				copy(model[w.pageId][w.offset:], w.data)
+			}
+			slots[s] = slot{}
+		default:
+			if crashPool.AbortTxn(slots[s].txn) != nil {
+				step = 300
+				continue
+			}
+			slots[s] = slot{}
+		}
+	}
+
+	if recoveryCrashWrites >= 0 {
+		// recovery itself may crash, or run to the end before the writes run out
+		if crashedPool, crashErr := initBuffPoolMgr(getCrashDiskMgr(test, d, recoveryCrashWrites), Options{PoolFrames: poolSize}); crashErr == nil {
+			closeTestPool(test, crashedPool)
+		}
+	}
+
+	recoveredPool, recErr := initBuffPoolMgr(disktest.GetDiskMgr(test, d), Options{PoolFrames: poolSize})
+	if recErr != nil {
+		test.Fatalf("seed %d crash %d: recovery failed: %v", seed, crashAfterWrites, recErr)
+	}
+	closeTestPool(test, recoveredPool)
+	for _, pageId := range pageIds {
+		page, fetchErr := recoveredPool.FetchPage(pageId)
+		if fetchErr != nil {
+			test.Fatalf("seed %d crash %d: fetch page %d failed: %v", seed, crashAfterWrites, pageId, fetchErr)
+		}
+		if !bytes.Equal(page.pageData[constants.PageHeaderSize:], model[pageId][constants.PageHeaderSize:]) {
+			test.Errorf("seed %d crash %d: page %d does not match the committed state", seed, crashAfterWrites, pageId)
+		}
+	}
+	for _, txnId := range recoveredPool.GetRecoveryReport().RolledBackTxns {
+		if committed[txnId] || !started[txnId] {
+			test.Errorf("seed %d crash %d: txn %d should not be rolled back", seed, crashAfterWrites, txnId)
+		}
+	}
+}
+
+func TestRecoverCrashAtArbitraryPoints(test *testing.T) {
+	for crashAfter := 0; crashAfter < 60; crashAfter += 3 {
+		for seed := int64(1); seed <= 3; seed++ {
+			runCrashScenario(test, seed, crashAfter, -1, false)
+		}
+	}
+}
+
+func TestRecoverCrashWithCheckpoints(test *testing.T) {
+	for crashAfter := 0; crashAfter < 90; crashAfter += 3 {
+		for seed := int64(1); seed <= 3; seed++ {
+			runCrashScenario(test, seed, crashAfter, -1, true)
+		}
+	}
+}
+
+func TestRecoverCrashDuringRecovery(test *testing.T) {
+	for recoveryCrash := 0; recoveryCrash < 8; recoveryCrash++ {
+		runCrashScenario(test, 7, 40, recoveryCrash, true)
+	}
+}
+
+func TestRecoverReusedPage(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	bfrPool := getTestPool(test, d, Options{})
+	oldPage, _ := bfrPool.NewPage()
+	oldPageId := oldPage.PageId
+	txn, _ := bfrPool.BeginTxn()
+	bfrPool.WritePageData(txn, oldPageId, 100, []byte{5})
+	bfrPool.CommitTxn(txn)
+	bfrPool.DeletePage(oldPageId)
+	newPage, _ := bfrPool.NewPage()
+	// crash: redo should not apply the committed update of the deleted page to the new page
+	reopenedPool := getTestPool(test, d, Options{})
+	page, fetchErr := reopenedPool.FetchPage(newPage.PageId)
+	if newPage.PageId != oldPageId || fetchErr != nil || page.pageData[100] != 0 {
+		test.Errorf("old log records redone on a reused page")
+	}
+}
+
+// the new pages at the end of the file never reached the disk, redo makes them again from the log
+func TestRecoverLostEndPages(test *testing.T) {
+	for _, lost := range []string{"truncated", "zeroed", "blank"} {
+		d := disktest.GetFileInit(test)
+		bfrPool := getTestPool(test, d, Options{})
+		pageIds := make([]int, 2)
+		for i := range pageIds {
+			page, _ := bfrPool.NewPage()
+			pageIds[i] = page.PageId
+		}
+		txn, _ := bfrPool.BeginTxn()
+		for _, pageId := range pageIds {
+			bfrPool.WritePageData(txn, pageId, 100, []byte{9})
+		}
+		bfrPool.CommitTxn(txn)
+		abandonTestPool(bfrPool)
+		bfrPool.diskMgr.Close()
+
+		switch lost {
+		case "truncated":
+			os.Truncate(d.DbFilePath, int64(pageIds[0]*constants.PageSize))
+		case "zeroed":
+			dbFile, _ := os.OpenFile(d.DbFilePath, os.O_RDWR, 0)
+			dbFile.WriteAt(make([]byte, constants.PageSize), int64(pageIds[0]*constants.PageSize))
+			dbFile.Close()
+		case "blank":
+			// the blank page AllocatePage writes, without the new page written over it
+			diskMgr, _ := diskmgr.GetDiskFileMgr(d)
+			diskMgr.WritePage(pageIds[0], make([]byte, constants.PageSize))
+			diskMgr.Close()
+		}
+
+		reopenedPool, initErr := InitBuffPoolMgr(d, Options{})
+		if initErr != nil {
+			test.Errorf("%s pages: recovery failed: %v", lost, initErr)
+			continue
+		}
+		closeTestPool(test, reopenedPool)
+		for _, pageId := range pageIds {
+			page, fetchErr := reopenedPool.FetchPage(pageId)
+			if fetchErr != nil || page.pageData[0] != 1 || page.pageData[100] != 9 {
+				test.Errorf("%s pages: page %d not made again from the log: %v", lost, pageId, fetchErr)
+			}
+		}
+	}
+}
+
+// the first write of a reused page is synced, else the free page left on disk hides the records of the new page from redo
+func TestNewPageSyncsReusedPage(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	d.SyncPolicy = diskmgr.SyncOnFlush
+	orderDiskMgr := &syncOrderDiskMgr{DiskFileMgr: disktest.GetDiskMgr(test, d)}
+	bfrPool := getTestPoolOn(test, orderDiskMgr, Options{})
+	oldPage, _ := bfrPool.NewPage()
+	oldPageId := oldPage.PageId
+	bfrPool.DeletePage(oldPageId)
+
+	orderDiskMgr.calls = nil
+	newPage, _ := bfrPool.NewPage()
+	writeAt := slices.Index(orderDiskMgr.calls, fmt.Sprintf("write %d", oldPageId))
+	if newPage.PageId != oldPageId || writeAt < 0 || !slices.Contains(orderDiskMgr.calls[writeAt:], "sync") {
+		test.Errorf("first write of a reused page not synced: %v", orderDiskMgr.calls)
+	}
+}
+
+// the crash came between taking a page off the free list and writing the new page, the free page keeps the records of the deleted page away
+func TestRecoverPoppedFreePage(test *testing.T) {
+	d := disktest.GetFileInit(test)
+	bfrPool := getTestPool(test, d, Options{})
+	oldPage, _ := bfrPool.NewPage()
+	oldPageId := oldPage.PageId
+	bfrPool.NewPage()
+	txn, _ := bfrPool.BeginTxn()
+	bfrPool.WritePageData(txn, oldPageId, 100, []byte{1})
+	bfrPool.CommitTxn(txn)
+	bfrPool.DeletePage(oldPageId)
+	bfrPool.diskMgr.AllocatePage()
+	abandonTestPool(bfrPool)
+	bfrPool.diskMgr.Close()
+
+	reopenedPool, initErr := InitBuffPoolMgr(d, Options{})
+	if initErr != nil {
+		test.Errorf("recovery with a page off the free list and not written failed: %v", initErr)
+		return
+	}
+	closeTestPool(test, reopenedPool)
+	if _, fetchErr := reopenedPool.FetchPage(oldPageId); !errors.Is(fetchErr, diskmgr.ErrPageFree) || reopenedPool.GetRecoveryReport().RedoneRecords != 0 {
+		test.Errorf("record of a deleted page redone on the free page: %v", fetchErr)
+	}
+}
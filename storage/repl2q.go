@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"github.com/rohithputha/HymStMgr/utils"
+)
+
+/*
+twoQ is the full 2Q of Johnson and Shasha: a page seen for the first time goes into the a1in fifo, where more accesses do not count.
+when it is evicted from a1in its pageId is kept in the a1out ghost fifo, and a page that comes back while in a1out goes into the am lru.
+a scan only goes through a1in, so it can not push the pages of am out.
+*/
+type twoQ struct {
+	a1in    *keyList    // frame indexes, fifo
+	am      *keyList    // frame indexes, lru
+	a1out   *keyList    // pageIds, fifo
+	empty   *keyList    // frame indexes without a page
+	pageIds map[int]int // frame index -> pageId
+	kin     int         // a1in is evicted from first once it is larger than kin
+	kout    int         // max size of a1out
+}
+
+// get2QReplPol sizes a1in at a quarter and a1out at half of the pool, the sizes the 2Q paper suggests
+func get2QReplPol(poolFrames int) ReplPol {
+	return &twoQ{
+		a1in:    getKeyList(),
+		am:      getKeyList(),
+		a1out:   getKeyList(),
+		empty:   getKeyList(),
+		pageIds: make(map[int]int),
+		kin:     max(1, poolFrames/4),
+		kout:    max(1, poolFrames/2),
+	}
+}
+
+func (q *twoQ) InitPage(pageIndex int, pageId int) {
+	if q.a1in.remove(pageIndex) {
+		q.a1out.pushBack(q.pageIds[pageIndex])
+		if q.a1out.len() > q.kout {
+			q.a1out.popFront()
+		}
+	}
+	q.am.remove(pageIndex)
+	q.empty.remove(pageIndex)
+	q.pageIds[pageIndex] = pageId
+
+	switch {
+	case pageId == 0:
+		q.empty.pushBack(pageIndex)
+	case q.a1out.remove(pageId):
+		q.am.pushBack(pageIndex)
+	default:
+		q.a1in.pushBack(pageIndex)
+	}
+}
+
+func (q *twoQ) AddPageTime(pageIndex int, timestamp int64) (err error) {
+	// accesses in a1in are taken as correlated, only am is kept in lru order
+	if q.am.contains(pageIndex) {
+		q.am.pushBack(pageIndex)
+	}
+	return nil
+}
+
+func (q *twoQ) SetEvictable(pageIndex int, evictable bool) {}
+
+func (q *twoQ) FindReplPage(timestamp int64, excludedPages utils.ISet[int]) (pageIndex int) {
+	if pageIndex = q.empty.oldest(excludedPages); pageIndex >= 0 {
+		return pageIndex
+	}
+	first, second := q.am, q.a1in
+	if q.a1in.len() > q.kin {
+		first, second = q.a1in, q.am
+	}
+	if pageIndex = first.oldest(excludedPages); pageIndex >= 0 {
+		return pageIndex
+	}
+	return second.oldest(excludedPages)
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"github.com/rohithputha/HymStMgr/utils"
+)
+
+/*
+arc is the adaptive replacement cache of Megiddo and Modha: t1 holds the pages seen once recently and t2 the pages seen at least twice.
+b1 and b2 are ghost lists with the pageIds last evicted from t1 and t2, a miss that hits a ghost list moves the target size p of t1:
+a hit in b1 means t1 was too small, a hit in b2 means t2 was. eviction takes from t1 while it is larger than p, else from t2.
+*/
+type arc struct {
+	t1, t2  *keyList     // frame indexes, lru
+	b1, b2  *keyList     // pageIds, lru
+	empty   *keyList     // frame indexes without a page
+	placed  map[int]bool // frames whose page was just placed, the pool adds the time of the access that loaded it next
+	pageIds map[int]int  // frame index -> pageId
+	p       int          // target size of t1
+	c       int          // number of frames
+}
+
+func getArcReplPol(poolFrames int) ReplPol {
+	return &arc{
+		t1:      getKeyList(),
+		t2:      getKeyList(),
+		b1:      getKeyList(),
+		b2:      getKeyList(),
+		empty:   getKeyList(),
+		placed:  make(map[int]bool),
+		pageIds: make(map[int]int),
+		c:       poolFrames,
+	}
+}
+
+func (a *arc) InitPage(pageIndex int, pageId int) {
+	// the old page of the frame was evicted, it is remembered in the ghost list of its list
+	if a.t1.remove(pageIndex) {
+		a.b1.pushBack(a.pageIds[pageIndex])
+	} else if a.t2.remove(pageIndex) {
+		a.b2.pushBack(a.pageIds[pageIndex])
+	}
+	a.empty.remove(pageIndex)
+	a.pageIds[pageIndex] = pageId
+	a.placed[pageIndex] = pageId != 0
+
+	switch {
+	case pageId == 0:
+		a.empty.pushBack(pageIndex)
+	case a.b1.contains(pageId):
+		a.p = min(a.c, a.p+max(1, a.b2.len()/a.b1.len()))
+		a.b1.remove(pageId)
+		a.t2.pushBack(pageIndex)
+	case a.b2.contains(pageId):
+		a.p = max(0, a.p-max(1, a.b1.len()/a.b2.len()))
+		a.b2.remove(pageId)
+		a.t2.pushBack(pageIndex)
+	default:
+		a.t1.pushBack(pageIndex)
+	}
+
+	// the ghost lists together with their lists never hold more than c and 2c pages
+	for a.b1.len() > 0 && a.t1.len()+a.b1.len() > a.c {
+		a.b1.popFront()
+	}
+	for a.b2.len() > 0 && a.t1.len()+a.t2.len()+a.b1.len()+a.b2.len() > 2*a.c {
+		a.b2.popFront()
+	}
+}
+
+func (a *arc) AddPageTime(pageIndex int, timestamp int64) (err error) {
+	if a.placed[pageIndex] {
+		a.placed[pageIndex] = false
+		return nil
+	}
+	if a.t1.remove(pageIndex) || a.t2.contains(pageIndex) {
+		a.t2.pushBack(pageIndex)
+	}
+	return nil
+}
+
+func (a *arc) SetEvictable(pageIndex int, evictable bool) {}
+
+func (a *arc) FindReplPage(timestamp int64, excludedPages utils.ISet[int]) (pageIndex int) {
+	if pageIndex = a.empty.oldest(excludedPages); pageIndex >= 0 {
+		return pageIndex
+	}
+	first, second := a.t2, a.t1
+	if a.t1.len() > 0 && a.t1.len() > a.p {
+		first, second = a.t1, a.t2
+	}
+	if pageIndex = first.oldest(excludedPages); pageIndex >= 0 {
+		return pageIndex
+	}
+	return second.oldest(excludedPages)
+}
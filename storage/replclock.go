@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"github.com/rohithputha/HymStMgr/utils"
+)
+
+/*
+clock keeps a reference bit per frame and a hand that sweeps over the frames in order: a frame with the bit set gets a second chance
+(the bit is cleared and the hand moves on), the first frame found with the bit clear is evicted.
+*/
+type clock struct {
+	frames  []int        // frame indexes in the order the hand visits them
+	slots   map[int]int  // frame index -> slot in frames
+	refBits map[int]bool // frame index -> referenced since the hand last passed it
+	hand    int
+}
+
+func getClockReplPol() ReplPol {
+	return &clock{slots: make(map[int]int), refBits: make(map[int]bool)}
+}
+
+func (c *clock) InitPage(pageIndex int, pageId int) {
+	if _, ok := c.slots[pageIndex]; !ok {
+		c.slots[pageIndex] = len(c.frames)
+		c.frames = append(c.frames, pageIndex)
+	}
+	c.refBits[pageIndex] = false
+}
+
+func (c *clock) AddPageTime(pageIndex int, timestamp int64) (err error) {
+	if _, ok := c.slots[pageIndex]; ok {
+		c.refBits[pageIndex] = true
+	}
+	return nil
+}
+
+func (c *clock) SetEvictable(pageIndex int, evictable bool) {}
+
+func (c *clock) FindReplPage(timestamp int64, excludedPages utils.ISet[int]) (pageIndex int) {
+	// two rounds are enough: the first one clears every bit it passes
+	for range 2 * len(c.frames) {
+		pageIndex = c.frames[c.hand]
+		c.hand = (c.hand + 1) % len(c.frames)
+		if excludedPages.Contains(pageIndex) {
+			continue
+		}
+		if c.refBits[pageIndex] {
+			c.refBits[pageIndex] = false
+			continue
+		}
+		return pageIndex
+	}
+	return -1
+}
@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"github.com/rohithputha/HymStMgr/utils"
+)
+
+// lru evicts the frame whose page was accessed least recently, empty frames go first
+type lru struct {
+	frames *keyList
+}
+
+func getLruReplPol() ReplPol {
+	return &lru{frames: getKeyList()}
+}
+
+func (l *lru) InitPage(pageIndex int, pageId int) {
+	// a frame with a new page has no accesses yet, it is the first one to go until it is accessed
+	l.frames.pushFront(pageIndex)
+}
+
+func (l *lru) AddPageTime(pageIndex int, timestamp int64) (err error) {
+	l.frames.pushBack(pageIndex)
+	return nil
+}
+
+func (l *lru) SetEvictable(pageIndex int, evictable bool) {}
+
+func (l *lru) FindReplPage(timestamp int64, excludedPages utils.ISet[int]) (pageIndex int) {
+	return l.frames.oldest(excludedPages)
+}
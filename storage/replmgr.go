@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"container/heap"
+	"container/list"
 	"errors"
 
 	"github.com/rohithputha/HymStMgr/utils"
@@ -12,81 +14,270 @@ import (
 // should setup
 // should it store info based on pageIndex or pageId? maybe go with pageIndex: will be easy on memory but not accurate I feel.
 
+/*
+ReplPol picks the frame to evict when the pool has no free frame left, pages are known by the index of the frame that holds them.
+the pool calls every method under its lock, a ReplPol does not need its own locking.
+*/
 type ReplPol interface {
-	initPageLruk(pageIndex int)
-	addPageTime(pageIndex int, timestamp int64) (err error)
-	findReplPage(timestamp int64, excludedPages utils.ISet[int]) (pageIndex int)
+	// InitPage tells the policy that the frame now holds pageId (0 for an empty frame), the history of the frame's old page is dropped
+	InitPage(pageIndex int, pageId int)
+	// AddPageTime records an access to the page in the frame
+	AddPageTime(pageIndex int, timestamp int64) (err error)
+	// SetEvictable tells the policy if the frame can be evicted, the pool calls it when the pin count of the frame goes from 0 to 1 and back.
+	// it lets a policy keep an index of the victims, policies that skip the excluded frames as they scan can ignore it
+	SetEvictable(pageIndex int, evictable bool)
+	// FindReplPage returns the frame to evict, never one in excludedPages or one set not evictable, or -1 if there is none
+	FindReplPage(timestamp int64, excludedPages utils.ISet[int]) (pageIndex int)
 }
 
+/*
+lruk evicts the page with the largest backward K-distance, the time since its K-th most recent reference.
+a page with fewer than K references has an infinite distance, ties between such pages go to the least recently used.
+references closer than corPeriod to the page's last one are correlated and count as one, a page still inside its
+correlated period is only evicted when every other page is too. timestamps come from the pool's logical clock.
+the evictable frames are kept in a heap ordered by their backward K-distance, so that a victim is found in O(log n).
+*/
 type lruk struct {
-	pageHistMap     map[int](utils.IQueue[int64])
-	pageLastTimeMap map[int]int64
+	pageHistMap     map[int](utils.IQueue[int64]) // the times of the last k uncorrelated references, oldest first
+	pageLastTimeMap map[int]int64                 // the time of the last reference, correlated or not
+	evictable       *lrukHeap
+	k               int   // number of references kept per page
+	corPeriod       int64 // correlated reference period
 }
 
-func getLrukReplPol() ReplPol {
+func getLrukReplPol(k int, corPeriod int64) ReplPol {
 	lruk := lruk{
 		pageHistMap:     make(map[int]utils.IQueue[int64]),
 		pageLastTimeMap: make(map[int]int64),
+		k:               k,
+		corPeriod:       corPeriod,
 	}
+	lruk.evictable = &lrukHeap{l: &lruk}
 	return &lruk
 }
 
-func (l *lruk) initPageLruk(pageIndex int) {
-	l.pageHistMap[pageIndex] = utils.GetNewQueue[int64](4)
+// InitPage drops the history of the frame, a frame the policy has not seen before starts out evictable
+func (l *lruk) InitPage(pageIndex int, pageId int) {
+	_, known := l.pageHistMap[pageIndex]
+	l.pageHistMap[pageIndex] = utils.GetNewQueue[int64](l.k)
 	l.pageLastTimeMap[pageIndex] = int64(0)
+	if !known {
+		l.SetEvictable(pageIndex, true)
+		return
+	}
+	l.evictable.fix(pageIndex)
 }
 
-func (l *lruk) addPageTime(pageIndex int, timestamp int64) (err error) {
-	// is 5000 correct? 5000 units of the time, what is the unit here?
-	// should there be a locking mechanism here?
-	if timestamp-l.pageLastTimeMap[pageIndex] > 500 {
-		timeQueue, ok := l.pageHistMap[pageIndex]
-		if !ok {
-			return errors.New("pageIndex does not exist")
-		}
-		recentTime, recentErr := timeQueue.GetLast() //here last is used to find the latest timestamp
-		if recentErr != nil {
-			timeQueue.ForcePush(timestamp)
-			l.pageLastTimeMap[pageIndex] = timestamp
-			return nil
-		}
-		corPeriod := l.pageLastTimeMap[pageIndex] - recentTime
-		for i := 0; i < timeQueue.GetSize(); i++ { // internal queue has this structure ->  (head,....., tail) (head -> 0 , tail -> len(q)-1) tail is the most recent element to be pushed
-			queueEle, queueGetErr := timeQueue.Get(i)
-			if queueGetErr != nil {
-				return queueGetErr
-			}
-			l.pageHistMap[pageIndex].Update(i, queueEle+corPeriod)
-		}
-		l.pageHistMap[pageIndex].ForcePush(timestamp)
+func (l *lruk) SetEvictable(pageIndex int, evictable bool) {
+	i := l.evictable.position(pageIndex)
+	if evictable && i < 0 {
+		heap.Push(l.evictable, pageIndex)
+	} else if !evictable && i >= 0 {
+		heap.Remove(l.evictable, i)
 	}
+}
+
+func (l *lruk) AddPageTime(pageIndex int, timestamp int64) (err error) {
+	timeQueue, ok := l.pageHistMap[pageIndex]
+	if !ok {
+		return errors.New("pageIndex does not exist")
+	}
+	defer l.evictable.fix(pageIndex)
+	lastTime := l.pageLastTimeMap[pageIndex]
 	l.pageLastTimeMap[pageIndex] = timestamp
+	if timeQueue.GetSize() == 0 {
+		timeQueue.ForcePush(timestamp)
+		return nil
+	}
+	if timestamp-lastTime <= l.corPeriod {
+		return nil
+	}
+	// a new uncorrelated reference: the older references move up by the length of the correlated period that just ended
+	recentTime, _ := timeQueue.GetLast()
+	corPeriod := lastTime - recentTime
+	for i := 0; i < timeQueue.GetSize(); i++ { // internal queue has this structure ->  (head,....., tail) (head -> 0 , tail -> len(q)-1) tail is the most recent element to be pushed
+		queueEle, queueGetErr := timeQueue.Get(i)
+		if queueGetErr != nil {
+			return queueGetErr
+		}
+		timeQueue.Update(i, queueEle+corPeriod)
+	}
+	timeQueue.ForcePush(timestamp)
 	return nil
 }
 
-func (l *lruk) findReplPage(timestamp int64, excludedPages utils.ISet[int]) (pageIndex int) {
-	minTime := timestamp
+/*
+FindReplPage pops the heap till it finds a frame that is not excluded and out of its correlated period, and pushes the popped frames back.
+only the frames referenced in the last corPeriod ticks and the excluded ones are popped on top of the victim, the pool excludes the
+pinned frames and those are not in the heap, so with a small period a victim costs O(log n).
+if every frame is in its correlated period the first one popped is the victim.
+*/
+func (l *lruk) FindReplPage(timestamp int64, excludedPages utils.ISet[int]) (pageIndex int) {
 	victim := -1
-	for pageIndex, timeQueue := range l.pageHistMap {
+	popped := []int{}
+	for l.evictable.Len() > 0 {
+		pageIndex := heap.Pop(l.evictable).(int)
+		popped = append(popped, pageIndex)
 		if excludedPages.Contains(pageIndex) {
 			continue
 		}
-		kQueueEle, queueGetErr := timeQueue.GetFirst()
-		if queueGetErr != nil {
-			continue
+		if victim == -1 {
+			victim = pageIndex
 		}
-		if timestamp-l.pageLastTimeMap[pageIndex] > 500 && kQueueEle < minTime {
+		if timestamp-l.pageLastTimeMap[pageIndex] > l.corPeriod {
 			victim = pageIndex
-			minTime = kQueueEle
+			break
 		}
 	}
-	if victim == -1 {
-		for pageIndex, _ := range l.pageHistMap {
-			if excludedPages.Contains(pageIndex) {
-				continue
-			}
-			return pageIndex // not a very efficient way to return the pageIndex but increases prob of finding a repl page. need to test the real impact later.
-		}
+	for _, pageIndex := range popped {
+		heap.Push(l.evictable, pageIndex)
 	}
 	return victim
 }
+
+// heapEntry works out the eviction key of the frame: the infinite distances first, then the oldest k-th reference,
+// the lru breaks the ties and the frame index the last ones, so that the victim does not depend on map order
+func (l *lruk) heapEntry(pageIndex int) lrukHeapEntry {
+	entry := lrukHeapEntry{pageIndex: pageIndex, lastTime: l.pageLastTimeMap[pageIndex]}
+	if timeQueue := l.pageHistMap[pageIndex]; timeQueue.GetSize() >= l.k {
+		entry.finite = true
+		entry.kTime, _ = timeQueue.GetFirst()
+	}
+	return entry
+}
+
+/*
+lrukHeap is a heap.Interface over the evictable frames of a lruk, the next victim is on top.
+every entry keeps a copy of the eviction key of its frame, fix refreshes it after the history of the frame changed.
+*/
+type lrukHeap struct {
+	l         *lruk
+	entries   []lrukHeapEntry
+	heapIndex []int // frame index -> position in entries, -1 for a frame that is not evictable
+}
+
+type lrukHeapEntry struct {
+	pageIndex int
+	finite    bool  // the frame has k references
+	kTime     int64 // time of the k-th most recent reference
+	lastTime  int64
+}
+
+func (h *lrukHeap) Len() int {
+	return len(h.entries)
+}
+
+// Less puts the next victim first, see heapEntry for the order
+func (h *lrukHeap) Less(i, j int) bool {
+	a, b := &h.entries[i], &h.entries[j]
+	if a.finite != b.finite {
+		return !a.finite
+	}
+	if a.kTime != b.kTime {
+		return a.kTime < b.kTime
+	}
+	if a.lastTime != b.lastTime {
+		return a.lastTime < b.lastTime
+	}
+	return a.pageIndex < b.pageIndex
+}
+
+func (h *lrukHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.heapIndex[h.entries[i].pageIndex] = i
+	h.heapIndex[h.entries[j].pageIndex] = j
+}
+
+func (h *lrukHeap) Push(x any) {
+	pageIndex := x.(int)
+	for len(h.heapIndex) <= pageIndex {
+		h.heapIndex = append(h.heapIndex, -1)
+	}
+	h.heapIndex[pageIndex] = len(h.entries)
+	h.entries = append(h.entries, h.l.heapEntry(pageIndex))
+}
+
+func (h *lrukHeap) Pop() any {
+	pageIndex := h.entries[len(h.entries)-1].pageIndex
+	h.entries = h.entries[:len(h.entries)-1]
+	h.heapIndex[pageIndex] = -1
+	return pageIndex
+}
+
+// position returns where the frame is in the heap, or -1
+func (h *lrukHeap) position(pageIndex int) int {
+	if pageIndex < len(h.heapIndex) {
+		return h.heapIndex[pageIndex]
+	}
+	return -1
+}
+
+// fix moves the frame to its place after its history changed, frames that are not evictable are not in the heap
+func (h *lrukHeap) fix(pageIndex int) {
+	if i := h.position(pageIndex); i >= 0 {
+		h.entries[i] = h.l.heapEntry(pageIndex)
+		heap.Fix(h, i)
+	}
+}
+
+// keyList is a list of frame indexes or pageIds in recency order (front is the oldest), with O(1) lookup of a key
+type keyList struct {
+	order *list.List
+	elems map[int]*list.Element
+}
+
+func getKeyList() *keyList {
+	return &keyList{order: list.New(), elems: make(map[int]*list.Element)}
+}
+
+func (kl *keyList) contains(key int) bool {
+	_, ok := kl.elems[key]
+	return ok
+}
+
+func (kl *keyList) len() int {
+	return kl.order.Len()
+}
+
+// pushBack adds the key as the newest, or moves it there if it is already in the list
+func (kl *keyList) pushBack(key int) {
+	if elem, ok := kl.elems[key]; ok {
+		kl.order.MoveToBack(elem)
+		return
+	}
+	kl.elems[key] = kl.order.PushBack(key)
+}
+
+// pushFront adds the key as the oldest, or moves it there if it is already in the list
+func (kl *keyList) pushFront(key int) {
+	if elem, ok := kl.elems[key]; ok {
+		kl.order.MoveToFront(elem)
+		return
+	}
+	kl.elems[key] = kl.order.PushFront(key)
+}
+
+func (kl *keyList) remove(key int) bool {
+	elem, ok := kl.elems[key]
+	if ok {
+		kl.order.Remove(elem)
+		delete(kl.elems, key)
+	}
+	return ok
+}
+
+func (kl *keyList) popFront() (key int) {
+	key = kl.order.Front().Value.(int)
+	kl.remove(key)
+	return key
+}
+
+// oldest returns the oldest key that is not excluded, or -1
+func (kl *keyList) oldest(excluded utils.ISet[int]) int {
+	for elem := kl.order.Front(); elem != nil; elem = elem.Next() {
+		if key := elem.Value.(int); !excluded.Contains(key) {
+			return key
+		}
+	}
+	return -1
+}
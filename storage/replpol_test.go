@@ -1,9 +1,13 @@
 package storage
 
 import (
+	"math/rand"
+	"slices"
 	"testing"
 	"time"
 
+	"github.com/rohithputha/HymStMgr/constants"
+	"github.com/rohithputha/HymStMgr/diskmgr/disktest"
 	"github.com/rohithputha/HymStMgr/utils"
 )
 
@@ -15,11 +19,8 @@ func TestLrukReplPolInit(test *testing.T) {
 }
 
 func TestLrukReplPolPageInit(test *testing.T) {
-	lruk := lruk{
-		pageHistMap:     make(map[int]utils.IQueue[int64]),
-		pageLastTimeMap: make(map[int]int64),
-	}
-	lruk.initPageLruk(0)
+	lruk := getLrukReplPol(constants.LrukK, constants.LrukCorrelatedPeriod).(*lruk)
+	lruk.InitPage(0, 0)
 	if len(lruk.pageHistMap) != 1 {
 		test.Errorf("lruk page init not working as expected")
 	}
@@ -29,27 +30,21 @@ func TestLrukReplPolPageInit(test *testing.T) {
 }
 
 func TestLrukAddTime(test *testing.T) {
-	lruk := lruk{
-		pageHistMap:     make(map[int]utils.IQueue[int64]),
-		pageLastTimeMap: make(map[int]int64),
-	}
-	lruk.initPageLruk(0)
+	lruk := getLrukReplPol(constants.LrukK, constants.LrukCorrelatedPeriod).(*lruk)
+	lruk.InitPage(0, 0)
 
-	lruk.addPageTime(0, time.Now().Unix())
+	lruk.AddPageTime(0, time.Now().Unix())
 	if lruk.pageHistMap[0].GetSize() != 1 {
 		test.Errorf("lruk add first time not working as expected")
 	}
 }
 
 func TestLrukAddTimeMulti(test *testing.T) {
-	lruk := lruk{
-		pageHistMap:     make(map[int]utils.IQueue[int64]),
-		pageLastTimeMap: make(map[int]int64),
-	}
-	lruk.initPageLruk(0)
-	lruk.addPageTime(0, time.Now().UnixNano()/int64(time.Millisecond))
+	lruk := getLrukReplPol(constants.LrukK, 500).(*lruk) // ms
+	lruk.InitPage(0, 0)
+	lruk.AddPageTime(0, time.Now().UnixNano()/int64(time.Millisecond))
 	time.Sleep(20 * time.Millisecond)
-	lruk.addPageTime(0, time.Now().UnixNano()/int64(time.Millisecond))
+	lruk.AddPageTime(0, time.Now().UnixNano()/int64(time.Millisecond))
 
 	if histT, _ := lruk.pageHistMap[0].GetLast(); lruk.pageLastTimeMap[0]-histT < 20 && lruk.pageHistMap[0].GetSize() != 1 {
 		test.Errorf("lruk add multiple times to a page with same corr ref range not working")
@@ -57,16 +52,13 @@ func TestLrukAddTimeMulti(test *testing.T) {
 }
 
 func TestLrukAddTimeMultiCorrPeriod(test *testing.T) {
-	lruk := lruk{
-		pageHistMap:     make(map[int]utils.IQueue[int64]),
-		pageLastTimeMap: make(map[int]int64),
-	}
-	lruk.initPageLruk(0)
-	lruk.addPageTime(0, time.Now().UnixNano()/int64(time.Millisecond))
+	lruk := getLrukReplPol(constants.LrukK, 500).(*lruk) // ms
+	lruk.InitPage(0, 0)
+	lruk.AddPageTime(0, time.Now().UnixNano()/int64(time.Millisecond))
 	time.Sleep(20 * time.Millisecond)
-	lruk.addPageTime(0, time.Now().UnixNano()/int64(time.Millisecond))
+	lruk.AddPageTime(0, time.Now().UnixNano()/int64(time.Millisecond))
 	time.Sleep(501 * time.Millisecond)
-	lruk.addPageTime(0, time.Now().UnixNano()/int64(time.Millisecond))
+	lruk.AddPageTime(0, time.Now().UnixNano()/int64(time.Millisecond))
 
 	if histT, _ := lruk.pageHistMap[0].GetLast(); lruk.pageLastTimeMap[0]-histT != 0 && lruk.pageHistMap[0].GetSize() != 2 {
 		test.Errorf("lruk add multiple times to a page with different corr ref range not working")
@@ -77,24 +69,418 @@ func TestLrukAddTimeMultiCorrPeriod(test *testing.T) {
 }
 
 func TestLrukFindReplPage(test *testing.T) {
-	lruk := lruk{
-		pageHistMap:     make(map[int]utils.IQueue[int64]),
-		pageLastTimeMap: make(map[int]int64),
-	}
-	lruk.initPageLruk(0)
-	lruk.initPageLruk(1)
-	lruk.pageHistMap[0].ForcePush(1000)
-	lruk.pageHistMap[0].ForcePush(1510)
-	lruk.pageHistMap[0].ForcePush(2520)
-	lruk.pageHistMap[1].ForcePush(980)
-	lruk.pageHistMap[1].ForcePush(1505)
-	lruk.pageHistMap[1].ForcePush(2510)
-	lruk.pageLastTimeMap[0] = 2520
-	lruk.pageLastTimeMap[1] = 2510
-
-	replPageIndex := lruk.findReplPage(3530, utils.GetNewSet[int]())
+	lruk := getLrukReplPol(3, 500)
+	lruk.InitPage(0, 0)
+	lruk.InitPage(1, 0)
+	// the history goes in through AddPageTime, so that the heap of the evictable frames sees it
+	for _, timestamp := range []int64{1000, 1510, 2520} {
+		lruk.AddPageTime(0, timestamp)
+	}
+	for _, timestamp := range []int64{980, 1505, 2510} {
+		lruk.AddPageTime(1, timestamp)
+	}
+
+	replPageIndex := lruk.FindReplPage(3530, utils.GetNewSet[int]())
 	if replPageIndex != 1 {
 		test.Errorf("lruk find repl page not working as expected")
 	}
+}
+
+// the conformance tests below run against every policy
+var replPolsUnderTest = []struct {
+	name       string
+	newReplPol func(poolFrames int) ReplPol
+}{
+	{"lruk", func(poolFrames int) ReplPol { return getLrukReplPol(constants.LrukK, constants.LrukCorrelatedPeriod) }},
+	{"lru", func(poolFrames int) ReplPol { return getLruReplPol() }},
+	{"clock", func(poolFrames int) ReplPol { return getClockReplPol() }},
+	{"2q", get2QReplPol},
+	{"arc", getArcReplPol},
+}
+
+// replPolSim drives a policy the way the pool does: empty frames are used first, then the policy picks the victim
+type replPolSim struct {
+	pol       ReplPol
+	framePage []int       // frame index -> pageId, 0 for an empty frame
+	pageFrame map[int]int // pageId -> frame index
+	pinned    utils.ISet[int]
+	filled    int // frames are filled in order, the frames from filled on are empty
+	timestamp int64
+	misses    int
+}
+
+func getReplPolSim(newReplPol func(poolFrames int) ReplPol, poolFrames int) *replPolSim {
+	sim := &replPolSim{pol: newReplPol(poolFrames), framePage: make([]int, poolFrames), pageFrame: make(map[int]int), pinned: utils.GetNewSet[int]()}
+	for i := range poolFrames {
+		sim.pol.InitPage(i, 0)
+	}
+	return sim
+}
+
+func (sim *replPolSim) access(pageId int) {
+	sim.timestamp++
+	if pageIndex, ok := sim.pageFrame[pageId]; ok {
+		sim.pol.AddPageTime(pageIndex, sim.timestamp)
+		return
+	}
+	sim.misses++
+	pageIndex := sim.filled
+	if sim.filled < len(sim.framePage) {
+		sim.filled++
+	} else {
+		pageIndex = sim.pol.FindReplPage(sim.timestamp, sim.pinned)
+		delete(sim.pageFrame, sim.framePage[pageIndex])
+	}
+	sim.framePage[pageIndex] = pageId
+	sim.pageFrame[pageId] = pageIndex
+	sim.pol.InitPage(pageIndex, pageId)
+	sim.pol.AddPageTime(pageIndex, sim.timestamp)
+}
+
+// setPinned pins or unpins the frame the way the pool does, pinned frames are excluded and not evictable
+func (sim *replPolSim) setPinned(pageIndex int, pinned bool) {
+	if pinned {
+		sim.pinned.Add(pageIndex)
+	} else {
+		sim.pinned.Delete(pageIndex)
+	}
+	sim.pol.SetEvictable(pageIndex, !pinned)
+}
+
+func TestReplPolExcludedPages(test *testing.T) {
+	for _, replPol := range replPolsUnderTest {
+		sim := getReplPolSim(replPol.newReplPol, 4)
+		for pageId := 1; pageId <= 4; pageId++ {
+			sim.access(pageId)
+		}
+		excluded := utils.GetNewSet[int]()
+		for i := range 4 {
+			excluded.Add(i)
+		}
+		if pageIndex := sim.pol.FindReplPage(sim.timestamp, excluded); pageIndex != -1 {
+			test.Errorf("%s: find repl page with every frame excluded returned %d", replPol.name, pageIndex)
+		}
+		excluded.Delete(2)
+		if pageIndex := sim.pol.FindReplPage(sim.timestamp, excluded); pageIndex != 2 {
+			test.Errorf("%s: find repl page with one frame not excluded returned %d", replPol.name, pageIndex)
+		}
+	}
+}
+
+func TestReplPolEvictsColdPage(test *testing.T) {
+	for _, replPol := range replPolsUnderTest {
+		sim := getReplPolSim(replPol.newReplPol, 4)
+		for _, pageId := range []int{1, 2, 3, 4, 2, 3, 4} {
+			sim.access(pageId)
+		}
+		if pageIndex := sim.pol.FindReplPage(sim.timestamp, utils.GetNewSet[int]()); pageIndex != sim.pageFrame[1] {
+			test.Errorf("%s: the page accessed once and least recently is not the victim", replPol.name)
+		}
+	}
+}
+
+func TestReplPolWorkingSetFits(test *testing.T) {
+	for _, replPol := range replPolsUnderTest {
+		sim := getReplPolSim(replPol.newReplPol, 8)
+		for i := range 200 {
+			sim.access(1 + i%8)
+		}
+		if sim.misses != 8 {
+			test.Errorf("%s: %d misses on a working set that fits the pool", replPol.name, sim.misses)
+		}
+	}
+}
+
+func TestReplPolRandomOps(test *testing.T) {
+	const poolFrames = 16
+	for _, replPol := range replPolsUnderTest {
+		rng := rand.New(rand.NewSource(1))
+		sim := getReplPolSim(replPol.newReplPol, poolFrames)
+		for range 2000 {
+			sim.access(1 + rng.Intn(3*poolFrames))
+			excluded := utils.GetNewSet[int]()
+			for range rng.Intn(poolFrames + 1) {
+				excluded.Add(rng.Intn(poolFrames))
+			}
+			pageIndex := sim.pol.FindReplPage(sim.timestamp, excluded)
+			if excluded.GetSize() == poolFrames && pageIndex != -1 || excluded.GetSize() < poolFrames && (pageIndex < 0 || pageIndex >= poolFrames || excluded.Contains(pageIndex)) {
+				test.Errorf("%s: find repl page returned %d with %d of %d frames excluded", replPol.name, pageIndex, excluded.GetSize(), poolFrames)
+				break
+			}
+		}
+	}
+}
+
+// hot pages accessed twice between scans of pages that are seen only once should stay cached with 2Q and ARC, but not with LRU
+func TestReplPolScanResistance(test *testing.T) {
+	hotMisses := func(newReplPol func(poolFrames int) ReplPol) int {
+		sim := getReplPolSim(newReplPol, 8)
+		misses, scanPageId := 0, 100
+		for round := range 50 {
+			for _, hotPageId := range []int{1, 2, 1, 2} {
+				before := sim.misses
+				sim.access(hotPageId)
+				if round >= 5 {
+					misses += sim.misses - before
+				}
+			}
+			for range 8 {
+				sim.access(scanPageId)
+				scanPageId++
+			}
+		}
+		return misses
+	}
+	lruMisses := hotMisses(func(poolFrames int) ReplPol { return getLruReplPol() })
+	if twoQMisses := hotMisses(get2QReplPol); twoQMisses != 0 || lruMisses == 0 {
+		test.Errorf("2q is not scan resistant: %d hot misses, lru %d", twoQMisses, lruMisses)
+	}
+	if arcMisses := hotMisses(getArcReplPol); arcMisses != 0 {
+		test.Errorf("arc is not scan resistant: %d hot misses, lru %d", arcMisses, lruMisses)
+	}
+}
+
+func TestClockSecondChance(test *testing.T) {
+	sim := getReplPolSim(func(poolFrames int) ReplPol { return getClockReplPol() }, 3)
+	for _, pageId := range []int{1, 2, 3} {
+		sim.access(pageId)
+	}
+	// the first sweep clears every bit, then page 1 is evicted; page 2 is referenced again and skipped on the next sweep
+	sim.access(4)
+	sim.access(2)
+	sim.access(5)
+	if _, ok := sim.pageFrame[2]; !ok {
+		test.Errorf("clock evicted a page that was referenced since the hand passed it")
+	}
+	if _, ok := sim.pageFrame[3]; ok {
+		test.Errorf("clock did not evict the page without its reference bit")
+	}
+}
 
+func TestArcAdaptsTarget(test *testing.T) {
+	sim := getReplPolSim(getArcReplPol, 4)
+	for _, pageId := range []int{1, 2, 1, 2, 3, 4, 5} {
+		sim.access(pageId)
+	}
+	// page 3 was evicted from t1 into b1, a miss on it grows the target size of t1 and brings it into t2
+	sim.access(3)
+	if arcPol := sim.pol.(*arc); arcPol.p != 1 || !arcPol.t2.contains(sim.pageFrame[3]) {
+		test.Errorf("arc hit in b1 not working as expected: p %d", arcPol.p)
+	}
+}
+
+func TestLrukVictimOrder(test *testing.T) {
+	lruk := getLrukReplPol(2, 0)
+	for i := range 4 {
+		lruk.InitPage(i, i+1)
+	}
+	for timestamp, pageIndex := range []int{0, 1, 2, 3, 0, 1, 0} {
+		lruk.AddPageTime(pageIndex, int64(timestamp+1))
+	}
+	// frames 2 and 3 have one reference so their distance is infinite and the lru of them goes first, then the oldest 2nd reference
+	excluded := utils.GetNewSet[int]()
+	for _, want := range []int{2, 3, 1, 0} {
+		if victim := lruk.FindReplPage(8, excluded); victim != want {
+			test.Errorf("lruk victim %d, expected %d", victim, want)
+		}
+		excluded.Add(want)
+	}
+}
+
+func TestLrukCorrelatedPeriod(test *testing.T) {
+	lruk := getLrukReplPol(2, 2).(*lruk)
+	lruk.InitPage(0, 1)
+	lruk.InitPage(1, 2)
+	lruk.AddPageTime(0, 1)
+	lruk.AddPageTime(0, 2) // correlated with the first reference
+	lruk.AddPageTime(0, 5)
+	lruk.AddPageTime(1, 6)
+	if kTime, _ := lruk.pageHistMap[0].GetFirst(); kTime != 2 || lruk.pageHistMap[0].GetSize() != 2 {
+		test.Errorf("lruk history after a correlated period not working as expected")
+	}
+	// both pages are in their correlated period, so the infinite distance goes first
+	if victim := lruk.FindReplPage(7, utils.GetNewSet[int]()); victim != 1 {
+		test.Errorf("lruk victim with every page correlated not working as expected")
+	}
+	// frame 0 is out of its correlated period and frame 1 is not
+	if victim := lruk.FindReplPage(8, utils.GetNewSet[int]()); victim != 0 {
+		test.Errorf("lruk evicts a page inside its correlated period")
+	}
+}
+
+/*
+lrukModel is the reference model of lru-k for the trace test: it keeps every reference of a page and works the
+history out from the bursts of correlated references, the way the lru-k paper defines it.
+*/
+type lrukModel struct {
+	k         int
+	corPeriod int64
+	refs      map[int][]int64
+}
+
+func (m *lrukModel) InitPage(pageIndex int, pageId int) {
+	m.refs[pageIndex] = nil
+}
+
+func (m *lrukModel) AddPageTime(pageIndex int, timestamp int64) (err error) {
+	m.refs[pageIndex] = append(m.refs[pageIndex], timestamp)
+	return nil
+}
+
+// hist returns the times of the uncorrelated references, most recent first: a burst of correlated references counts
+// from its start, moved up by the length of every burst after it but the last
+func (m *lrukModel) hist(pageIndex int) []int64 {
+	refs := m.refs[pageIndex]
+	var starts, lengths []int64
+	for i, ref := range refs {
+		if i > 0 && ref-refs[i-1] <= m.corPeriod {
+			lengths[len(lengths)-1] = ref - starts[len(starts)-1]
+			continue
+		}
+		starts = append(starts, ref)
+		lengths = append(lengths, 0)
+	}
+	hist := []int64{}
+	for j := len(starts) - 1; j >= 0; j-- {
+		histTime := starts[j]
+		for later := j; later < len(starts)-1; later++ {
+			histTime += lengths[later]
+		}
+		hist = append(hist, histTime)
+	}
+	return hist
+}
+
+func (m *lrukModel) SetEvictable(pageIndex int, evictable bool) {}
+
+func (m *lrukModel) FindReplPage(timestamp int64, excludedPages utils.ISet[int]) (pageIndex int) {
+	// sort key: (correlated, finite, k-th time, last time, frame index)
+	key := func(pageIndex int) []int64 {
+		refs, hist := m.refs[pageIndex], m.hist(pageIndex)
+		last, correlated, finite, kTime := int64(0), int64(0), int64(0), int64(0)
+		if len(refs) > 0 {
+			last = refs[len(refs)-1]
+		}
+		if timestamp-last <= m.corPeriod {
+			correlated = 1
+		}
+		if len(hist) >= m.k {
+			finite, kTime = 1, hist[m.k-1]
+		}
+		return []int64{correlated, finite, kTime, last, int64(pageIndex)}
+	}
+	victim := -1
+	for pageIndex := range m.refs {
+		if !excludedPages.Contains(pageIndex) && (victim == -1 || slices.Compare(key(pageIndex), key(victim)) < 0) {
+			victim = pageIndex
+		}
+	}
+	return victim
+}
+
+func TestLrukTraceMatchesModel(test *testing.T) {
+	const poolFrames = 8
+	for _, params := range []struct {
+		k         int
+		corPeriod int64
+	}{{1, 0}, {2, 0}, {2, 3}, {3, 5}} {
+		rng := rand.New(rand.NewSource(int64(params.k)*100 + params.corPeriod))
+		lrukSim := getReplPolSim(func(poolFrames int) ReplPol { return getLrukReplPol(params.k, params.corPeriod) }, poolFrames)
+		modelSim := getReplPolSim(func(poolFrames int) ReplPol {
+			return &lrukModel{k: params.k, corPeriod: params.corPeriod, refs: make(map[int][]int64)}
+		}, poolFrames)
+		for i := range 5000 {
+			// a few hot pages, some repeats right after each other and a long tail
+			pageId := 1 + rng.Intn(4)
+			if rng.Intn(3) == 0 {
+				pageId = 1 + rng.Intn(40)
+			}
+			lrukSim.access(pageId)
+			modelSim.access(pageId)
+			if rng.Intn(4) == 0 {
+				lrukSim.access(pageId)
+				modelSim.access(pageId)
+			}
+			if pageIndex := rng.Intn(poolFrames); lrukSim.pinned.GetSize() < 3 || lrukSim.pinned.Contains(pageIndex) {
+				pinned := !lrukSim.pinned.Contains(pageIndex)
+				lrukSim.setPinned(pageIndex, pinned)
+				modelSim.setPinned(pageIndex, pinned)
+			}
+			excluded, modelExcluded := utils.GetNewSet[int](), utils.GetNewSet[int]()
+			for range rng.Intn(3) {
+				pageIndex := rng.Intn(poolFrames)
+				excluded.Add(pageIndex)
+				modelExcluded.Add(pageIndex)
+			}
+			for pageIndex := range poolFrames {
+				if lrukSim.pinned.Contains(pageIndex) {
+					modelExcluded.Add(pageIndex)
+				}
+			}
+			// lruk is only told of the pinned frames through SetEvictable
+			victim := lrukSim.pol.FindReplPage(lrukSim.timestamp+1, excluded)
+			if modelVictim := modelSim.pol.FindReplPage(modelSim.timestamp+1, modelExcluded); victim != modelVictim || !slices.Equal(lrukSim.framePage, modelSim.framePage) {
+				test.Errorf("k %d, period %d: lruk victim %d but the model picks %d at access %d", params.k, params.corPeriod, victim, modelVictim, i)
+				break
+			}
+		}
+	}
+}
+
+func TestLrukSetEvictable(test *testing.T) {
+	sim := getReplPolSim(func(poolFrames int) ReplPol { return getLrukReplPol(2, 0) }, 3)
+	for _, pageId := range []int{1, 2, 3} {
+		sim.access(pageId)
+	}
+	noneExcluded := utils.GetNewSet[int]()
+	sim.pol.SetEvictable(0, false)
+	if victim := sim.pol.FindReplPage(sim.timestamp, noneExcluded); victim != 1 {
+		test.Errorf("lruk evicts a frame that is not evictable")
+	}
+	sim.pol.SetEvictable(1, false)
+	sim.pol.SetEvictable(2, false)
+	sim.pol.SetEvictable(2, false)
+	if victim := sim.pol.FindReplPage(sim.timestamp, noneExcluded); victim != -1 {
+		test.Errorf("lruk find repl page with no evictable frame returned %d", victim)
+	}
+	sim.pol.SetEvictable(0, true)
+	sim.pol.SetEvictable(0, true)
+	if victim := sim.pol.FindReplPage(sim.timestamp, noneExcluded); victim != 0 || sim.pol.(*lruk).evictable.Len() != 1 {
+		test.Errorf("lruk set evictable not working as expected")
+	}
+}
+
+func TestLrukEvictableFollowsPins(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{PoolFrames: 4})
+	lruk := bfrPool.replPol.(*lruk)
+	guard, _ := bfrPool.NewPageWrite()
+	pageIndex := bfrPool.pageMap[guard.PageId()]
+	if lruk.evictable.position(pageIndex) >= 0 || lruk.evictable.Len() != 3 {
+		test.Errorf("pinned frame is evictable in lruk")
+	}
+	guard.Release()
+	if lruk.evictable.position(pageIndex) < 0 || lruk.evictable.Len() != 4 {
+		test.Errorf("unpinned frame is not evictable in lruk")
+	}
+}
+
+// BenchmarkReplPolFindReplPage evicts and reloads one frame per op in a pool of 100k frames
+func BenchmarkReplPolFindReplPage(b *testing.B) {
+	const poolFrames = 100_000
+	for _, replPol := range replPolsUnderTest {
+		b.Run(replPol.name, func(b *testing.B) {
+			sim := getReplPolSim(replPol.newReplPol, poolFrames)
+			rng := rand.New(rand.NewSource(1))
+			for pageId := 1; pageId <= poolFrames; pageId++ {
+				sim.access(pageId)
+				if rng.Intn(2) == 0 {
+					sim.access(pageId)
+				}
+			}
+			b.ResetTimer()
+			for i := range b.N {
+				sim.access(poolFrames + 1 + i)
+			}
+		})
+	}
 }
@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/rohithputha/HymStMgr/constants"
+	"github.com/rohithputha/HymStMgr/logmgr"
+)
+
+type TxnState int
+
+const (
+	TxnActive TxnState = iota
+	TxnCommitted
+	TxnAborted
+)
+
+/*
+Txn is a transaction that changes pages through the buffer pool.
+every change is logged (physiological: page id, offset and the before/after bytes) before it is applied to the page.
+the update records are also kept in memory so that a rollback does not have to read the log back.
+*/
+type Txn struct {
+	TxnId      int64
+	State      TxnState
+	firstLsn   int64
+	prevLsn    int64
+	commitLsn  int64 // lsn of the commit record once it is appended, guarded by txnMux
+	updateRecs []*logmgr.LogRecord
+}
+
+func (bp *BuffPoolMgrStr) BeginTxn() (txn *Txn, beginErr error) {
+	bp.txnMux.Lock()
+	defer bp.txnMux.Unlock()
+
+	if bp.closed.Load() {
+		return nil, ErrClosed
+	}
+	txn = &Txn{TxnId: bp.nextTxnId, State: TxnActive}
+	lsn, beginErr := bp.logMgr.AppendLogRecord(&logmgr.LogRecord{RecType: logmgr.LogBegin, TxnId: txn.TxnId})
+	if beginErr != nil {
+		return nil, beginErr
+	}
+	bp.nextTxnId++
+	txn.firstLsn = lsn
+	txn.prevLsn = lsn
+	bp.activeTxns[txn.TxnId] = txn
+	return txn, nil
+}
+
+/*
+WritePageData writes data at the offset of the page for the txn.
+the page header is reserved for the pool, so the offset should be past constants.PageHeaderSize.
+the update record is appended before the page is changed and the page lsn is moved to the record's lsn.
+*/
+func (bp *BuffPoolMgrStr) WritePageData(txn *Txn, pageId int, offset int, data []byte) (writeErr error) {
+	if bp.closed.Load() {
+		return ErrClosed
+	}
+	if txn.State != TxnActive {
+		return fmt.Errorf("%w: txn %d", ErrTxnNotActive, txn.TxnId)
+	}
+	if offset < constants.PageHeaderSize || offset+len(data) > bp.pageSize {
+		return fmt.Errorf("%w: %d bytes at offset %d, the page data is from %d to %d", ErrOutOfRange, len(data), offset, constants.PageHeaderSize, bp.pageSize)
+	}
+	page, fetchErr := bp.fetchPage(pageId, true, nil)
+	if fetchErr != nil {
+		return fetchErr
+	}
+	defer bp.UnpinPage(pageId)
+	page.pageMux.Lock()
+	defer page.pageMux.Unlock()
+
+	updateRec := &logmgr.LogRecord{
+		RecType:     logmgr.LogUpdate,
+		TxnId:       txn.TxnId,
+		PrevLsn:     txn.prevLsn,
+		PageId:      pageId,
+		Offset:      offset,
+		BeforeImage: append([]byte(nil), page.pageData[offset:offset+len(data)]...),
+		AfterImage:  append([]byte(nil), data...),
+	}
+	page.beginDirty(bp.logMgr.GetLastLsn() + 1)
+	lsn, appendErr := bp.logMgr.AppendLogRecord(updateRec)
+	if appendErr != nil {
+		return appendErr
+	}
+	copy(page.pageData[offset:], data)
+	page.markDirty(lsn)
+	bp.setTxnLsn(txn, lsn)
+	txn.updateRecs = append(txn.updateRecs, updateRec)
+	return nil
+}
+
+// CommitTxn returns only after the commit record is durable in the log
+func (bp *BuffPoolMgrStr) CommitTxn(txn *Txn) (commitErr error) {
+	if bp.closed.Load() {
+		return ErrClosed
+	}
+	if txn.State != TxnActive {
+		return fmt.Errorf("%w: txn %d", ErrTxnNotActive, txn.TxnId)
+	}
+	commitLsn, commitErr := bp.logMgr.AppendLogRecord(&logmgr.LogRecord{RecType: logmgr.LogCommit, TxnId: txn.TxnId, PrevLsn: txn.prevLsn})
+	if commitErr != nil {
+		return commitErr
+	}
+	// a checkpoint from here on has to count the txn as committed, its begin record can be after the commit record
+	bp.txnMux.Lock()
+	txn.prevLsn = commitLsn
+	txn.commitLsn = commitLsn
+	bp.txnMux.Unlock()
+	if commitErr = bp.logMgr.Flush(commitLsn); commitErr != nil {
+		return commitErr
+	}
+	bp.txnMux.Lock()
+	txn.State = TxnCommitted
+	bp.txnMux.Unlock()
+	return bp.endTxn(txn)
+}
+
+// AbortTxn undoes the changes of the txn in reverse order, writing a compensation record for each undone update
+func (bp *BuffPoolMgrStr) AbortTxn(txn *Txn) (abortErr error) {
+	if bp.closed.Load() {
+		return ErrClosed
+	}
+	if txn.State != TxnActive {
+		return fmt.Errorf("%w: txn %d", ErrTxnNotActive, txn.TxnId)
+	}
+	abortLsn, abortErr := bp.logMgr.AppendLogRecord(&logmgr.LogRecord{RecType: logmgr.LogAbort, TxnId: txn.TxnId, PrevLsn: txn.prevLsn})
+	if abortErr != nil {
+		return abortErr
+	}
+	bp.setTxnLsn(txn, abortLsn)
+	for i := len(txn.updateRecs) - 1; i >= 0; i-- {
+		clrLsn, undoErr := bp.undoUpdate(txn.updateRecs[i], txn.prevLsn)
+		if undoErr != nil {
+			return undoErr
+		}
+		bp.setTxnLsn(txn, clrLsn)
+		txn.updateRecs = txn.updateRecs[:i]
+	}
+	bp.txnMux.Lock()
+	txn.State = TxnAborted
+	bp.txnMux.Unlock()
+	return bp.endTxn(txn)
+}
+
+func (bp *BuffPoolMgrStr) endTxn(txn *Txn) (endErr error) {
+	bp.txnMux.Lock()
+	defer bp.txnMux.Unlock()
+
+	if _, endErr = bp.logMgr.AppendLogRecord(&logmgr.LogRecord{RecType: logmgr.LogEnd, TxnId: txn.TxnId, PrevLsn: txn.prevLsn}); endErr != nil {
+		return endErr
+	}
+	delete(bp.activeTxns, txn.TxnId)
+	return nil
+}
+
+// setTxnLsn moves the last lsn of the txn under the txn lock, checkpoints read it from other goroutines
+func (bp *BuffPoolMgrStr) setTxnLsn(txn *Txn, lsn int64) {
+	bp.txnMux.Lock()
+	defer bp.txnMux.Unlock()
+	txn.prevLsn = lsn
+}
+
+/*
+undoUpdate puts the before image of the update record back on the page and logs it as a compensation record.
+the compensation record is redo only, its UndoNextLsn skips over the undone update so that a rollback is never undone twice.
+*/
+func (bp *BuffPoolMgrStr) undoUpdate(updateRec *logmgr.LogRecord, prevLsn int64) (clrLsn int64, undoErr error) {
+	page, fetchErr := bp.fetchPage(updateRec.PageId, true, nil)
+	if fetchErr != nil {
+		return logmgr.InvalidLsn, fetchErr
+	}
+	defer bp.UnpinPage(updateRec.PageId)
+	page.pageMux.Lock()
+	defer page.pageMux.Unlock()
+
+	page.beginDirty(bp.logMgr.GetLastLsn() + 1)
+	clrLsn, undoErr = bp.logMgr.AppendLogRecord(&logmgr.LogRecord{
+		RecType:     logmgr.LogCompensate,
+		TxnId:       updateRec.TxnId,
+		PrevLsn:     prevLsn,
+		PageId:      updateRec.PageId,
+		Offset:      updateRec.Offset,
+		UndoNextLsn: updateRec.PrevLsn,
+		AfterImage:  updateRec.BeforeImage,
+	})
+	if undoErr != nil {
+		return logmgr.InvalidLsn, undoErr
+	}
+	copy(page.pageData[updateRec.Offset:], updateRec.BeforeImage)
+	page.markDirty(clrLsn)
+	return clrLsn, nil
+}
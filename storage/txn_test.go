@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rohithputha/HymStMgr/constants"
+	"github.com/rohithputha/HymStMgr/diskmgr/disktest"
+)
+
+func TestWritePageData(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
+	newPage, _ := bfrPool.NewPage()
+	txn, _ := bfrPool.BeginTxn()
+	writeErr := bfrPool.WritePageData(txn, newPage.PageId, 100, []byte{7, 8, 9})
+	if writeErr != nil || newPage.pageData[101] != 8 || !newPage.IsDirty || newPage.PageLSN != bfrPool.logMgr.GetLastLsn() {
+		test.Errorf("write page data not working as expected")
+	}
+	if newPage.Pin != 0 {
+		test.Errorf("write page data does not unpin the page")
+	}
+}
+
+func TestWritePageDataHeader(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
+	newPage, _ := bfrPool.NewPage()
+	txn, _ := bfrPool.BeginTxn()
+	if err := bfrPool.WritePageData(txn, newPage.PageId, constants.PageHeaderSize-1, []byte{1}); !errors.Is(err, ErrOutOfRange) {
+		test.Errorf("write page data allowed a write into the page header")
+	}
+	if err := bfrPool.WritePageData(txn, newPage.PageId, constants.PageSize-1, []byte{1, 2}); !errors.Is(err, ErrOutOfRange) {
+		test.Errorf("write page data allowed a write past the page end")
+	}
+}
+
+func TestCommitTxn(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
+	newPage, _ := bfrPool.NewPage()
+	txn, _ := bfrPool.BeginTxn()
+	bfrPool.WritePageData(txn, newPage.PageId, 100, []byte{7})
+	commitErr := bfrPool.CommitTxn(txn)
+	if commitErr != nil || txn.State != TxnCommitted || bfrPool.logMgr.GetFlushedLsn() < newPage.PageLSN {
+		test.Errorf("commit txn not working as expected")
+	}
+	if _, ok := bfrPool.activeTxns[txn.TxnId]; ok {
+		test.Errorf("committed txn still in the active txn table")
+	}
+	if err := bfrPool.WritePageData(txn, newPage.PageId, 100, []byte{8}); !errors.Is(err, ErrTxnNotActive) {
+		test.Errorf("write allowed on a committed txn")
+	}
+	if err := bfrPool.CommitTxn(txn); !errors.Is(err, ErrTxnNotActive) {
+		test.Errorf("second commit of a txn does not return ErrTxnNotActive")
+	}
+	if err := bfrPool.AbortTxn(txn); !errors.Is(err, ErrTxnNotActive) {
+		test.Errorf("abort of a committed txn does not return ErrTxnNotActive")
+	}
+}
+
+func TestAbortTxn(test *testing.T) {
+	bfrPool := getTestPool(test, disktest.GetFileInit(test), Options{})
+	newPage, _ := bfrPool.NewPage()
+	txn1, _ := bfrPool.BeginTxn()
+	bfrPool.WritePageData(txn1, newPage.PageId, 100, []byte{1, 1})
+	bfrPool.CommitTxn(txn1)
+
+	txn2, _ := bfrPool.BeginTxn()
+	bfrPool.WritePageData(txn2, newPage.PageId, 100, []byte{2})
+	bfrPool.WritePageData(txn2, newPage.PageId, 101, []byte{3})
+	abortErr := bfrPool.AbortTxn(txn2)
+	if abortErr != nil || txn2.State != TxnAborted || newPage.pageData[100] != 1 || newPage.pageData[101] != 1 {
+		test.Errorf("abort txn not working as expected")
+	}
+}